@@ -0,0 +1,263 @@
+package thinknum
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mehiX/thinknumV2/internal/metrics"
+	"github.com/mehiX/thinknumV2/internal/query"
+)
+
+// Batch A sub-search on part of the tickers of a search
+type Batch struct {
+	Index   int      `json:"index"`
+	Tickers []string `json:"tickers"`
+	// Rows The record offsets of the batch in the merged results, from the offset of its first record to that offset plus its Total.
+	// The offsets are the ones of the missing ranges, so a missing range within Rows belongs to the batch
+	Rows Range `json:"rows"`
+	// Fetched Number of rows of the batch in the outputs, after the rows of the previous batches. Less than Total if some are missing
+	Fetched int    `json:"fetched"`
+	Total   int    `json:"total"`
+	Pages   int    `json:"pages"`
+	Error   string `json:"error,omitempty"`
+	// TickerRows The rows of every ticker of the batch, as ranges of record offsets like Rows, when the search has a ticker_column.
+	// A ticker without rows has an empty list. Rows are matched on the full ticker or on its symbol, e.g. `aapl`
+	TickerRows map[string][]Range `json:"ticker_rows,omitempty"`
+	// Request The request sent for the batch, recorded with the missing ranges of partial results
+	Request query.Request `json:"-"`
+}
+
+// writeBatches Writes the sidecar file listing the tickers of every batch and the rows they returned in the outputs.
+// The rows appended to incremental outputs are only part of the files, every run writes its own sidecar, e.g. `out/jobs.20210305T063000Z.batches.json`
+func writeBatches(sr SearchResult) (string, error) {
+
+	b, err := json.MarshalIndent(sr.Batches, "", "    ")
+	if err != nil {
+		return "", err
+	}
+
+	ext := "batches.json"
+	if sr.Watermark != "" {
+		ext = newOutputLayout(sr.Search, sr.Started).values["run_time"] + "." + ext
+	}
+
+	return writeSidecar(sr, ext, b)
+}
+
+// batchSize Returns the number of tickers per batch for the search. No batching if 0
+func (c *client) batchSize(sd SearchDefinition) int {
+	if sd.TickerBatchSize > 0 {
+		return sd.TickerBatchSize
+	}
+	return c.TickerBatchSize
+}
+
+// tickerBatches Splits the search in sub-searches of at most `size` tickers each.
+// Returns nil if the search doesn't need to be split
+func (s SearchDefinition) tickerBatches(size int) []SearchDefinition {
+
+	tickers := s.Request.Tickers
+	if size <= 0 || len(tickers) <= size {
+		return nil
+	}
+
+	n := (len(tickers) + size - 1) / size
+
+	batches := make([]SearchDefinition, 0, n)
+	for i := 0; i < n; i++ {
+		end := (i + 1) * size
+		if end > len(tickers) {
+			end = len(tickers)
+		}
+
+		b := s.Clone()
+		b.Name = fmt.Sprintf("%s [batch %d/%d]", s.Name, i+1, n)
+		b.Request.Tickers = append([]string{}, tickers[i*size:end]...)
+		batches = append(batches, b)
+	}
+
+	return batches
+}
+
+// batchMerge Collects the results of the batches of a search, which can finish in any order
+type batchMerge struct {
+	mu      sync.Mutex
+	sr      SearchResult
+	started bool
+	batches []SearchDefinition
+	results []query.RunResult
+	stats   runStats
+	pending int
+}
+
+func newBatchMerge(sr SearchResult, batches []SearchDefinition) *batchMerge {
+	return &batchMerge{
+		sr:      sr,
+		batches: batches,
+		results: make([]query.RunResult, len(batches)),
+		pending: len(batches),
+	}
+}
+
+// start Records that a batch starts running: the search starts with its first batch, not when it was split
+func (m *batchMerge) start() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.started {
+		m.sr.Started, m.started = time.Now(), true
+	}
+}
+
+// add Records the result of batch `i`. Returns true when it was the last batch
+func (m *batchMerge) add(i int, res query.RunResult, stats *runStats) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.results[i] = res
+	m.stats.retries += stats.retries
+	m.stats.timeouts += stats.timeouts
+	m.pending--
+
+	return m.pending == 0
+}
+
+// merge Puts the rows of all the batches together, in the order of the batches.
+// The offsets of every batch are shifted after the previous batches so that the missing ranges stay meaningful
+func (m *batchMerge) merge() (SearchResult, *runStats) {
+
+	sr := m.sr
+	var data query.RowsItems
+
+	for i, res := range m.results {
+		b := Batch{
+			Index:   i + 1,
+			Tickers: m.batches[i].Request.Tickers,
+			Rows:    Range{Start: data.Total, End: data.Total + res.Data.Total},
+			Fetched: len(res.Data.Rows),
+			Total:   res.Data.Total,
+			Pages:   res.Data.Pages,
+		}
+		// the batch already ran, so its request builds
+		b.Request, _ = m.batches[i].BuildRequest()
+		if col := columnIndex(res.Data, sr.Search.TickerColumn); col >= 0 {
+			b.TickerRows = tickerRows(res.Data, col, b.Tickers, data.Total)
+		}
+
+		if res.Error != nil {
+			b.Error = res.Error.Error()
+			if sr.Error == nil {
+				sr.Error = fmt.Errorf("batch %d/%d: %w", i+1, len(m.results), res.Error)
+			}
+		}
+
+		if len(data.Fields) == 0 {
+			data.Fields = res.Data.Fields
+		}
+		for _, f := range res.Data.Fetched {
+			data.Fetched = append(data.Fetched, Range{Start: data.Total + f.Start, End: data.Total + f.End})
+		}
+		data.Rows = append(data.Rows, res.Data.Rows...)
+		data.Total += res.Data.Total
+		data.Pages += res.Data.Pages
+
+		sr.Batches = append(sr.Batches, b)
+	}
+
+	sr.Data = data
+
+	return sr, &m.stats
+}
+
+// tickerRows Returns the ranges of record offsets of every ticker, shifted by `shift`. See Batch.TickerRows
+func tickerRows(d query.RowsItems, col int, tickers []string, shift int) map[string][]Range {
+
+	byValue := make(map[string]string, 2*len(tickers))
+	ranges := make(map[string][]Range, len(tickers))
+	for _, t := range tickers {
+		ranges[t] = []Range{}
+		byValue[strings.ToLower(t)] = t
+		if i := strings.LastIndex(t, ":"); i >= 0 {
+			byValue[strings.ToLower(t[i+1:])] = t
+		}
+	}
+
+	// the rows are in the order of their offsets
+	var offsets []int
+	for _, f := range d.Fetched {
+		for o := f.Start; o < f.End; o++ {
+			offsets = append(offsets, o)
+		}
+	}
+
+	for i, r := range d.Rows {
+		if col >= len(r) {
+			continue
+		}
+		t, ok := byValue[strings.ToLower(fmt.Sprint(r[col]))]
+		if !ok {
+			continue
+		}
+		n := shift + i
+		if len(offsets) == len(d.Rows) {
+			n = shift + offsets[i]
+		}
+		if l := ranges[t]; len(l) > 0 && l[len(l)-1].End == n {
+			l[len(l)-1].End = n + 1
+			continue
+		}
+		ranges[t] = append(ranges[t], Range{Start: n, End: n + 1})
+	}
+
+	return ranges
+}
+
+// runBatches Runs the batches of a search in parallel, at most `Workers` at a time, and merges their results
+func (c *client) runBatches(sr SearchResult, batches []SearchDefinition) SearchResult {
+
+	c.searchLog(sr.Search).Info("Search split by tickers", "tickers", len(sr.Search.Request.Tickers), "batches", len(batches))
+
+	m := newBatchMerge(sr, batches)
+
+	workers := c.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	slots := make(chan struct{}, workers)
+
+	var wg sync.WaitGroup
+	for i, b := range batches {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int, b SearchDefinition) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			m.start()
+			stats := &runStats{}
+			m.add(i, c.runSearch(b, stats), stats)
+		}(i, b)
+	}
+	wg.Wait()
+
+	merged, stats := m.merge()
+	return c.finish(merged, stats)
+}
+
+// runBatch Runs one batch of a search in a worker of RunAll. The merged result is sent after the last batch
+func (c *client) runBatch(t task, results chan<- SearchResult) {
+
+	t.merge.start()
+	c.Metrics.Add(metrics.WorkersBusy, 1)
+	stats := &runStats{}
+	res := c.runSearch(t.search, stats)
+	c.Metrics.Add(metrics.WorkersBusy, -1)
+
+	if t.merge.add(t.batch, res, stats) {
+		merged, stats := t.merge.merge()
+		results <- c.finish(merged, stats)
+	}
+}
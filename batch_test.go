@@ -0,0 +1,149 @@
+package thinknum
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mehiX/thinknumV2/internal/query"
+)
+
+func TestBatches(t *testing.T) {
+
+	sd := SearchDefinition{Name: "jobs", Request: query.Request{Tickers: []string{"a", "b", "c", "d", "e"}}}
+
+	if b := sd.tickerBatches(5); b != nil {
+		t.Errorf("Expected no batches when all the tickers fit in one: %v", b)
+	}
+
+	batches := sd.tickerBatches(2)
+	if len(batches) != 3 || batches[2].Name != "jobs [batch 3/3]" || !reflect.DeepEqual(batches[2].Request.Tickers, []string{"e"}) {
+		t.Fatalf("Wrong batches: %+v", batches)
+	}
+
+	m := newBatchMerge(SearchResult{Search: sd}, batches)
+
+	rows := func(n int) []query.Row { return make([]query.Row, n) }
+	results := []query.RunResult{
+		{Data: query.RowsItems{RowItemsMetadata: query.RowItemsMetadata{Total: 3, Pages: 1, Fetched: []Range{{Start: 0, End: 3}}}, Rows: rows(3)}},
+		{Data: query.RowsItems{RowItemsMetadata: query.RowItemsMetadata{Total: 4, Pages: 1, Fetched: []Range{{Start: 0, End: 2}}}, Rows: rows(2)}, Error: errors.New("boom")},
+		{Data: query.RowsItems{RowItemsMetadata: query.RowItemsMetadata{Total: 1, Pages: 1, Fetched: []Range{{Start: 0, End: 1}}}, Rows: rows(1)}},
+	}
+
+	// the batches finish in any order
+	for _, i := range []int{2, 0, 1} {
+		last := m.add(i, results[i], &runStats{retries: 1})
+		if last != (i == 1) {
+			t.Errorf("Batch %d: wrong last flag %v", i, last)
+		}
+	}
+
+	sr, stats := m.merge()
+
+	if sr.Error == nil || stats.retries != 3 {
+		t.Errorf("Expected the error of batch 2 and 3 retries. Got: %v, %d", sr.Error, stats.retries)
+	}
+	if len(sr.Data.Rows) != 6 || sr.Data.Total != 8 || sr.Data.Pages != 3 {
+		t.Errorf("Wrong merged data: rows %d, total %d, pages %d", len(sr.Data.Rows), sr.Data.Total, sr.Data.Pages)
+	}
+	if missing := sr.Data.Missing(); !reflect.DeepEqual(missing, []Range{{Start: 5, End: 7}}) {
+		t.Errorf("Wrong missing ranges: %v", missing)
+	}
+	if sr.Batches[1].Error != "boom" {
+		t.Errorf("Wrong batch error: %+v", sr.Batches[1])
+	}
+
+	// the batches and the missing ranges use the same offsets: the missing range is in the second batch
+	expected := []struct {
+		rows    Range
+		fetched int
+	}{
+		{Range{Start: 0, End: 3}, 3},
+		{Range{Start: 3, End: 7}, 2},
+		{Range{Start: 7, End: 8}, 1},
+	}
+	for i, e := range expected {
+		if b := sr.Batches[i]; b.Rows != e.rows || b.Fetched != e.fetched {
+			t.Errorf("Wrong provenance of batch %d. Expected: %v, %d rows, got: %v, %d rows", i+1, e.rows, e.fetched, b.Rows, b.Fetched)
+		}
+	}
+
+	// the partial results list the missing ranges of the failed batch, with the tickers it was sent with
+	sr.Search.OutputFile = filepath.Join(t.TempDir(), "jobs")
+	fn, err := writeMissing(sr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var missing MissingRanges
+	if b, err := ioutil.ReadFile(fn); err != nil || json.Unmarshal(b, &missing) != nil {
+		t.Fatalf("Cannot read %s: %v", fn, err)
+	}
+	if len(missing.Batches) != 1 {
+		t.Fatalf("Expected only the failed batch, got: %+v", missing.Batches)
+	}
+	if b := missing.Batches[0]; b.Index != 2 || !reflect.DeepEqual(b.Missing, []Range{{Start: 5, End: 7}}) || !reflect.DeepEqual(b.Request.Tickers, []string{"c", "d"}) {
+		t.Errorf("Wrong missing ranges of the batch: %+v", b)
+	}
+}
+
+func TestWriteBatchesIncremental(t *testing.T) {
+
+	dir := t.TempDir()
+	sr := SearchResult{
+		Search:    SearchDefinition{Name: "jobs", OutputFile: filepath.Join(dir, "jobs")},
+		Started:   time.Date(2021, 3, 5, 6, 30, 0, 0, time.UTC),
+		Batches:   []Batch{{Index: 1, Tickers: []string{"a"}}},
+		Watermark: "2021-03-04",
+	}
+
+	fn, err := writeBatches(sr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := filepath.Join(dir, "jobs.20210305T063000Z.batches.json"); fn != expected {
+		t.Errorf("Wrong sidecar of an incremental run. Expected: %s, got: %s", expected, fn)
+	}
+
+	sr.Watermark = ""
+	if fn, _ := writeBatches(sr); fn != filepath.Join(dir, "jobs.batches.json") {
+		t.Errorf("Wrong sidecar: %s", fn)
+	}
+}
+
+func TestTickerRows(t *testing.T) {
+
+	var d query.RowsItems
+	d.Rows = []query.Row{{"AAPL", 1}, {"AAPL", 2}, {"ibm", 3}, {"AAPL", 4}, {"MSFT", 5}}
+	// the third record is missing
+	d.Fetched = []Range{{Start: 0, End: 2}, {Start: 3, End: 6}}
+
+	got := tickerRows(d, 0, []string{"nasdaq:aapl", "nyse:ibm", "nasdaq:tsla"}, 10)
+	expected := map[string][]Range{
+		"nasdaq:aapl": {{Start: 10, End: 12}, {Start: 14, End: 15}},
+		"nyse:ibm":    {{Start: 13, End: 14}},
+		"nasdaq:tsla": {},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Wrong rows per ticker. Expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestBatchStarted(t *testing.T) {
+
+	prepared := time.Now().Add(-time.Hour)
+	m := newBatchMerge(SearchResult{Started: prepared}, []SearchDefinition{{}, {}})
+
+	m.start()
+	first := m.sr.Started
+	if !first.After(prepared) {
+		t.Errorf("Expected the search to start with its first batch, got: %v", first)
+	}
+	m.start()
+	if !m.sr.Started.Equal(first) {
+		t.Errorf("Expected the start of the first batch to be kept, got: %v", m.sr.Started)
+	}
+}
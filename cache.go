@@ -0,0 +1,55 @@
+package thinknum
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mehiX/thinknumV2/internal/cache"
+)
+
+// Cache The pages of results stored on disk
+type Cache = cache.Cache
+
+// CacheEntry One page stored in the cache
+type CacheEntry = cache.Entry
+
+// CacheConfig Where the pages of results are cached on disk. The cache is disabled if Dir is empty
+type CacheConfig struct {
+	Dir string `json:"dir"`
+	// TTL How long a cached page is used, e.g. "12h". Pages never expire if empty
+	TTL string `json:"ttl"`
+	// MaxSizeMB The oldest pages are removed when the cache grows larger. No limit if 0
+	MaxSizeMB int64 `json:"max_size_mb"`
+}
+
+// Enabled Checks if a cache directory is configured
+func (cc CacheConfig) Enabled() bool {
+	return cc.Dir != ""
+}
+
+// ttl Parses the TTL of the cache
+func (cc CacheConfig) ttl() (time.Duration, error) {
+	if cc.TTL == "" {
+		return 0, nil
+	}
+
+	d, err := time.ParseDuration(cc.TTL)
+	if err != nil {
+		return 0, fmt.Errorf("cache ttl: %w", err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("cache ttl: should not be negative: %s", cc.TTL)
+	}
+
+	return d, nil
+}
+
+// OpenCache Opens the cache directory configured in `cc`, creating it if needed
+func OpenCache(cc CacheConfig) (*Cache, error) {
+	ttl, err := cc.ttl()
+	if err != nil {
+		return nil, err
+	}
+
+	return cache.New(cc.Dir, ttl, cc.MaxSizeMB*1024*1024)
+}
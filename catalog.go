@@ -0,0 +1,297 @@
+package thinknum
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mehiX/thinknumV2/internal/query"
+)
+
+// Kinds of catalogs
+const (
+	CatalogDatasets = "datasets"
+	CatalogTickers  = "tickers"
+)
+
+// Catalog A snapshot of the list of datasets or of the tickers of a dataset
+type Catalog struct {
+	Kind string `json:"kind"`
+	// The dataset of a tickers catalog
+	Dataset string `json:"dataset,omitempty"`
+	// The ticker used to filter a datasets catalog
+	Ticker  string         `json:"ticker,omitempty"`
+	Fetched time.Time      `json:"fetched"`
+	Entries []CatalogEntry `json:"entries"`
+}
+
+// CatalogEntry A dataset or a ticker. Only the fields returned for its kind are set
+type CatalogEntry struct {
+	ID          string `json:"id"`
+	DisplayName string `json:"display_name"`
+	Sector      string `json:"sector,omitempty"`
+	Industry    string `json:"industry,omitempty"`
+	Country     string `json:"country,omitempty"`
+	State       string `json:"state,omitempty"`
+	Summary     string `json:"summary,omitempty"`
+}
+
+// NewDatasetCatalog Returns a catalog of the datasets, fetched now
+func NewDatasetCatalog(ticker string, items []query.DatasetItem) Catalog {
+	c := Catalog{Kind: CatalogDatasets, Ticker: ticker, Fetched: time.Now()}
+	for _, d := range items {
+		c.Entries = append(c.Entries, CatalogEntry{
+			ID:          d.ID,
+			DisplayName: d.DisplayName,
+			State:       d.State,
+			Summary:     d.Summary,
+		})
+	}
+	c.sort()
+	return c
+}
+
+// NewTickerCatalog Returns a catalog of the tickers of a dataset, fetched now
+func NewTickerCatalog(dataset string, items []query.TickerItem) Catalog {
+	c := Catalog{Kind: CatalogTickers, Dataset: dataset, Fetched: time.Now()}
+	for _, t := range items {
+		c.Entries = append(c.Entries, CatalogEntry{
+			ID:          t.ID,
+			DisplayName: t.DisplayName,
+			Sector:      t.Sector,
+			Industry:    t.Industry,
+			Country:     t.Country,
+		})
+	}
+	c.sort()
+	return c
+}
+
+func (c *Catalog) sort() {
+	sort.Slice(c.Entries, func(i, j int) bool { return c.Entries[i].ID < c.Entries[j].ID })
+}
+
+// CatalogPath Returns the file where the catalog is stored in `dir`, e.g. `dir/datasets.json` or `dir/tickers_job_listings.json`
+func CatalogPath(dir string, c Catalog) string {
+	name := c.Kind
+	switch {
+	case c.Kind == CatalogTickers:
+		name += "_" + c.Dataset
+	case c.Ticker != "":
+		name += "_" + c.Ticker
+	}
+
+	// tickers contain colons, e.g. nasdaq:aapl
+	name = strings.NewReplacer(":", "_", "/", "_", "\\", "_").Replace(name)
+
+	return filepath.Join(dir, name+".json")
+}
+
+// LoadCatalog Loads a catalog stored with SaveCatalog
+func LoadCatalog(fn string) (Catalog, error) {
+	var c Catalog
+
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return c, err
+	}
+
+	err = json.Unmarshal(b, &c)
+	return c, err
+}
+
+// SaveCatalog Stores the catalog in `dir`, replacing the previous snapshot. Returns the path of the file
+func SaveCatalog(dir string, c Catalog) (string, error) {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return "", err
+	}
+
+	b, err := json.MarshalIndent(c, "", "    ")
+	if err != nil {
+		return "", err
+	}
+
+	fn := CatalogPath(dir, c)
+	return fn, writeFile(fn, b)
+}
+
+// CatalogStore Keeps the latest snapshot of every catalog in a directory
+type CatalogStore struct {
+	Dir string
+	// MaxAge A stored snapshot younger than this is used instead of fetching the catalog again. Always fetch if 0
+	MaxAge time.Duration
+}
+
+// Get Returns the catalog identified by the kind, dataset and ticker of `key`.
+// The stored snapshot is returned if it is recent enough, unless `refresh` is set. Otherwise the catalog is fetched
+// with `fetch` and stored, replacing the previous snapshot, which is returned too (with a zero Fetched time if there was none)
+func (s CatalogStore) Get(key Catalog, refresh bool, fetch func() (Catalog, error)) (current, previous Catalog, err error) {
+
+	fn := CatalogPath(s.Dir, key)
+
+	previous, err = LoadCatalog(fn)
+	if err != nil && !os.IsNotExist(err) {
+		return current, previous, fmt.Errorf("cannot load %s: %w", fn, err)
+	}
+
+	if err == nil && !refresh && s.MaxAge > 0 && time.Since(previous.Fetched) < s.MaxAge {
+		return previous, previous, nil
+	}
+
+	current, err = fetch()
+	if err != nil {
+		return current, previous, err
+	}
+
+	_, err = SaveCatalog(s.Dir, current)
+
+	return current, previous, err
+}
+
+// Search Returns the entries with `pattern` in their ID, display name, sector, industry or country.
+// The pattern is a case insensitive substring, or a regular expression if `regex` is set
+func (c Catalog) Search(pattern string, regex bool) (Catalog, error) {
+
+	match := func(s string) bool {
+		return strings.Contains(strings.ToLower(s), strings.ToLower(pattern))
+	}
+	if regex {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return c, err
+		}
+		match = re.MatchString
+	}
+
+	found := c
+	found.Entries = nil
+	for _, e := range c.Entries {
+		for _, f := range []string{e.ID, e.DisplayName, e.Sector, e.Industry, e.Country} {
+			if f != "" && match(f) {
+				found.Entries = append(found.Entries, e)
+				break
+			}
+		}
+	}
+
+	return found, nil
+}
+
+// DiffCatalogs Returns the entries of `current` that are not in `previous` and the entries of `previous` that are not in `current`
+func DiffCatalogs(previous, current Catalog) (added, removed []CatalogEntry) {
+
+	ids := func(c Catalog) map[string]bool {
+		m := make(map[string]bool, len(c.Entries))
+		for _, e := range c.Entries {
+			m[e.ID] = true
+		}
+		return m
+	}
+
+	prev, cur := ids(previous), ids(current)
+
+	for _, e := range current.Entries {
+		if !prev[e.ID] {
+			added = append(added, e)
+		}
+	}
+	for _, e := range previous.Entries {
+		if !cur[e.ID] {
+			removed = append(removed, e)
+		}
+	}
+
+	return added, removed
+}
+
+// WriteCatalogDiff Writes the entries added and removed since the previous snapshot, as a table, as CSV or as JSON
+func WriteCatalogDiff(w io.Writer, previous, current Catalog, added, removed []CatalogEntry, format string) error {
+
+	header, values := current.columns()
+	header = append([]string{"change"}, header...)
+
+	rows := make([][]string, 0, len(added)+len(removed))
+	for _, e := range added {
+		rows = append(rows, append([]string{"added"}, values(e)...))
+	}
+	for _, e := range removed {
+		rows = append(rows, append([]string{"removed"}, values(e)...))
+	}
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "    ")
+		return enc.Encode(struct {
+			Previous time.Time      `json:"previous"`
+			Current  time.Time      `json:"current"`
+			Added    []CatalogEntry `json:"added"`
+			Removed  []CatalogEntry `json:"removed"`
+		}{previous.Fetched, current.Fetched, added, removed})
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write(header)
+		cw.WriteAll(rows)
+		return cw.Error()
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.ToUpper(strings.Join(header, "\t")))
+		for _, r := range rows {
+			fmt.Fprintln(tw, strings.Join(r, "\t"))
+		}
+		return tw.Flush()
+	}
+
+	return fmt.Errorf("unknown format %q, expected table, csv or json", format)
+}
+
+// columns Returns the header and the values of the columns that make sense for the kind of catalog
+func (c Catalog) columns() ([]string, func(CatalogEntry) []string) {
+	if c.Kind == CatalogTickers {
+		return []string{"id", "display_name", "sector", "industry", "country"}, func(e CatalogEntry) []string {
+			return []string{e.ID, e.DisplayName, e.Sector, e.Industry, e.Country}
+		}
+	}
+	return []string{"id", "display_name", "state"}, func(e CatalogEntry) []string {
+		return []string{e.ID, e.DisplayName, e.State}
+	}
+}
+
+// WriteCatalog Writes the entries of the catalog as a table, as CSV or as JSON
+func WriteCatalog(w io.Writer, c Catalog, format string) error {
+
+	header, values := c.columns()
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "    ")
+		return enc.Encode(c)
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write(header)
+		for _, e := range c.Entries {
+			cw.Write(values(e))
+		}
+		cw.Flush()
+		return cw.Error()
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.ToUpper(strings.Join(header, "\t")))
+		for _, e := range c.Entries {
+			fmt.Fprintln(tw, strings.Join(values(e), "\t"))
+		}
+		return tw.Flush()
+	}
+
+	return fmt.Errorf("unknown format %q, expected table, csv or json", format)
+}
@@ -0,0 +1,59 @@
+package thinknum
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mehiX/thinknumV2/internal/query"
+)
+
+func TestCatalog(t *testing.T) {
+
+	dir := t.TempDir()
+	store := CatalogStore{Dir: dir, MaxAge: time.Hour}
+	key := Catalog{Kind: CatalogTickers, Dataset: "job_listings"}
+
+	fetches := 0
+	items := []query.TickerItem{
+		{ID: "nasdaq:aapl", DisplayName: "Apple", Sector: "Technology", Country: "US"},
+		{ID: "nyse:ibm", DisplayName: "IBM", Sector: "Technology", Country: "US"},
+	}
+	fetch := func() (Catalog, error) {
+		fetches++
+		return NewTickerCatalog("job_listings", items), nil
+	}
+
+	first, previous, err := store.Get(key, false, fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !previous.Fetched.IsZero() || len(first.Entries) != 2 {
+		t.Fatalf("Expected a new catalog with 2 entries. Got: %+v, previous: %+v", first, previous)
+	}
+
+	// the stored snapshot is recent enough
+	if _, _, err := store.Get(key, false, fetch); err != nil || fetches != 1 {
+		t.Fatalf("Expected the stored catalog to be used. Fetches: %d, error: %v", fetches, err)
+	}
+
+	items = append(items[1:], query.TickerItem{ID: "nasdaq:msft", DisplayName: "Microsoft"})
+	current, previous, err := store.Get(key, true, fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	added, removed := DiffCatalogs(previous, current)
+	if len(added) != 1 || added[0].ID != "nasdaq:msft" || len(removed) != 1 || removed[0].ID != "nasdaq:aapl" {
+		t.Errorf("Wrong diff. Added: %v, removed: %v", added, removed)
+	}
+
+	found, err := current.Search("MICRO", false)
+	if err != nil || len(found.Entries) != 1 || found.Entries[0].ID != "nasdaq:msft" {
+		t.Errorf("Wrong substring search: %v, %v", found.Entries, err)
+	}
+
+	found, err = current.Search("^nyse:", true)
+	if err != nil || len(found.Entries) != 1 || found.Entries[0].ID != "nyse:ibm" {
+		t.Errorf("Wrong regex search: %v, %v", found.Entries, err)
+	}
+}
@@ -2,7 +2,10 @@ package thinknum
 
 import (
 	"fmt"
+	"sync"
+	"time"
 
+	"github.com/mehiX/thinknumV2/internal/metrics"
 	"github.com/mehiX/thinknumV2/internal/query"
 )
 
@@ -11,34 +14,73 @@ type Client interface {
 	Datasets(string) ([]query.DatasetItem, error)
 	Tickers(string) ([]query.TickerItem, error)
 	RunSearch(SearchDefinition) query.RunResult
+	Preview(SearchDefinition, int) query.RunResult
+	Count(SearchDefinition) (int, error)
+	Run(SearchDefinition) SearchResult
 	RunAll() <-chan SearchResult
 	SaveSearchResult(SearchResult) []SaveResult
+	SavePartialResult(SearchResult) []SaveResult
 }
 
 // SearchResult Brings together the search definition and the search results
 type SearchResult struct {
 	query.RunResult
 	Search SearchDefinition
+	// For incremental searches, the watermark the results were fetched from.
+	// The results are appended to the existing outputs when it is not empty
+	Watermark string
+	// When the search started and how long it took to fetch all the results
+	Started  time.Time
+	Duration time.Duration
+	// Number of requests sent again after an error and number of gateway timeouts waited for
+	Retries  int
+	Timeouts int
+	// A problem that didn't stop the search, e.g. an *IncompleteError when fewer rows than announced were fetched
+	Warning error
+	// For searches split by tickers, the tickers of every batch and where its rows are in the results
+	Batches []Batch
+	// The request sent to the API: the request of the search with the tickers of its universe, its where expression
+	// and the watermark filter. The batches of a search split by tickers send it with their own tickers
+	Request query.Request
 }
 
 // SaveResult The result of a save results operation. The Error will be not nil if there was an error during the process
-// For the same search there will be one SaveResult per specified OutputType
+// For the same search there will be one SaveResult per specified OutputType, or one per file for partitioned or sliced outputs
 type SaveResult struct {
 	Search SearchDefinition
 	// As specified by the OutputType in the search definition
 	Type  string
 	Error error
+	// The file written, its size and its SHA-256 checksum. Only set when there is no error
+	Path   string
+	Size   int64
+	SHA256 string
+	// Written Number of bytes written by this save: the size of the file, or of the rows appended to it
+	Written int64
+	// URI The object the file was uploaded to, e.g. `s3://bucket/out/jobs.csv`, when an upload is configured
+	URI string
+	// UploadError Why the file could not be uploaded. The file is saved nevertheless, a failed upload doesn't set Error
+	UploadError error
+	// Skipped Why no file was written, without an error, e.g. a partitioned output without rows. The files of the previous run are kept
+	Skipped string
 }
 
 type client struct {
 	Config
 	Token string
+	api   *query.API
+
+	stateOnce sync.Once
+	state     *State
+	stateErr  error
+
+	catalogs tickerCatalogs
 }
 
 // NewClientFromJSON Returns a new client for the Thinknum API. It will contain a valid token based on the received credentials
 func NewClientFromJSON(configFile string) (Client, error) {
 
-	cfg, err := ConfigFromJSON(configFile)
+	cfg, err := LoadConfig(configFile)
 	if err != nil {
 		return nil, err
 	}
@@ -52,18 +94,36 @@ func NewClientFromJSON(configFile string) (Client, error) {
 }
 
 // NewClient Create a new client providing your own configuration and token
+// Pages are cached if a cache directory is configured, unless NoCache is set. The client runs without a cache if the directory can't be opened
 func NewClient(cfg *Config, token *AuthToken) Client {
-	return &client{
+	c := &client{
 		Config: *cfg,
 		Token:  token.Token,
+		api: &query.API{
+			Hostname: cfg.Hostname,
+			Version:  cfg.Version,
+			Token:    token.Token,
+			Log:      cfg.log(),
+			Metrics:  cfg.Metrics,
+		},
+	}
+
+	if cfg.Cache.Enabled() && !cfg.NoCache {
+		pc, err := OpenCache(cfg.Cache)
+		if err != nil {
+			cfg.log().Warn("Cannot open the cache, running without it", "dir", cfg.Cache.Dir, "error", err)
+		} else {
+			c.api.Cache = pc
+		}
 	}
 
+	return c
 }
 
 // Datasets Get a list of available datasets
 // If a tickerID is provided (is not empty) then it is used to filter the datasets
 func (c *client) Datasets(tickerID string) ([]query.DatasetItem, error) {
-	return query.Datasets(c.Hostname, c.Version, c.Token, tickerID)
+	return c.api.Datasets(tickerID)
 }
 
 // Tickers Get the list of tickers for the provided `datasetID`
@@ -71,21 +131,218 @@ func (c *client) Tickers(datasetID string) ([]query.TickerItem, error) {
 	if datasetID == "" {
 		return nil, fmt.Errorf("no dataset provided when querying for tickers")
 	}
-	return query.TickerList(c.Hostname, c.Version, c.Token, datasetID)
+	return c.api.TickerList(datasetID)
 }
 
 // RunSearch Perform a search based on the SearchDefinition supplied
 // Return a RunResult
 func (c *client) RunSearch(sd SearchDefinition) query.RunResult {
+	return c.runSearch(sd, nil)
+}
+
+// runSearch Performs the search. The number of retries and timeouts are counted in `stats`, if not nil
+func (c *client) runSearch(sd SearchDefinition, stats *runStats) query.RunResult {
+
+	log := c.searchLog(sd)
+	log.Info("Running search")
+	c.progress(sd, ProgressEvent{Kind: ProgressStarted})
+
+	req, err := sd.BuildRequest()
+	if err != nil {
+		c.progress(sd, ProgressEvent{Kind: ProgressFinished, Err: err})
+		return query.RunResult{Error: err}
+	}
+
+	progress := c.queryObserver(sd)
+	observe := func(e query.Event) {
+		if stats != nil {
+			stats.observe(e)
+		}
+		if progress != nil {
+			progress(e)
+		}
+	}
+
+	api := c.api.WithLogger(log).WithObserver(observe)
+	res := api.RunSearch(sd.DatasetID, c.PageSize, req)
+
+	if res.Error == nil && c.RefetchIncomplete && checkComplete(res.Data) != nil {
+		res.Error = api.FetchMissing(sd.DatasetID, c.PageSize, req, &res.Data)
+	}
+
+	c.Metrics.Add(metrics.RowsFetched, float64(len(res.Data.Rows)), "search", sd.Name)
+	c.Metrics.Add(metrics.PagesFetched, float64(res.Data.Pages), "search", sd.Name)
+
+	c.progress(sd, ProgressEvent{
+		Kind:  ProgressFinished,
+		Page:  res.Data.Pages,
+		Rows:  len(res.Data.Rows),
+		Total: res.Data.Total,
+		Err:   res.Error,
+	})
+
+	return res
+
+}
+
+// Preview Perform a search based on the SearchDefinition supplied but only fetch the first `rows` records
+func (c *client) Preview(sd SearchDefinition, rows int) query.RunResult {
+
+	sd, err := c.resolveUniverse(sd)
+	if err != nil {
+		return query.RunResult{Error: err}
+	}
+
+	req, err := sd.BuildRequest()
+	if err != nil {
+		return query.RunResult{Error: err}
+	}
+
+	return c.api.WithLogger(c.searchLog(sd)).RunPage(sd.DatasetID, 0, rows, req)
+}
+
+// searchLog Returns a logger that adds the search name to every message
+func (c *client) searchLog(sd SearchDefinition) Logger {
+	return c.log().With("search", sd.Name)
+}
+
+// Count Returns the number of records the search would return, without downloading them.
+// The search is counted as Run would send it: with the tickers of its universe and, for incremental searches, after the watermark
+func (c *client) Count(sd SearchDefinition) (int, error) {
+
+	sr, run, ok := c.prepare(sd)
+	if !ok {
+		return 0, sr.Error
+	}
+
+	res := c.Preview(run, 1)
+
+	return res.Data.Total, res.Error
+}
+
+// Run Runs one search the same way RunAll does.
+// Incremental searches only fetch the rows newer than the watermark recorded by the previous run.
+// Searches with more tickers than the batch size are split in sub-searches, run in parallel and merged
+func (c *client) Run(sd SearchDefinition) SearchResult {
+
+	sr, run, ok := c.prepare(sd)
+	if !ok {
+		return sr
+	}
+
+	if batches := run.tickerBatches(c.batchSize(sd)); len(batches) > 1 {
+		return c.runBatches(sr, batches)
+	}
+
+	return c.execute(sr, run)
+}
+
+// execute Runs a prepared search that is not split in batches
+func (c *client) execute(sr SearchResult, run SearchDefinition) SearchResult {
+
+	stats := &runStats{}
+	sr.RunResult = c.runSearch(run, stats)
+
+	return c.finish(sr, stats)
+}
+
+// prepare Starts the result of the search and returns the search to run, with the tickers of its universe
+// and the watermark filter of incremental searches.
+// Returns false if the search can't run, the error is in the result
+func (c *client) prepare(sd SearchDefinition) (SearchResult, SearchDefinition, bool) {
+
+	sr := SearchResult{Search: sd, Started: time.Now()}
+
+	run, err := c.resolveUniverse(sd)
+	if err != nil {
+		sr.Error = err
+		return sr, run, false
+	}
 
-	fmt.Printf("Running search: %s\n", sd.Name)
+	if sd.Incremental && !c.FullRefresh {
+		st, err := c.loadState()
+		if err != nil {
+			sr.Error = err
+			return sr, run, false
+		}
 
-	dataset := query.DatasetItem{
-		ID: sd.DatasetID,
+		if wm := st.Watermark(sd.Name); wm != "" {
+			c.searchLog(sd).Info("Incremental search", "watermark_column", sd.watermarkColumn(), "watermark", wm)
+
+			run = run.afterWatermark(wm)
+			sr.Watermark = wm
+		}
+	}
+
+	if sr.Request, err = run.BuildRequest(); err != nil {
+		sr.Error = err
+		return sr, run, false
 	}
 
-	return dataset.RunSearch(c.Hostname, c.Version, c.Token, c.PageSize, sd.Request)
+	return sr, run, true
+}
+
+// finish Checks that the results are complete and records the duration and the counts of the search
+func (c *client) finish(sr SearchResult, stats *runStats) SearchResult {
+
+	if sr.Error == nil {
+		sr.Warning = checkComplete(sr.Data)
+		if sr.Warning != nil {
+			c.searchLog(sr.Search).Warn("Incomplete results", "error", sr.Warning)
+		}
+	}
+	if sr.Error == nil && sr.Watermark != "" {
+		c.skipWritten(&sr)
+	}
+	sr.Duration = time.Since(sr.Started)
+	sr.Retries = stats.retries
+	sr.Timeouts = stats.timeouts
 
+	return sr
+}
+
+// skipWritten Removes the rows of an incremental search written by the previous run, fetched again with the rows
+// arriving late with the same watermark value
+func (c *client) skipWritten(sr *SearchResult) {
+
+	st, err := c.loadState()
+	if err != nil {
+		sr.Error = err
+		return
+	}
+
+	if n := skipSeen(&sr.Data, sr.Search.watermarkColumn(), sr.Watermark, st.SeenRows(sr.Search.Name)); n > 0 {
+		sr.Data.Total -= n
+		c.searchLog(sr.Search).Info("Rows already written skipped", "rows", n, "watermark", sr.Watermark)
+	}
+}
+
+// runStats Counts what happened while running a search
+type runStats struct {
+	retries  int
+	timeouts int
+}
+
+func (rs *runStats) observe(e query.Event) {
+	switch e.Kind {
+	case query.EventRetry:
+		rs.retries++
+	case query.EventTimeout:
+		rs.timeouts++
+	}
+}
+
+// loadState Loads the state of the incremental searches the first time it is needed
+func (c *client) loadState() (*State, error) {
+	c.stateOnce.Do(func() {
+		fn := c.StateFile
+		if fn == "" {
+			fn = defaultStateFile
+		}
+		c.state, c.stateErr = LoadState(fn)
+	})
+
+	return c.state, c.stateErr
 }
 
 // RunAll Runs all the searches defined in the configuration file
@@ -104,19 +361,130 @@ func (c *client) RunAll() <-chan SearchResult {
 
 func (c *client) SaveSearchResult(sr SearchResult) []SaveResult {
 
-	out := make(chan SaveResult)
-	results := make([]SaveResult, 0)
+	if c.Strict && IsIncomplete(sr.Warning) {
+		results := make([]SaveResult, 0)
+		for _, t := range sr.Search.OutputTypes {
+			results = append(results, SaveResult{
+				Search: sr.Search,
+				Type:   t,
+				Error:  fmt.Errorf("strict mode, not writing: %w", sr.Warning),
+			})
+		}
+		return results
+	}
+
+	results, failed := c.writeOutputs(sr)
+
+	if sr.Search.Incremental && !failed {
+		c.updateWatermark(sr)
+	}
 
+	return results
+}
+
+// SavePartialResult Saves the rows fetched by a search that failed midway, when the configuration allows it.
+// The outputs get a `.partial` suffix, e.g. `out/jobs.partial.csv`, and a sidecar file `out/jobs.partial.missing.json`
+// describes the offset ranges that are missing so they can be fetched later.
+// Nothing is saved, and nil is returned, if the search did not fail or did not fetch any row
+func (c *client) SavePartialResult(sr SearchResult) []SaveResult {
+
+	if sr.Error == nil || len(sr.Data.Rows) == 0 || c.PartialResults != PartialSave {
+		return nil
+	}
+
+	partial := sr
+	partial.Search = sr.Search.Clone()
+	partial.Search.OutputFile = withSuffix(sr.Search.OutputFile, partialSuffix)
+	// partial results are never appended to the outputs of an incremental search
+	partial.Watermark = ""
+
+	results, _ := c.writeOutputs(partial)
+
+	sidecar := SaveResult{Search: partial.Search, Type: "missing"}
+	sidecar.Path, sidecar.Error = writeMissing(partial)
+	if sidecar.Error == nil {
+		sidecar.Size, sidecar.SHA256, sidecar.Error = checksum(sidecar.Path)
+	}
+	c.uploadResult(&sidecar)
+
+	return append(results, sidecar)
+}
+
+// writeOutputs Writes the results in all the output types of the search, see saveOutputs, and uploads the files
+// Returns the results for every file written and whether any of them failed
+func (c *client) writeOutputs(sr SearchResult) ([]SaveResult, bool) {
+
+	results, failed := saveOutputs(sr, c.CreateDirs)
+
+	// the files of the output types are uploaded in parallel, once they are all saved
+	var wg sync.WaitGroup
 	for _, t := range sr.Search.OutputTypes {
+		wg.Add(1)
 		go func(t string) {
-			out <- writeToFile(sr, t)
+			defer wg.Done()
+			for i := range results {
+				if results[i].Type == t {
+					c.uploadResult(&results[i])
+				}
+			}
 		}(t)
 	}
+	wg.Wait()
 
-	for range sr.Search.OutputTypes {
-		result := <-out
-		results = append(results, result)
+	for _, t := range sr.Search.OutputTypes {
+		var err error
+		for _, r := range results {
+			if r.Type != t {
+				continue
+			}
+			if r.Error != nil && err == nil {
+				err = r.Error
+			}
+			c.Metrics.Add(metrics.BytesWritten, float64(r.Written), "search", sr.Search.Name, "type", t)
+		}
+		c.progress(sr.Search, ProgressEvent{
+			Kind:       ProgressWritten,
+			Rows:       len(sr.Data.Rows),
+			Total:      sr.Data.Total,
+			OutputType: t,
+			Err:        err,
+		})
 	}
 
-	return results
+	if len(sr.Batches) > 0 {
+		sidecar := SaveResult{Search: sr.Search, Type: "batches"}
+		sidecar.Path, sidecar.Error = writeBatches(sr)
+		if sidecar.Error == nil {
+			sidecar.Size, sidecar.SHA256, sidecar.Error = checksum(sidecar.Path)
+		}
+		c.uploadResult(&sidecar)
+		results = append(results, sidecar)
+		failed = failed || sidecar.Error != nil
+	}
+
+	return results, failed
+}
+
+// updateWatermark Records the highest watermark value of the saved results so the next run continues from there,
+// with the rows written with that value, which the next run fetches again and skips
+func (c *client) updateWatermark(sr SearchResult) {
+
+	col := sr.Search.watermarkColumn()
+	wm := maxWatermark(sr.Data, col)
+	if wm == "" || (sr.Watermark != "" && watermarkLess(wm, sr.Watermark)) {
+		return
+	}
+
+	st, err := c.loadState()
+	if err == nil {
+		seen := watermarkRows(sr.Data, col, wm)
+		if sr.Watermark != "" && watermarkEqual(wm, sr.Watermark) {
+			// only rows arriving late with the same value
+			seen = append(st.SeenRows(sr.Search.Name), seen...)
+		}
+		err = st.SetWatermark(sr.Search.Name, wm, seen)
+	}
+	if err != nil {
+		c.searchLog(sr.Search).Error("Cannot save the watermark", "watermark", wm, "error", err)
+	}
 }
@@ -1,37 +1,69 @@
 package thinknum
 
 import (
-	"fmt"
 	"sync"
+	"time"
+
+	"github.com/mehiX/thinknumV2/internal/metrics"
 )
 
+// task A search for a worker, or one batch of a search split by tickers
+type task struct {
+	// the search to run, prepared, and its result so far
+	search SearchDefinition
+	result SearchResult
+	// set for the batches of a search
+	merge *batchMerge
+	batch int
+}
+
 // RunAll Runs all the searches defined in the configuration file
 func runAllFor(c *client, resultsStream chan SearchResult) {
 
-	searchesStream := make(chan SearchDefinition)
+	tasksStream := make(chan task)
 	// generate work
 	go func() {
-		defer close(searchesStream)
+		defer close(tasksStream)
 
 		// from slice to channel
 		for _, s := range c.Searches {
 			// skip disabled seaches
-			if !s.Disabled {
-				searchesStream <- s
-			} else {
-				fmt.Printf("Skip search: %s [disabled]\n", s.Name)
+			if s.Disabled {
+				c.searchLog(s).Info("Skip disabled search")
+				continue
+			}
+
+			sr, run, ok := c.prepare(s)
+			if !ok {
+				resultsStream <- sr
+				continue
+			}
+
+			batches := run.tickerBatches(c.batchSize(s))
+			if len(batches) <= 1 {
+				tasksStream <- task{search: run, result: sr}
+				continue
+			}
+
+			// the batches of a search go through the workers like any other search
+			c.searchLog(s).Info("Search split by tickers", "tickers", len(run.Request.Tickers), "batches", len(batches))
+
+			m := newBatchMerge(sr, batches)
+			for i, b := range batches {
+				tasksStream <- task{search: b, merge: m, batch: i}
 			}
 		}
 	}()
 
 	workers := c.Workers
+	c.Metrics.Set(metrics.Workers, float64(workers))
 
 	var wg sync.WaitGroup
 	wg.Add(workers)
 
 	// start idle workers
 	for i := 0; i < workers; i++ {
-		go runner(c, searchesStream, &wg, resultsStream)
+		go runner(c, tasksStream, &wg, resultsStream)
 	}
 
 	wg.Wait()
@@ -39,11 +71,22 @@ func runAllFor(c *client, resultsStream chan SearchResult) {
 }
 
 // runner A worker that sits idle waiting for work on the incoming channel
-func runner(c *client, searches <-chan SearchDefinition, wg *sync.WaitGroup, results chan<- SearchResult) {
+func runner(c *client, tasks <-chan task, wg *sync.WaitGroup, results chan<- SearchResult) {
 	defer wg.Done()
 
-	for s := range searches {
-		ri := c.RunSearch(s)
-		results <- SearchResult{ri, s}
+	for t := range tasks {
+		if t.merge != nil {
+			c.runBatch(t, results)
+			continue
+		}
+
+		// the time spent waiting for a worker doesn't count
+		t.result.Started = time.Now()
+
+		c.Metrics.Add(metrics.WorkersBusy, 1)
+		res := c.execute(t.result, t.search)
+		c.Metrics.Add(metrics.WorkersBusy, -1)
+
+		results <- res
 	}
 }
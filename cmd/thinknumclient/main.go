@@ -3,44 +3,231 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"text/tabwriter"
 
 	thinknum "github.com/mehiX/thinknumV2"
 )
 
 var (
-	cfg = flag.String("c", "config.json", "Configuration file")
+	cfg         = flag.String("c", "config.json", "Configuration file")
+	dryRun      = flag.Bool("dry-run", false, "Only report how many rows and pages each search would return, without downloading them")
+	maxPages    = flag.Int("max-pages", thinknum.DefaultMaxPages, "dry-run: suggest to split the searches that need more pages than this")
+	fullRefresh = flag.Bool("full-refresh", false, "Fetch all the rows of incremental searches and overwrite their outputs")
+	logLevel    = flag.String("log-level", "info", "Minimum level of the log messages: debug, info, warn or error")
+	logJSON     = flag.Bool("log-json", false, "Write the log messages as JSON")
+	showProg    = flag.Bool("progress", true, "Show the live progress of the running searches when standard error is a terminal")
+	metricsAddr = flag.String("metrics-addr", "", "Serve Prometheus metrics at http://<addr>/metrics while the searches run, e.g. :9090")
+	metricsPush = flag.String("metrics-push", "", "Push the metrics to this Pushgateway at the end of the run, e.g. http://localhost:9091")
+	metricsJob  = flag.String("metrics-job", "thinknumclient", "Job name used when pushing metrics")
+	reportPath  = flag.String("report", "", "Write a run report to this file: Markdown for a .md file, JSON otherwise. Overrides the configuration")
+	strict      = flag.Bool("strict", false, "Do not write the outputs of searches with incomplete results")
+	refetch     = flag.Bool("refetch", false, "Fetch again the missing records of searches with incomplete results")
+	savePartial = flag.Bool("save-partial", false, "Save the rows fetched by searches that fail midway, with a .partial suffix")
+	noCache     = flag.Bool("no-cache", false, "Don't use the page cache: query the API for every page and don't store the responses")
+	failPolicy  = flag.String("fail-policy", "", "When to exit with an error: fail-any, fail-all or never. Overrides the configuration (default fail-any)")
 )
 
 func main() {
 	flag.Parse()
 
+	os.Exit(run())
+}
+
+// run Runs all the searches and returns the exit code
+func run() int {
+
 	fmt.Printf("Using configuration from %s\n", *cfg)
 
-	client, err := thinknum.NewClientFromJSON(*cfg)
+	// everything printed while the progress display is active goes through it
+	var out, logOut io.Writer = os.Stdout, os.Stderr
+	var disp *display
+	if *showProg && !*dryRun && isTerminal(os.Stderr) {
+		disp = newDisplay(os.Stderr)
+		defer disp.close()
+		out, logOut = disp.writer(os.Stdout), disp.writer(os.Stderr)
+	}
+
+	logger, err := thinknum.NewLogger(logOut, *logLevel, *logJSON)
+	if err != nil {
+		panic(err)
+	}
+
+	conf, err := thinknum.LoadConfig(*cfg)
+	if err != nil {
+		panic(err)
+	}
+	conf.Logger = logger
+
+	if *metricsAddr != "" || *metricsPush != "" {
+		conf.Metrics = thinknum.NewMetrics()
+		defer pushMetrics(conf.Metrics, logger)
+	}
+	if *metricsAddr != "" {
+		go serveMetrics(conf.Metrics, logger)
+	}
+	if disp != nil {
+		conf.OnProgress = disp.handle
+	}
+
+	token, err := thinknum.GetToken(conf.ConfigAuth)
 	if err != nil {
 		panic(err)
 	}
 
+	if *fullRefresh {
+		conf.FullRefresh = true
+	}
+	if *strict {
+		conf.Strict = true
+	}
+	if *refetch {
+		conf.RefetchIncomplete = true
+	}
+	if *noCache {
+		conf.NoCache = true
+	}
+	if *savePartial {
+		conf.PartialResults = thinknum.PartialSave
+	}
+	if *reportPath != "" {
+		conf.Report = *reportPath
+	}
+	if *failPolicy != "" {
+		conf.FailPolicy = *failPolicy
+	}
+	if conf.FailPolicy == "" {
+		conf.FailPolicy = thinknum.FailAny
+	}
+	if err := thinknum.ValidateFailPolicy(conf.FailPolicy); err != nil {
+		panic(err)
+	}
+
+	client := thinknum.NewClient(conf, token)
+
+	if *dryRun {
+		printPlan(client, conf)
+		return 0
+	}
+
+	report := thinknum.NewRunReport()
+
 	for ri := range client.RunAll() {
 		if ri.Error != nil {
-			fmt.Printf("Error: %v\n", ri.Error)
-			// TODO maybe try to save any result that might be in there
+			fmt.Fprintf(out, "Error: %v\n", ri.Error)
+			saved := client.SavePartialResult(ri)
+			report.Add(ri, saved)
+			for _, res := range saved {
+				fmt.Fprintf(out, "%s => Partial output type: %s, Path: %s, Error: %v\n",
+					res.Search.Name,
+					res.Type,
+					res.Path,
+					res.Error)
+			}
 			continue
 		}
 
+		if ri.Warning != nil {
+			fmt.Fprintf(out, "%s => Warning: %v\n", ri.Search.Name, ri.Warning)
+		}
+
 		results := client.SaveSearchResult(ri)
+		report.Add(ri, results)
 		for _, res := range results {
-			fmt.Printf("%s => Output type: %s, Error: %v\n",
+			if res.Skipped != "" {
+				fmt.Fprintf(out, "%s => Output type: %s, Skipped: %s\n", res.Search.Name, res.Type, res.Skipped)
+				continue
+			}
+			fmt.Fprintf(out, "%s => Output type: %s, Error: %v\n",
 				res.Search.Name,
 				res.Type,
 				res.Error)
 		}
-		fmt.Printf("Output to: %s\n", ri.Search.OutputFile)
-		fmt.Printf("Fields: %d\tRows: %d/%d\tPages: %d\n",
+		fmt.Fprintf(out, "Output to: %s\n", ri.Search.OutputFile)
+		fmt.Fprintf(out, "Fields: %d\tRows: %d/%d\tPages: %d\n",
 			len(ri.Data.Fields),
 			len(ri.Data.Rows),
 			ri.Data.Total,
 			ri.Data.Pages)
 	}
 
+	report.Finish()
+	fmt.Fprintf(out, "Searches: %d succeeded, %d failed\n", report.Succeeded, report.Failed)
+
+	if conf.Report != "" {
+		if err := report.Save(conf.Report); err != nil {
+			logger.Error("Cannot write the run report", "path", conf.Report, "error", err)
+			return 1
+		}
+		logger.Info("Run report written", "path", conf.Report)
+	}
+
+	return report.ExitCode(conf.FailPolicy)
+}
+
+// serveMetrics Exposes the metrics for Prometheus to scrape
+func serveMetrics(m *thinknum.Metrics, logger thinknum.Logger) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.Handler())
+
+	logger.Info("Serving metrics", "addr", *metricsAddr)
+	if err := http.ListenAndServe(*metricsAddr, mux); err != nil {
+		logger.Error("Metrics endpoint stopped", "error", err)
+	}
+}
+
+// pushMetrics Sends the metrics of the run to the Pushgateway, if one is configured
+func pushMetrics(m *thinknum.Metrics, logger thinknum.Logger) {
+	if *metricsPush == "" {
+		return
+	}
+
+	if err := m.Push(*metricsPush, *metricsJob); err != nil {
+		logger.Error("Cannot push metrics", "gateway", *metricsPush, "error", err)
+		return
+	}
+	logger.Info("Metrics pushed", "gateway", *metricsPush, "job", *metricsJob)
+}
+
+// printPlan Counts the results of every enabled search and prints the estimated size of the run
+func printPlan(client thinknum.Client, conf *thinknum.Config) {
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "SEARCH\tDATASET\tROWS\tPAGES\tSPLIT\t")
+
+	var rows, pages int
+	for _, s := range conf.Searches {
+		if s.Disabled {
+			continue
+		}
+
+		total, err := client.Count(s)
+		if err != nil {
+			fmt.Fprintf(w, "%s\t%s\terror: %v\t\t\t\n", s.Name, s.DatasetID, err)
+			continue
+		}
+
+		p := thinknum.PlanSearch(s, total, conf.PageSize, *maxPages)
+		rows += p.Total
+		pages += p.Pages
+
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\t\n", s.Name, s.DatasetID, p.Total, p.Pages, splitAdvice(p))
+	}
+
+	fmt.Fprintf(w, "TOTAL\t\t%d\t%d\t\t\n", rows, pages)
+	w.Flush()
+}
+
+// splitAdvice Describes how a search should be split, in terms of `splitsrch` parameters when possible
+func splitAdvice(p thinknum.SearchPlan) string {
+	switch {
+	case p.Slices <= 1:
+		return "-"
+	case p.Interval > 0:
+		return fmt.Sprintf("%d slices, -interval %.0fh", p.Slices, p.Interval.Hours())
+	default:
+		return fmt.Sprintf("%d slices", p.Slices)
+	}
 }
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	thinknum "github.com/mehiX/thinknumV2"
+)
+
+// searchProgress What the display knows about a running search
+type searchProgress struct {
+	name    string
+	started time.Time
+	rows    int
+	total   int
+	pages   int
+	status  string
+}
+
+// display A live, multi-line view of the running searches, redrawn in place on a terminal
+type display struct {
+	mu       sync.Mutex
+	out      io.Writer
+	running  map[string]*searchProgress
+	finished int
+	failed   int
+	// number of lines currently drawn
+	lines int
+	stop  chan struct{}
+}
+
+func newDisplay(out io.Writer) *display {
+	d := &display{
+		out:     out,
+		running: make(map[string]*searchProgress),
+		stop:    make(chan struct{}),
+	}
+
+	// redraw regularly so the elapsed time and the ETA stay current
+	go func() {
+		t := time.NewTicker(time.Second)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				d.mu.Lock()
+				d.redraw()
+				d.mu.Unlock()
+			case <-d.stop:
+				return
+			}
+		}
+	}()
+
+	return d
+}
+
+// isTerminal Checks if the file is a terminal, where the display can be redrawn in place
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// handle Updates the display with a progress event
+func (d *display) handle(e thinknum.ProgressEvent) {
+	// outputs are written after the search is finished
+	if e.Kind == thinknum.ProgressWritten {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	sp, ok := d.running[e.Search]
+	if !ok {
+		sp = &searchProgress{name: e.Search, started: e.Time}
+		d.running[e.Search] = sp
+	}
+
+	switch e.Kind {
+	case thinknum.ProgressStarted:
+		sp.status = "waiting for first page"
+	case thinknum.ProgressPage:
+		sp.rows, sp.total, sp.pages = e.Rows, e.Total, e.Page
+		sp.status = ""
+	case thinknum.ProgressRetry:
+		sp.status = fmt.Sprintf("retry %d: %v", e.Attempt, e.Err)
+	case thinknum.ProgressTimeout:
+		sp.status = "waiting for the API (gateway timeout)"
+	case thinknum.ProgressFinished:
+		if e.Err != nil {
+			d.failed++
+		} else {
+			d.finished++
+		}
+		delete(d.running, e.Search)
+	}
+
+	d.redraw()
+}
+
+// writer Returns a writer whose output appears above the progress lines.
+// Use it for everything printed to the terminal while the display is active
+func (d *display) writer(w io.Writer) io.Writer {
+	return displayWriter{d, w}
+}
+
+type displayWriter struct {
+	d *display
+	w io.Writer
+}
+
+func (dw displayWriter) Write(p []byte) (int, error) {
+	dw.d.mu.Lock()
+	defer dw.d.mu.Unlock()
+
+	dw.d.clear()
+	n, err := dw.w.Write(p)
+	dw.d.draw()
+
+	return n, err
+}
+
+// close Stops redrawing and removes the progress lines
+func (d *display) close() {
+	close(d.stop)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.clear()
+}
+
+func (d *display) redraw() {
+	d.clear()
+	d.draw()
+}
+
+// clear Moves the cursor up to the first progress line and clears everything below
+func (d *display) clear() {
+	if d.lines > 0 {
+		fmt.Fprintf(d.out, "\033[%dA\033[J", d.lines)
+	}
+	d.lines = 0
+}
+
+func (d *display) draw() {
+	names := make([]string, 0, len(d.running))
+	for n := range d.running {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Running: %d  Finished: %d  Failed: %d\n", len(d.running), d.finished, d.failed)
+	for _, n := range names {
+		sb.WriteString(d.running[n].line(time.Now()) + "\n")
+	}
+
+	io.WriteString(d.out, sb.String())
+	d.lines = len(names) + 1
+}
+
+// line Describes the progress of the search, with an ETA based on the throughput observed so far
+func (sp *searchProgress) line(now time.Time) string {
+	elapsed := now.Sub(sp.started).Round(time.Second)
+
+	if sp.status != "" {
+		return fmt.Sprintf("  %-30s %s [%s]", sp.name, sp.status, elapsed)
+	}
+
+	percent := 0.0
+	if sp.total > 0 {
+		percent = 100 * float64(sp.rows) / float64(sp.total)
+	}
+
+	eta := "?"
+	if sp.rows > 0 && sp.total >= sp.rows {
+		perRow := now.Sub(sp.started) / time.Duration(sp.rows)
+		eta = (perRow * time.Duration(sp.total-sp.rows)).Round(time.Second).String()
+	}
+
+	return fmt.Sprintf("  %-30s %5.1f%%  rows %d/%d  pages %d  elapsed %s  eta %s",
+		sp.name, percent, sp.rows, sp.total, sp.pages, elapsed, eta)
+}
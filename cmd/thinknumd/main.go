@@ -0,0 +1,410 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	thinknum "github.com/mehiX/thinknumV2"
+)
+
+var (
+	cfg        = flag.String("c", "config.json", "Configuration file")
+	statusFile = flag.String("status", "thinknumd_status.json", "File where the status of the scheduled searches is persisted")
+	httpAddr   = flag.String("http", "127.0.0.1:8080", "Address of the HTTP status endpoint. Leave empty to disable it")
+	logLevel   = flag.String("log-level", "info", "Minimum level of the log messages: debug, info, warn or error")
+	logJSON    = flag.Bool("log-json", false, "Write the log messages as JSON")
+	shutdown   = flag.Duration("shutdown-timeout", 5*time.Minute, "How long to wait for the running searches to finish when stopping")
+)
+
+func main() {
+	flag.Parse()
+
+	logger, err := thinknum.NewLogger(os.Stderr, *logLevel, *logJSON)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+
+	logger.Info("Loading configuration", "path", *cfg)
+
+	conf, err := thinknum.LoadConfig(*cfg)
+	if err != nil {
+		fatal(logger, "Invalid configuration", err)
+	}
+	conf.Logger = logger
+	conf.Metrics = thinknum.NewMetrics()
+
+	status, err := loadStatus(*statusFile)
+	if err != nil {
+		fatal(logger, "Cannot load status", err)
+	}
+	status.Started = time.Now()
+
+	d := newDaemon(conf, logger, status)
+	d.cfgFile = *cfg
+
+	scheduled := 0
+	for _, s := range conf.Searches {
+		if s.Disabled || s.Schedule == "" {
+			continue
+		}
+		sched, err := thinknum.ParseSchedule(s.Schedule)
+		if err != nil {
+			fatal(logger, "Invalid schedule", err)
+		}
+		scheduled++
+		go d.loop(s, sched)
+	}
+
+	if scheduled == 0 {
+		fatal(logger, "No enabled search has a schedule", nil)
+	}
+	logger.Info("Searches scheduled", "count", scheduled)
+
+	if *httpAddr != "" {
+		go func() {
+			logger.Info("Serving status", "url", "http://"+*httpAddr+"/status")
+			fatal(logger, "Status endpoint stopped", http.ListenAndServe(*httpAddr, d.handler()))
+		}()
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	if !d.stopOn(signals, *shutdown) {
+		os.Exit(1)
+	}
+}
+
+// clock The time of the daemon, replaced in the tests
+type clock interface {
+	Now() time.Time
+	After(time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+type daemon struct {
+	conf *thinknum.Config
+	// the configuration file, loaded again before every run so that the dates relative to today move with the runs
+	cfgFile string
+	log     thinknum.Logger
+	status  *statusStore
+	clock   clock
+	// execute Runs a search and saves its results. Returns the results and the first error
+	execute func(thinknum.SearchDefinition) (thinknum.SearchResult, error)
+	// limits the number of searches running at the same time to the configured number of workers
+	slots chan struct{}
+
+	mu     sync.Mutex
+	token  *thinknum.AuthToken
+	client thinknum.Client
+	// set when the API rejected the token, so the cached token is not used again
+	rejected bool
+
+	// closed when the daemon stops, no run starts after that
+	stop     chan struct{}
+	stopping bool
+	running  sync.WaitGroup
+}
+
+// newDaemon Returns a daemon running the searches of the configuration, at most `workers` at the same time
+func newDaemon(conf *thinknum.Config, log thinknum.Logger, status *statusStore) *daemon {
+
+	workers := conf.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	d := &daemon{
+		conf:   conf,
+		log:    log,
+		status: status,
+		clock:  realClock{},
+		slots:  make(chan struct{}, workers),
+		stop:   make(chan struct{}),
+	}
+	d.execute = d.runAndSave
+
+	return d
+}
+
+// stopOn Waits for a signal, then stops the daemon, see shutdown. Returns false if searches were still running after `timeout`
+func (d *daemon) stopOn(signals <-chan os.Signal, timeout time.Duration) bool {
+
+	d.log.Info("Stopping", "signal", (<-signals).String())
+
+	if !d.shutdown(timeout) {
+		d.log.Warn("Searches still running, stopping anyway", "timeout", timeout.String())
+		return false
+	}
+	d.log.Info("Stopped")
+
+	return true
+}
+
+// shutdown Stops the schedules and waits for the running searches to finish, for at most `timeout`. Returns false on timeout
+func (d *daemon) shutdown(timeout time.Duration) bool {
+
+	d.mu.Lock()
+	d.stopping = true
+	close(d.stop)
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-d.clock.After(timeout):
+		return false
+	}
+}
+
+// startRun Counts a new run of a search, unless the daemon is stopping
+func (d *daemon) startRun() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.stopping {
+		return false
+	}
+	d.running.Add(1)
+	return true
+}
+
+// loop Runs the search every time the schedule says so. A run is skipped if the previous one is still in progress
+func (d *daemon) loop(s thinknum.SearchDefinition, sched thinknum.Schedule) {
+
+	log := d.log.With("search", s.Name)
+
+	for {
+		next := sched.Next(d.clock.Now())
+		if next.IsZero() {
+			log.Warn("Schedule never runs", "schedule", s.Schedule)
+			return
+		}
+
+		d.updateStatus(s.Name, func(st *searchStatus) {
+			st.Schedule = s.Schedule
+			st.NextRun = next
+		})
+
+		select {
+		case <-d.clock.After(next.Sub(d.clock.Now())):
+		case <-d.stop:
+			return
+		}
+
+		if !d.startRun() {
+			return
+		}
+
+		started, err := d.status.tryStart(s.Name, d.clock.Now())
+		if err != nil {
+			log.Error("Cannot save status", "error", err)
+		}
+		if !started {
+			d.running.Done()
+			log.Warn("Previous run still in progress, skipping")
+			continue
+		}
+
+		go d.run(s)
+	}
+}
+
+// run Runs the search when a worker is free and records the outcome in the status
+func (d *daemon) run(s thinknum.SearchDefinition) {
+
+	defer d.running.Done()
+
+	log := d.log.With("search", s.Name)
+
+	select {
+	case d.slots <- struct{}{}:
+		defer func() { <-d.slots }()
+	case <-d.stop:
+		// still waiting for a worker, don't start a search that would delay the shutdown
+		log.Info("Run cancelled")
+		d.updateStatus(s.Name, func(st *searchStatus) { st.State = "idle" })
+		return
+	}
+
+	log.Info("Run started")
+
+	res, err := d.execute(s)
+
+	d.updateStatus(s.Name, func(st *searchStatus) {
+		st.State = "idle"
+		st.LastEnd = d.clock.Now()
+		st.Runs++
+		st.Rows = len(res.Data.Rows)
+		st.Total = res.Data.Total
+		st.LastResult = "ok"
+		st.LastError = ""
+		if err != nil {
+			st.LastResult = "failed"
+			st.LastError = err.Error()
+			st.Failures++
+		}
+	})
+
+	if err != nil {
+		log.Error("Run failed", "error", err)
+		if errors.Is(err, thinknum.ErrUnauthorized) {
+			d.dropToken()
+		}
+		return
+	}
+	log.Info("Run finished", "rows", len(res.Data.Rows), "total", res.Data.Total)
+}
+
+// runAndSave Runs the search and saves the results, the same way thinknumclient does
+func (d *daemon) runAndSave(s thinknum.SearchDefinition) (thinknum.SearchResult, error) {
+
+	s = d.render(s)
+
+	client, err := d.getClient()
+	if err != nil {
+		return thinknum.SearchResult{}, err
+	}
+
+	res := client.Run(s)
+	if res.Error != nil {
+		for _, r := range client.SavePartialResult(res) {
+			if r.Error != nil {
+				d.log.Warn("Cannot save partial results", "search", s.Name, "type", r.Type, "error", r.Error)
+			}
+		}
+		return res, res.Error
+	}
+
+	for _, r := range client.SaveSearchResult(res) {
+		if r.Error != nil {
+			err = fmt.Errorf("output type %s: %w", r.Type, r.Error)
+		}
+	}
+
+	return res, err
+}
+
+// render Returns the search as defined by the configuration file now: its placeholders, e.g. `{{today-7d}}`, are substituted
+// when the daemon started, which would keep the dates of the first day. Changes to the other fields are picked up too,
+// except for the schedule, which needs a restart. Returns the search as loaded at the start if the file is not valid anymore
+func (d *daemon) render(s thinknum.SearchDefinition) thinknum.SearchDefinition {
+
+	if d.cfgFile == "" {
+		return s
+	}
+
+	conf, err := thinknum.LoadConfig(d.cfgFile)
+	if err != nil {
+		d.log.Warn("Cannot load the configuration again, running the search as loaded at the start", "search", s.Name, "error", err)
+		return s
+	}
+	for _, c := range conf.Searches {
+		if c.Name == s.Name {
+			return c
+		}
+	}
+
+	d.log.Warn("Search not in the configuration anymore, running it as loaded at the start", "search", s.Name)
+	return s
+}
+
+// getClient Returns a client with a valid token. A new token is requested when the current one expires
+func (d *daemon) getClient() (thinknum.Client, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.token != nil {
+		if expired, err := d.token.IsExpired(); !expired && err == nil {
+			return d.client, nil
+		}
+	}
+
+	var token *thinknum.AuthToken
+	var err error
+	if d.rejected {
+		// the cached token would be rejected again
+		token, err = thinknum.RequestNewToken(d.conf.ConfigAuth)
+		if err == nil {
+			if cerr := token.Cache(d.conf.TokenCachePath); cerr != nil {
+				d.log.Warn("Cannot cache token", "path", d.conf.TokenCachePath, "error", cerr)
+			}
+		}
+	} else {
+		token, err = thinknum.GetToken(d.conf.ConfigAuth)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	d.token = token
+	d.rejected = false
+	d.client = thinknum.NewClient(d.conf, token)
+
+	return d.client, nil
+}
+
+// dropToken Discards the current token after the API rejected it, a new one is requested for the next run
+func (d *daemon) dropToken() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.token = nil
+	d.rejected = true
+}
+
+func (d *daemon) updateStatus(name string, f func(*searchStatus)) {
+	if err := d.status.update(name, f); err != nil {
+		d.log.Error("Cannot save status", "search", name, "error", err)
+	}
+}
+
+// handler Serves the status of the scheduled searches as JSON
+func (d *daemon) handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		b, err := d.status.snapshot()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+	})
+
+	mux.Handle("/metrics", d.conf.Metrics.Handler())
+
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+
+	return mux
+}
+
+// fatal Logs the error and exits
+func fatal(log thinknum.Logger, msg string, err error) {
+	if err != nil {
+		log.Error(msg, "error", err)
+	} else {
+		log.Error(msg)
+	}
+	os.Exit(1)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	thinknum "github.com/mehiX/thinknumV2"
+	"github.com/mehiX/thinknumV2/internal/query"
+)
+
+// fakeClock A clock that only moves when the test sends a tick
+type fakeClock struct {
+	now   time.Time
+	ticks chan time.Time
+}
+
+func (c *fakeClock) Now() time.Time                       { return c.now }
+func (c *fakeClock) After(time.Duration) <-chan time.Time { return c.ticks }
+
+func newTestDaemon(t *testing.T, execute func(thinknum.SearchDefinition) (thinknum.SearchResult, error)) (*daemon, *fakeClock) {
+
+	status, err := loadStatus(filepath.Join(t.TempDir(), "status.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger, _ := thinknum.NewLogger(os.Stderr, "error", false)
+	d := newDaemon(&thinknum.Config{Workers: 1}, logger, status)
+	clk := &fakeClock{now: time.Date(2021, 3, 5, 6, 30, 0, 0, time.UTC), ticks: make(chan time.Time)}
+	d.clock = clk
+	d.execute = execute
+
+	return d, clk
+}
+
+// waitFor Waits until the status of the search satisfies `f`
+func waitFor(t *testing.T, d *daemon, name string, f func(searchStatus) bool) searchStatus {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		d.status.mu.Lock()
+		s, ok := d.status.Searches[name]
+		var cp searchStatus
+		if ok {
+			cp = *s
+		}
+		d.status.mu.Unlock()
+		if ok && f(cp) {
+			return cp
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("Timeout waiting for the status of %s", name)
+	return searchStatus{}
+}
+
+func TestLoop(t *testing.T) {
+
+	execute := func(s thinknum.SearchDefinition) (thinknum.SearchResult, error) {
+		var res thinknum.SearchResult
+		res.Data.Rows = []query.Row{{"a"}, {"b"}}
+		res.Data.Total = 2
+		if s.Name == "failing" {
+			return res, errors.New("forbidden")
+		}
+		return res, nil
+	}
+	sched, _ := thinknum.ParseSchedule("@every 1h")
+	run := func(name string) (*daemon, searchStatus) {
+		d, clk := newTestDaemon(t, execute)
+		go d.loop(thinknum.SearchDefinition{Name: name, Schedule: "@every 1h"}, sched)
+		clk.ticks <- clk.now
+
+		s := waitFor(t, d, name, func(s searchStatus) bool { return s.Runs == 1 && s.State == "idle" })
+		if !s.NextRun.Equal(clk.now.Add(time.Hour)) {
+			t.Errorf("Wrong next run of %s: %v", name, s.NextRun)
+		}
+		return d, s
+	}
+
+	d, ok := run("jobs")
+	if ok.LastResult != "ok" || ok.Rows != 2 {
+		t.Errorf("Wrong status: %+v", ok)
+	}
+	if !d.shutdown(time.Second) {
+		t.Error("Expected the daemon to stop")
+	}
+
+	d, failed := run("failing")
+	if failed.LastResult != "failed" || failed.LastError != "forbidden" || failed.Failures != 1 {
+		t.Errorf("Wrong status of the failed search: %+v", failed)
+	}
+	if !d.shutdown(time.Second) {
+		t.Error("Expected the daemon to stop")
+	}
+}
+
+func TestStopWaitsForRunningSearches(t *testing.T) {
+
+	started, release := make(chan struct{}), make(chan struct{})
+	d, clk := newTestDaemon(t, func(s thinknum.SearchDefinition) (thinknum.SearchResult, error) {
+		close(started)
+		<-release
+		return thinknum.SearchResult{}, nil
+	})
+
+	sched, _ := thinknum.ParseSchedule("@every 1h")
+	go d.loop(thinknum.SearchDefinition{Name: "jobs", Schedule: "@every 1h"}, sched)
+	clk.ticks <- clk.now
+	<-started
+
+	signals := make(chan os.Signal, 1)
+	signals <- syscall.SIGTERM
+	stopped := make(chan bool)
+	go func() { stopped <- d.stopOn(signals, time.Hour) }()
+
+	select {
+	case <-stopped:
+		t.Fatal("Expected the daemon to wait for the running search")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if !<-stopped {
+		t.Error("Expected the daemon to stop once the search finished")
+	}
+	if s := waitFor(t, d, "jobs", func(s searchStatus) bool { return true }); s.Runs != 1 || s.State != "idle" {
+		t.Errorf("Wrong status after the shutdown: %+v", s)
+	}
+
+	// no run starts after the shutdown
+	if d.startRun() {
+		t.Error("Expected no run to start after the shutdown")
+	}
+}
+
+func TestStopTimeout(t *testing.T) {
+
+	started, release := make(chan struct{}), make(chan struct{})
+	d, clk := newTestDaemon(t, func(s thinknum.SearchDefinition) (thinknum.SearchResult, error) {
+		close(started)
+		<-release
+		return thinknum.SearchResult{}, nil
+	})
+	defer func() {
+		close(release)
+		d.running.Wait()
+	}()
+
+	sched, _ := thinknum.ParseSchedule("@every 1h")
+	go d.loop(thinknum.SearchDefinition{Name: "jobs", Schedule: "@every 1h"}, sched)
+	clk.ticks <- clk.now
+	<-started
+
+	// the fake clock gives up waiting as soon as it ticks
+	go func() { clk.ticks <- clk.now }()
+	if d.shutdown(time.Minute) {
+		t.Error("Expected the shutdown to time out while the search runs")
+	}
+}
+
+func TestRender(t *testing.T) {
+
+	d, _ := newTestDaemon(t, nil)
+	dir := t.TempDir()
+	d.cfgFile = filepath.Join(dir, "config.yaml")
+	conf := `
+client_id: id
+client_secret: secret
+workers: 1
+page_size: 1000
+searches:
+  - name: jobs
+    dataset: job_listings
+    output: ` + filepath.Join(dir, "jobs") + `
+    output_types: [csv]
+    where: as_of_date >= {{today}}
+`
+	if err := ioutil.WriteFile(d.cfgFile, []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// loaded the day the daemon started
+	started := thinknum.SearchDefinition{Name: "jobs", DatasetID: "job_listings", Where: "as_of_date >= 2021-03-05"}
+	if s := d.render(started); s.Where != "as_of_date >= "+time.Now().Format("2006-01-02") {
+		t.Errorf("Expected the dates to be rendered again, got: %s", s.Where)
+	}
+
+	removed := thinknum.SearchDefinition{Name: "stores", DatasetID: "store"}
+	if s := d.render(removed); s.DatasetID != "store" {
+		t.Errorf("Expected the search as loaded at the start, got: %+v", s)
+	}
+}
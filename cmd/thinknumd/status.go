@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	thinknum "github.com/mehiX/thinknumV2"
+)
+
+// searchStatus What the daemon knows about one scheduled search
+type searchStatus struct {
+	Name     string `json:"name"`
+	Schedule string `json:"schedule"`
+	// idle or running
+	State     string    `json:"state"`
+	NextRun   time.Time `json:"next_run"`
+	LastStart time.Time `json:"last_start"`
+	LastEnd   time.Time `json:"last_end"`
+	// ok or failed
+	LastResult string `json:"last_result,omitempty"`
+	LastError  string `json:"last_error,omitempty"`
+	Rows       int    `json:"rows"`
+	Total      int    `json:"total"`
+	Runs       int    `json:"runs"`
+	Failures   int    `json:"failures"`
+	// Number of runs skipped because the previous run of the search was still in progress
+	Skipped int `json:"skipped"`
+}
+
+// statusStore Keeps the status of all the scheduled searches and persists it on every change
+type statusStore struct {
+	mu       sync.Mutex
+	path     string
+	Started  time.Time                `json:"started"`
+	Searches map[string]*searchStatus `json:"searches"`
+}
+
+// loadStatus Loads the status saved by a previous daemon. A missing file results in an empty status
+func loadStatus(fn string) (*statusStore, error) {
+
+	st := &statusStore{
+		path:     fn,
+		Searches: make(map[string]*searchStatus),
+	}
+
+	b, err := ioutil.ReadFile(fn)
+	if os.IsNotExist(err) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, st); err != nil {
+		return nil, err
+	}
+	if st.Searches == nil {
+		st.Searches = make(map[string]*searchStatus)
+	}
+
+	// a previous daemon might have been stopped in the middle of a run
+	for _, s := range st.Searches {
+		s.State = "idle"
+	}
+
+	return st, nil
+}
+
+// update Changes the status of a search and saves the status file
+func (st *statusStore) update(name string, f func(*searchStatus)) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	s, ok := st.Searches[name]
+	if !ok {
+		s = &searchStatus{Name: name, State: "idle"}
+		st.Searches[name] = s
+	}
+	f(s)
+
+	return st.save()
+}
+
+func (st *statusStore) save() error {
+	b, err := json.MarshalIndent(st, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return thinknum.WriteFileAtomic(st.path, 0644, func(w io.Writer) error {
+		_, err := w.Write(b)
+		return err
+	})
+}
+
+// snapshot Returns the current status as JSON
+func (st *statusStore) snapshot() ([]byte, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	return json.MarshalIndent(st, "", "    ")
+}
+
+// tryStart Marks the search as running. Returns false if the search is already running, in which case the run is counted as skipped
+func (st *statusStore) tryStart(name string, now time.Time) (bool, error) {
+	started := false
+
+	err := st.update(name, func(s *searchStatus) {
+		if s.State == "running" {
+			s.Skipped++
+			return
+		}
+		s.State = "running"
+		s.LastStart = now
+		started = true
+	})
+
+	return started, err
+}
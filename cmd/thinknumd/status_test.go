@@ -0,0 +1,39 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStatusStore(t *testing.T) {
+
+	fn := filepath.Join(t.TempDir(), "status.json")
+
+	st, err := loadStatus(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(st.Searches) != 0 {
+		t.Fatalf("Expected an empty status, got: %v", st.Searches)
+	}
+
+	now := time.Date(2021, 3, 5, 6, 30, 0, 0, time.UTC)
+	if started, err := st.tryStart("jobs", now); !started || err != nil {
+		t.Fatalf("Expected the run to start, got: %v, %v", started, err)
+	}
+	// the previous run is still in progress
+	if started, err := st.tryStart("jobs", now.Add(time.Hour)); started || err != nil {
+		t.Fatalf("Expected the run to be skipped, got: %v, %v", started, err)
+	}
+
+	// a daemon stopped in the middle of a run
+	loaded, err := loadStatus(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := loaded.Searches["jobs"]
+	if s == nil || s.State != "idle" || s.Skipped != 1 || !s.LastStart.Equal(now) {
+		t.Errorf("Wrong status after a restart: %+v", s)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	thinknum "github.com/mehiX/thinknumV2"
+)
+
+var (
+	cfg       = flag.String("c", "config.json", "Configuration file with the cache parameters")
+	dir       = flag.String("dir", "", "Cache directory. Overrides the configuration")
+	ttl       = flag.Duration("ttl", 0, "prune: remove the entries older than this, e.g. 24h. Defaults to the configured ttl")
+	maxSizeMB = flag.Int64("max-size-mb", -1, "prune: remove the oldest entries until the cache uses at most this many megabytes. Defaults to the configured max_size_mb")
+)
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `Usage: %s [options] list|prune|clear
+
+  list   list the cached pages, the oldest first
+  prune  remove the expired pages and the oldest pages over the size limit
+  clear  remove all the cached pages
+
+Options:
+`, os.Args[0])
+	flag.PrintDefaults()
+}
+
+func main() {
+
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cc := thinknum.CacheConfig{Dir: *dir}
+	if *dir == "" {
+		conf, err := thinknum.LoadAuthConfig(*cfg)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if !conf.Cache.Enabled() {
+			log.Fatalf("no cache dir in %s, use -dir\n", *cfg)
+		}
+		cc = conf.Cache
+	}
+
+	c, err := thinknum.OpenCache(cc)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	switch flag.Arg(0) {
+	case "list":
+		list(c)
+	case "prune":
+		age := c.TTL
+		if *ttl > 0 {
+			age = *ttl
+		}
+		limit := c.MaxBytes
+		if *maxSizeMB >= 0 {
+			limit = *maxSizeMB * 1024 * 1024
+		}
+		prune(c, age, limit)
+	case "clear":
+		report(c.Clear())
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+func list(c *thinknum.Cache) {
+
+	entries, err := c.List()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	var total int64
+	now := time.Now()
+	for _, e := range entries {
+		state := ""
+		if c.TTL > 0 && now.Sub(e.ModTime) > c.TTL {
+			state = "expired"
+		}
+		// the entries cached before their info was stored only have their key
+		page := "-"
+		if e.Info.Dataset != "" {
+			page = fmt.Sprintf("%s start=%d limit=%d", e.Info.Dataset, e.Info.Start, e.Info.Limit)
+		}
+		fmt.Printf("%s  %10d  %s  %-40s  %s\n", e.ModTime.Format(time.RFC3339), e.Size, e.Key, page, state)
+		total += e.Size
+	}
+
+	fmt.Printf("%d entries, %.1f MB in %s\n", len(entries), float64(total)/1024/1024, c.Dir)
+}
+
+func prune(c *thinknum.Cache, maxAge time.Duration, maxBytes int64) {
+	report(c.Prune(maxAge, maxBytes))
+}
+
+func report(removed int, freed int64, err error) {
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	fmt.Printf("Removed %d entries, %.1f MB\n", removed, float64(freed)/1024/1024)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	thinknum "github.com/mehiX/thinknumV2"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	cfg         = flag.String("c", "config.json", "Configuration file: JSON, YAML (.yaml, .yml) or TOML (.toml)")
+	format      = flag.String("format", "json", "render: output format, json or yaml")
+	showSecrets = flag.Bool("show-secrets", false, "render: print the secrets instead of masking them")
+)
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `Usage: %s [options] render|validate [files...]
+
+  render    print the configuration with the includes merged, the templates expanded,
+            the variables substituted and the defaults and extends applied
+  validate  check the configuration files (default the -c file) and list all their problems.
+            Exits with status 1 if a file is not valid
+
+Options:
+`, os.Args[0])
+	flag.PrintDefaults()
+}
+
+func main() {
+
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	switch flag.Arg(0) {
+	case "render":
+		render()
+	case "validate":
+		files := flag.Args()[1:]
+		if len(files) == 0 {
+			files = []string{*cfg}
+		}
+		if !validate(files) {
+			os.Exit(1)
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+func render() {
+
+	if *format != "json" && *format != "yaml" {
+		log.Fatalf("unknown format %q, expected json or yaml\n", *format)
+	}
+
+	conf, err := thinknum.LoadConfig(*cfg)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	if !*showSecrets {
+		for _, secret := range []*string{&conf.ClientSecret, &conf.Upload.SecretAccessKey, &conf.Upload.SessionToken} {
+			if *secret != "" {
+				*secret = "********"
+			}
+		}
+	}
+
+	b, err := json.MarshalIndent(conf, "", "  ")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	if *format == "yaml" {
+		// through a generic map, so that the keys are the same as in JSON
+		var v interface{}
+		if err := json.Unmarshal(b, &v); err != nil {
+			log.Fatalln(err)
+		}
+		if b, err = yaml.Marshal(v); err != nil {
+			log.Fatalln(err)
+		}
+	} else {
+		b = append(b, '\n')
+	}
+
+	if _, err := os.Stdout.Write(b); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// validate Prints the problems of every file. Returns false if a file is not valid
+func validate(files []string) bool {
+
+	valid := true
+	for _, fn := range files {
+		_, err := thinknum.LoadConfig(fn)
+		if err == nil {
+			fmt.Printf("%s: ok\n", fn)
+			continue
+		}
+		valid = false
+
+		var errs thinknum.ConfigErrors
+		if !errors.As(err, &errs) {
+			fmt.Printf("%s: %v\n", fn, err)
+			continue
+		}
+		for _, e := range errs {
+			fmt.Printf("%s: %v\n", fn, e)
+		}
+	}
+
+	return valid
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	thinknum "github.com/mehiX/thinknumV2"
+)
+
+var (
+	cfg         = flag.String("c", "config.json", "Configuration file (only the authentication parameters are used)")
+	tickers     = flag.String("t", "", "Comma separated list of ticker IDs, e.g. nasdaq:aapl,nyse:ibm")
+	tickersFile = flag.String("f", "", "File with one ticker ID per line. Empty lines and lines starting with # are ignored")
+	workers     = flag.Int("workers", 5, "Number of concurrent requests")
+	rate        = flag.Float64("rate", 5, "Maximum number of requests per second. Use 0 for no limit")
+	retries     = flag.Int("retries", 3, "Number of times a rate limited request is sent again")
+	format      = flag.String("format", "csv", "Format of the coverage matrix: csv or json")
+	output      = flag.String("o", "", "Write the coverage matrix to this file instead of standard output")
+	logLevel    = flag.String("log-level", "warn", "Minimum level of the log messages: debug, info, warn or error")
+	logJSON     = flag.Bool("log-json", false, "Write the log messages as JSON")
+)
+
+func main() {
+	flag.Parse()
+
+	list := splitList(*tickers)
+	if *tickersFile != "" {
+		fromFile, err := readTickers(*tickersFile)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		list = append(list, fromFile...)
+	}
+	if len(list) == 0 {
+		fmt.Println("No tickers provided")
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *format != "csv" && *format != "json" {
+		log.Fatalf("unknown format %q, expected csv or json\n", *format)
+	}
+
+	logger, err := thinknum.NewLogger(os.Stderr, *logLevel, *logJSON)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	conf, err := thinknum.LoadAuthConfig(*cfg)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	conf.Logger = logger
+
+	token, err := thinknum.GetToken(conf.ConfigAuth)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	client := thinknum.NewClient(conf, token)
+
+	var done int32
+	cv := thinknum.FetchCoverage(client, list, thinknum.CoverageOptions{
+		Workers: *workers,
+		Rate:    *rate,
+		Retries: *retries,
+		OnTicker: func(ticker string, err error) {
+			n := atomic.AddInt32(&done, 1)
+			if err != nil {
+				logger.Warn("Cannot get the datasets of a ticker", "ticker", ticker, "error", err)
+			}
+			logger.Debug("Ticker done", "ticker", ticker, "done", n, "total", len(list))
+		},
+	})
+
+	write := func(out io.Writer) error {
+		if *format == "json" {
+			return thinknum.WriteCoverageJSON(out, cv)
+		}
+		return thinknum.WriteCoverageCSV(out, cv)
+	}
+
+	if *output != "" {
+		err = thinknum.WriteFileAtomic(*output, 0644, write)
+	} else {
+		err = write(os.Stdout)
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	if err := thinknum.WriteCoverageSummary(os.Stderr, cv); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// readTickers Reads one ticker per line
+func readTickers(fn string) ([]string, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var l []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		l = append(l, line)
+	}
+
+	return l, sc.Err()
+}
+
+func splitList(s string) []string {
+	var l []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			l = append(l, v)
+		}
+	}
+	return l
+}
@@ -4,32 +4,99 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"os"
+	"time"
 
 	thinknum "github.com/mehiX/thinknumV2"
 )
 
 var (
-	cfg      = flag.String("c", "config.json", "File to load configuration from")
-	tickerID = flag.String("t", "", "TickerID to filter datasets by")
+	cfg        = flag.String("c", "config.json", "File to load configuration from")
+	tickerID   = flag.String("t", "", "TickerID to filter datasets by")
+	catalogDir = flag.String("catalog-dir", "", "Directory where the catalogs are stored. Defaults to the catalog_dir of the configuration, or "+thinknum.DefaultCatalogDir)
+	maxAge     = flag.Duration("max-age", 24*time.Hour, "Use the stored catalog if it was fetched less than this ago. Use 0 to always fetch it")
+	search     = flag.String("search", "", "Only show the datasets with this text in their ID, display name, sector, industry or country")
+	regex      = flag.Bool("regex", false, "Interpret -search as a regular expression")
+	format     = flag.String("format", "table", "Output format: table, csv or json")
+	diff       = flag.Bool("diff", false, "Fetch the catalog and show the datasets added or removed since the stored snapshot")
+	logLevel   = flag.String("log-level", "warn", "Minimum level of the log messages: debug, info, warn or error")
+	logJSON    = flag.Bool("log-json", false, "Write the log messages as JSON")
 )
 
 func main() {
 
 	flag.Parse()
 
-	fmt.Printf("Using configuration from: %s\n", *cfg)
+	fmt.Fprintf(os.Stderr, "Using configuration from: %s\n", *cfg)
 
-	client, err := thinknum.NewClientFromJSON(*cfg)
+	logger, err := thinknum.NewLogger(os.Stderr, *logLevel, *logJSON)
 	if err != nil {
 		log.Fatalln(err)
 	}
 
-	ds, err := client.Datasets(*tickerID)
+	conf, err := thinknum.LoadAuthConfig(*cfg)
 	if err != nil {
 		log.Fatalln(err)
 	}
+	conf.Logger = logger
 
-	for _, d := range ds {
-		fmt.Printf("%-30s %s\n", d.ID, d.DisplayName)
+	fetch := func() (thinknum.Catalog, error) {
+		token, err := thinknum.GetToken(conf.ConfigAuth)
+		if err != nil {
+			return thinknum.Catalog{}, err
+		}
+
+		ds, err := thinknum.NewClient(conf, token).Datasets(*tickerID)
+		if err != nil {
+			return thinknum.Catalog{}, err
+		}
+
+		return thinknum.NewDatasetCatalog(*tickerID, ds), nil
+	}
+
+	store := thinknum.CatalogStore{Dir: catalogDirOf(conf), MaxAge: *maxAge}
+	key := thinknum.Catalog{Kind: thinknum.CatalogDatasets, Ticker: *tickerID}
+
+	current, previous, err := store.Get(key, *diff, fetch)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	logger.Info("Catalog", "fetched", current.Fetched.Format(time.RFC3339), "datasets", len(current.Entries))
+
+	if *diff {
+		if previous.Fetched.IsZero() {
+			fmt.Fprintln(os.Stderr, "No previous snapshot, the catalog was stored for the next comparison")
+			return
+		}
+		previous, err = previous.Search(*search, *regex)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	current, err = current.Search(*search, *regex)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	if *diff {
+		added, removed := thinknum.DiffCatalogs(previous, current)
+		err = thinknum.WriteCatalogDiff(os.Stdout, previous, current, added, removed, *format)
+	} else {
+		err = thinknum.WriteCatalog(os.Stdout, current, *format)
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// catalogDirOf Returns the directory of the catalogs: -catalog-dir, or the one of the configuration, like the searches use
+func catalogDirOf(conf *thinknum.Config) string {
+	if *catalogDir != "" {
+		return *catalogDir
+	}
+	if conf.CatalogDir != "" {
+		return conf.CatalogDir
 	}
+	return thinknum.DefaultCatalogDir
 }
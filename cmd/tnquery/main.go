@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	thinknum "github.com/mehiX/thinknumV2"
+)
+
+var (
+	cfg     = flag.String("c", "config.json", "Configuration file (only the authentication and cache parameters are used)")
+	dataset = flag.String("d", "", "Dataset ID")
+	where   = flag.String("where", "", `Filter expression, e.g. 'country = "US" and as_of_date >= 2020-01-01'`)
+	tickers = flag.String("t", "", "Comma separated list of ticker IDs, e.g. nasdaq:aapl,nyse:ibm")
+	name    = flag.String("name", "tnquery", "Name of the search")
+	limit   = flag.Int("limit", previewRows, "Maximum number of rows to fetch. Use 0 to fetch all the rows. "+
+		"Defaults to 20 when printing to standard output and to all the rows with -o")
+	output = flag.String("o", "", "Write the results to this file instead of standard output. The suffix is added based on the format, "+
+		"without -format the format is taken from the suffix of the file (out/jobs.csv), csv and json if there is none")
+	format  = flag.String("format", "table", "Output format: table, csv or json. Files can be written in multiple formats: csv,json")
+	width   = flag.Int("width", 40, "Maximum width of a table column. Use 0 for no limit")
+	emit    = flag.Bool("emit", false, "Print the equivalent search definition as JSON and exit, without running the search")
+	noCache = flag.Bool("no-cache", false, "Don't use the page cache configured in the configuration file")
+
+	logLevel = flag.String("log-level", "warn", "Minimum level of the log messages: debug, info, warn or error")
+	logJSON  = flag.Bool("log-json", false, "Write the log messages as JSON")
+)
+
+// previewRows The default number of rows printed to standard output
+const previewRows = 20
+
+func main() {
+	flag.Parse()
+
+	if *dataset == "" {
+		fmt.Println("No dataset provided")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	formats := splitList(*format)
+	if *output != "" && !isFlagSet("format") {
+		*output, formats = formatsFromExt(*output)
+	}
+	if *output != "" && !isFlagSet("limit") {
+		// the default limit is for a preview, files get all the rows
+		*limit = 0
+	}
+	if err := validateFormats(formats); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	srch := thinknum.SearchDefinition{
+		Name:        *name,
+		OutputFile:  *output,
+		OutputTypes: formats,
+		DatasetID:   *dataset,
+		Where:       *where,
+	}
+	srch.Request.Tickers = splitList(*tickers)
+
+	if _, err := srch.BuildRequest(); err != nil {
+		printError(err)
+		os.Exit(2)
+	}
+
+	if *emit {
+		if *output == "" {
+			// a search in a config file always writes files
+			srch.OutputTypes = []string{"json", "csv"}
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "    ")
+		encoder.Encode(srch)
+		return
+	}
+
+	client, err := newClient()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(3)
+	}
+
+	var res thinknum.SearchResult
+	res.Search = srch
+	if *limit > 0 {
+		res.RunResult = client.Preview(srch, *limit)
+	} else {
+		res.RunResult = client.RunSearch(srch)
+	}
+
+	if res.Error != nil {
+		printError(res.Error)
+		os.Exit(4)
+	}
+
+	if *output == "" {
+		if err := printResult(res, formats[0]); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(5)
+		}
+		return
+	}
+
+	failed := false
+	for _, r := range client.SaveSearchResult(res) {
+		path := r.Path
+		if path == "" {
+			path = r.Search.OutputFile + "." + r.Type
+		}
+		if r.Error != nil {
+			fmt.Printf("%s => Error: %v\n", path, r.Error)
+			failed = true
+			continue
+		}
+		if r.Skipped != "" {
+			fmt.Printf("%s => Skipped: %s\n", path, r.Skipped)
+			continue
+		}
+		fmt.Println(path)
+	}
+	fmt.Printf("Rows: %d/%d\n", len(res.Data.Rows), res.Data.Total)
+
+	if failed {
+		os.Exit(5)
+	}
+}
+
+// newClient Returns a client using the authentication parameters of the configuration file
+func newClient() (thinknum.Client, error) {
+
+	logger, err := thinknum.NewLogger(os.Stderr, *logLevel, *logJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	conf, err := thinknum.LoadAuthConfig(*cfg)
+	if err != nil {
+		return nil, err
+	}
+	conf.Logger = logger
+	conf.NoCache = *noCache
+
+	token, err := thinknum.GetToken(conf.ConfigAuth)
+	if err != nil {
+		return nil, err
+	}
+
+	return thinknum.NewClient(conf, token), nil
+}
+
+// printResult Writes the results to standard output in the requested format
+func printResult(res thinknum.SearchResult, format string) error {
+	switch format {
+	case "csv":
+		return thinknum.WriteCSV(os.Stdout, res.Data)
+	case "json":
+		return thinknum.WriteJSON(os.Stdout, res.RunResult)
+	}
+
+	if err := thinknum.WriteTable(os.Stdout, res.Data, *width); err != nil {
+		return err
+	}
+	fmt.Printf("\nRows: %d/%d\n", len(res.Data.Rows), res.Data.Total)
+
+	return nil
+}
+
+// validateFormats Only one format can be printed to standard output, files can only be written as csv or json
+func validateFormats(formats []string) error {
+	for _, f := range formats {
+		switch f {
+		case "csv", "json":
+		case "table":
+			if *output != "" {
+				return fmt.Errorf("format table can only be printed to standard output, use -format csv or json with -o")
+			}
+		default:
+			return fmt.Errorf("unknown format: %s", f)
+		}
+	}
+
+	if *output == "" && len(formats) != 1 {
+		return fmt.Errorf("exactly one format should be used when printing to standard output")
+	}
+
+	return nil
+}
+
+// formatsFromExt Returns the output file without its extension and the format given by the extension, csv and json without one
+func formatsFromExt(fn string) (string, []string) {
+	switch ext := filepath.Ext(fn); ext {
+	case ".csv", ".json":
+		return strings.TrimSuffix(fn, ext), []string{ext[1:]}
+	}
+	return fn, []string{"csv", "json"}
+}
+
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		set = set || f.Name == name
+	})
+	return set
+}
+
+func splitList(s string) []string {
+	var l []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			l = append(l, v)
+		}
+	}
+	return l
+}
+
+// printError Prints the error. For filter expression errors also point at the offending token
+func printError(err error) {
+	fmt.Printf("Error: %v\n", err)
+
+	var we *thinknum.WhereError
+	if errors.As(err, &we) {
+		fmt.Println(we.Caret())
+	}
+}
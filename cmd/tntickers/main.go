@@ -5,12 +5,21 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	thinknum "github.com/mehiX/thinknumV2"
 )
 
 var cfg = flag.String("c", "config.json", "Configuration file to use")
 var dataset = flag.String("d", "", "Dataset ID")
+var catalogDir = flag.String("catalog-dir", "", "Directory where the catalogs are stored. Defaults to the catalog_dir of the configuration, or "+thinknum.DefaultCatalogDir)
+var maxAge = flag.Duration("max-age", 24*time.Hour, "Use the stored catalog if it was fetched less than this ago. Use 0 to always fetch it")
+var search = flag.String("search", "", "Only show the tickers with this text in their ID, display name, sector, industry or country")
+var regex = flag.Bool("regex", false, "Interpret -search as a regular expression")
+var format = flag.String("format", "table", "Output format: table, csv or json")
+var diff = flag.Bool("diff", false, "Fetch the catalog and show the tickers added or removed since the stored snapshot")
+var logLevel = flag.String("log-level", "warn", "Minimum level of the log messages: debug, info, warn or error")
+var logJSON = flag.Bool("log-json", false, "Write the log messages as JSON")
 
 func main() {
 	flag.Parse()
@@ -21,27 +30,76 @@ func main() {
 		os.Exit(1)
 	}
 
-	fmt.Printf("Using configuration from: %s\n", *cfg)
+	fmt.Fprintf(os.Stderr, "Using configuration from: %s\n", *cfg)
 
-	conf, err := thinknum.ConfigFromJSON(*cfg)
+	logger, err := thinknum.NewLogger(os.Stderr, *logLevel, *logJSON)
 	if err != nil {
 		panic(err)
 	}
 
-	tkn, err := thinknum.GetToken(conf.ConfigAuth)
+	conf, err := thinknum.LoadAuthConfig(*cfg)
 	if err != nil {
 		panic(err)
 	}
+	conf.Logger = logger
 
-	client := thinknum.NewClient(conf, tkn)
+	fetch := func() (thinknum.Catalog, error) {
+		tkn, err := thinknum.GetToken(conf.ConfigAuth)
+		if err != nil {
+			return thinknum.Catalog{}, err
+		}
 
-	tickers, err := client.Tickers(*dataset)
+		tickers, err := thinknum.NewClient(conf, tkn).Tickers(*dataset)
+		if err != nil {
+			return thinknum.Catalog{}, err
+		}
+
+		return thinknum.NewTickerCatalog(*dataset, tickers), nil
+	}
+
+	store := thinknum.CatalogStore{Dir: catalogDirOf(conf), MaxAge: *maxAge}
+	key := thinknum.Catalog{Kind: thinknum.CatalogTickers, Dataset: *dataset}
+
+	current, previous, err := store.Get(key, *diff, fetch)
 	if err != nil {
-		panic(err)
+		log.Fatalln(err)
 	}
+	logger.Info("Catalog", "fetched", current.Fetched.Format(time.RFC3339), "tickers", len(current.Entries))
 
-	log.Println("Retrieved", len(tickers))
-	//for _, t := range tickers {
-	//fmt.Printf("%20s %s\n", t.ID, t.DisplayName)
-	//}
+	if *diff {
+		if previous.Fetched.IsZero() {
+			fmt.Fprintln(os.Stderr, "No previous snapshot, the catalog was stored for the next comparison")
+			return
+		}
+		previous, err = previous.Search(*search, *regex)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	current, err = current.Search(*search, *regex)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	if *diff {
+		added, removed := thinknum.DiffCatalogs(previous, current)
+		err = thinknum.WriteCatalogDiff(os.Stdout, previous, current, added, removed, *format)
+	} else {
+		err = thinknum.WriteCatalog(os.Stdout, current, *format)
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// catalogDirOf Returns the directory of the catalogs: -catalog-dir, or the one of the configuration, like the searches use
+func catalogDirOf(conf *thinknum.Config) string {
+	if *catalogDir != "" {
+		return *catalogDir
+	}
+	if conf.CatalogDir != "" {
+		return conf.CatalogDir
+	}
+	return thinknum.DefaultCatalogDir
 }
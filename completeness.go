@@ -0,0 +1,44 @@
+package thinknum
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mehiX/thinknumV2/internal/query"
+)
+
+// Range A range of record offsets, from Start (included) to End (excluded)
+type Range = query.Range
+
+// IncompleteError The number of records fetched differs from the total announced by the API.
+// This happens when the API returns a short page or when the total changes while the search runs
+type IncompleteError struct {
+	Fetched int
+	Total   int
+	// The offset ranges that were not fetched
+	Missing []Range
+}
+
+func (e *IncompleteError) Error() string {
+	return fmt.Sprintf("incomplete results: fetched %d of %d rows, %d ranges missing", e.Fetched, e.Total, len(e.Missing))
+}
+
+// checkComplete Returns an *IncompleteError if not all the records announced by the API were fetched
+func checkComplete(d query.RowsItems) error {
+	missing := d.Missing()
+	if len(d.Rows) == d.Total && len(missing) == 0 {
+		return nil
+	}
+
+	return &IncompleteError{
+		Fetched: len(d.Rows),
+		Total:   d.Total,
+		Missing: missing,
+	}
+}
+
+// IsIncomplete Checks if the error reports incomplete results
+func IsIncomplete(err error) bool {
+	var ie *IncompleteError
+	return errors.As(err, &ie)
+}
@@ -0,0 +1,82 @@
+package thinknum
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mehiX/thinknumV2/internal/query"
+)
+
+func TestCheckComplete(t *testing.T) {
+
+	var scenarios = []struct {
+		name    string
+		rows    int
+		meta    query.RowItemsMetadata
+		missing []Range
+	}{
+		{"complete", 4, query.RowItemsMetadata{Total: 4, Fetched: []Range{{Start: 0, End: 2}, {Start: 2, End: 4}}}, nil},
+		{"no results", 0, query.RowItemsMetadata{Total: 0}, nil},
+		{"short last page", 3, query.RowItemsMetadata{Total: 4, Fetched: []Range{{Start: 0, End: 2}, {Start: 2, End: 3}}}, []Range{{Start: 3, End: 4}}},
+		{"gap", 4, query.RowItemsMetadata{Total: 6, Fetched: []Range{{Start: 0, End: 2}, {Start: 4, End: 6}}}, []Range{{Start: 2, End: 4}}},
+		{"total changed", 5, query.RowItemsMetadata{Total: 4, Fetched: []Range{{Start: 0, End: 5}}}, nil},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			d := query.RowsItems{RowItemsMetadata: s.meta, Rows: make([]query.Row, s.rows)}
+			err := checkComplete(d)
+			if complete := s.rows == s.meta.Total && s.missing == nil; complete != (err == nil) {
+				t.Fatalf("Expected complete: %v, got: %v", complete, err)
+			}
+			if err == nil {
+				return
+			}
+			if !IsIncomplete(err) {
+				t.Fatalf("Expected an IncompleteError, got: %v", err)
+			}
+			ie := err.(*IncompleteError)
+			if ie.Fetched != s.rows || ie.Total != s.meta.Total || !reflect.DeepEqual(ie.Missing, s.missing) {
+				t.Errorf("Wrong error: %+v", ie)
+			}
+		})
+	}
+}
+
+func TestStrictIncomplete(t *testing.T) {
+
+	dir := t.TempDir()
+
+	sr := SearchResult{
+		Search: SearchDefinition{
+			Name:        "jobs",
+			OutputFile:  filepath.Join(dir, "jobs"),
+			OutputTypes: []string{"csv", "json"},
+		},
+		Started: time.Now(),
+	}
+	sr.Data.Fields = []query.Field{{ID: "title", DisplayName: "Title"}}
+	sr.Data.Rows = []query.Row{{"a"}}
+	sr.Data.Total = 2
+	sr.Data.Fetched = []Range{{Start: 0, End: 1}}
+	sr.Warning = checkComplete(sr.Data)
+
+	c := &client{Config: Config{Strict: true}}
+	results := c.SaveSearchResult(sr)
+	if len(results) != 2 {
+		t.Fatalf("Expected a result per output type, got: %v", results)
+	}
+	for _, r := range results {
+		if !IsIncomplete(r.Error) {
+			t.Errorf("Expected an incomplete results error for %s, got: %v", r.Type, r.Error)
+		}
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 0 {
+		t.Errorf("Expected no files in strict mode, got %d", len(files))
+	}
+}
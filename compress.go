@@ -0,0 +1,109 @@
+package thinknum
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compressions of the output files
+const (
+	CompressionNone = ""
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+func validateCompression(c string) error {
+	switch c {
+	case CompressionNone, CompressionGzip, CompressionZstd:
+		return nil
+	}
+	return fmt.Errorf("unknown compression %q, expected %s or %s", c, CompressionGzip, CompressionZstd)
+}
+
+// compressionExt Returns the extension added to the compressed files, e.g. `.gz` for `out/jobs.csv.gz`
+func compressionExt(c string) string {
+	switch c {
+	case CompressionGzip:
+		return ".gz"
+	case CompressionZstd:
+		return ".zst"
+	}
+	return ""
+}
+
+// writeCompressed Calls `write` with a writer compressing to `w`. The data is compressed as it is written, it is never held in memory
+func writeCompressed(w io.Writer, c string, write func(io.Writer) error) error {
+
+	var cw io.WriteCloser
+	switch c {
+	case CompressionNone:
+		return write(w)
+	case CompressionGzip:
+		cw = gzip.NewWriter(w)
+	case CompressionZstd:
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return err
+		}
+		cw = zw
+	default:
+		return validateCompression(c)
+	}
+
+	if err := write(cw); err != nil {
+		cw.Close()
+		return err
+	}
+
+	return cw.Close()
+}
+
+// OpenOutput Opens an output file, decompressing it if its name ends with `.gz` or `.zst`.
+// Appended files hold several compressed streams one after the other, which are read as one
+func OpenOutput(fn string) (io.ReadCloser, error) {
+
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case strings.HasSuffix(fn, ".gz"):
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%s: %w", fn, err)
+		}
+		return &decompressor{Reader: zr, close: []func() error{zr.Close, f.Close}}, nil
+	case strings.HasSuffix(fn, ".zst"):
+		zr, err := zstd.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%s: %w", fn, err)
+		}
+		return &decompressor{Reader: zr, close: []func() error{func() error { zr.Close(); return nil }, f.Close}}, nil
+	}
+
+	return f, nil
+}
+
+// decompressor Reads the decompressed content of a file and closes both the decompression and the file
+type decompressor struct {
+	io.Reader
+	close []func() error
+}
+
+func (d *decompressor) Close() error {
+	var err error
+	for _, c := range d.close {
+		if cerr := c(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
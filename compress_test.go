@@ -0,0 +1,89 @@
+package thinknum
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/mehiX/thinknumV2/internal/query"
+)
+
+func TestEncodeResultJSON(t *testing.T) {
+
+	var d query.RunResult
+	d.Data.Total = 2
+	d.Data.Pages = 1
+	d.Data.Fields = []query.Field{{ID: "title", DisplayName: "Title"}}
+
+	for _, rows := range [][]query.Row{nil, {{"<b>Go</b> & Rust"}, {1.5}}} {
+		d.Data.Rows = rows
+
+		var buf bytes.Buffer
+		if err := encodeResultJSON(&buf, d); err != nil {
+			t.Fatal(err)
+		}
+
+		expected, _ := json.Marshal(d)
+		if buf.String() != string(expected) {
+			t.Errorf("Wrong JSON.\nExpected: %s\ngot:      %s", expected, buf.String())
+		}
+	}
+}
+
+func TestCompressedOutputs(t *testing.T) {
+
+	dir := t.TempDir()
+
+	first := query.RunResult{}
+	first.Data.Fields = []query.Field{{ID: "title", DisplayName: "Title"}}
+	first.Data.Rows = []query.Row{{"golang"}}
+	second := first
+	second.Data.Rows = []query.Row{{"rust"}}
+
+	for _, c := range []string{CompressionGzip, CompressionZstd} {
+		t.Run(c, func(t *testing.T) {
+
+			csvFile := filepath.Join(dir, "jobs.csv"+compressionExt(c))
+			if err := commitWrite(appendResultCSV(csvFile, first, c, false)); err != nil {
+				t.Fatal(err)
+			}
+			if err := commitWrite(appendResultCSV(csvFile, second, c, false)); err != nil {
+				t.Fatal(err)
+			}
+			if got := readOutput(t, csvFile); got != "Title\ngolang\nrust\n" {
+				t.Errorf("Wrong CSV: %q", got)
+			}
+
+			jsonFile := filepath.Join(dir, "jobs.json"+compressionExt(c))
+			if err := commitWrite(appendResultJSON(jsonFile, first, c)); err != nil {
+				t.Fatal(err)
+			}
+			if err := commitWrite(appendResultJSON(jsonFile, second, c)); err != nil {
+				t.Fatal(err)
+			}
+			var res query.RunResult
+			if err := json.Unmarshal([]byte(readOutput(t, jsonFile)), &res); err != nil {
+				t.Fatal(err)
+			}
+			if len(res.Data.Rows) != 2 || res.Data.Rows[1][0] != "rust" {
+				t.Errorf("Wrong JSON rows: %v", res.Data.Rows)
+			}
+		})
+	}
+}
+
+func readOutput(t *testing.T, fn string) string {
+	f, err := OpenOutput(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
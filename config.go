@@ -1,12 +1,8 @@
 package thinknum
 
 import (
-	"bytes"
-	"encoding/json"
-	"fmt"
 	"io/fs"
 	"io/ioutil"
-	"log"
 	"os"
 	"path/filepath"
 )
@@ -18,9 +14,37 @@ const (
 // Config Provide a configuration for the client
 type Config struct {
 	ConfigAuth
-	Workers  int                `json:"workers"`
-	PageSize int                `json:"page_size"`
-	Searches []SearchDefinition `json:"searches"`
+	Workers  int `json:"workers"`
+	PageSize int `json:"page_size"`
+	// TickerBatchSize Split the searches with more tickers in sub-searches of this many tickers, run in parallel. No limit if 0
+	TickerBatchSize int                `json:"ticker_batch_size"`
+	Searches        []SearchDefinition `json:"searches"`
+	// File where the progress of incremental searches is recorded between runs. Defaults to `.thinknum_state.json`
+	StateFile string `json:"state_file"`
+	// Ignore the recorded progress of incremental searches: fetch everything and overwrite the outputs
+	FullRefresh bool `json:"full_refresh"`
+	// RefetchIncomplete Fetch again the ranges of records missing when fewer rows than announced were fetched
+	RefetchIncomplete bool `json:"refetch_incomplete"`
+	// Strict Refuse to write the outputs of searches with incomplete results
+	Strict bool `json:"strict"`
+	// PartialResults What to do with the rows fetched by a search that fails midway: discard (default) or save
+	PartialResults string `json:"partial_results"`
+	// Report Path of the run report written by thinknumclient: Markdown for a `.md` file, JSON otherwise
+	Report string `json:"report"`
+	// FailPolicy When thinknumclient exits with an error: fail-any (default), fail-all or never
+	FailPolicy string `json:"fail_policy"`
+	// CreateDirs Create the missing directories of the output files instead of failing
+	CreateDirs bool `json:"create_dirs"`
+	// CatalogDir Directory of the tickers catalogs used to resolve the universes of the searches. Defaults to `.thinknum_catalog`
+	CatalogDir string `json:"catalog_dir"`
+	// Cache Keeps the pages of results on disk, so that running the same searches again doesn't download them again
+	Cache CacheConfig `json:"cache"`
+	// Upload Uploads the output files to an S3 compatible object storage once they are written
+	Upload UploadConfig `json:"upload"`
+	// NoCache Ignore the cache configuration: always query the API and don't store anything
+	NoCache bool `json:"-"`
+	// OnProgress Receives the progress of the running searches. It is called concurrently from all the workers
+	OnProgress func(ProgressEvent) `json:"-"`
 }
 
 // ConfigAuth Authentication parameters for the client
@@ -32,53 +56,15 @@ type ConfigAuth struct {
 	ClientSecret   string `json:"client_secret"`
 	TokenCachePath string `json:"token_cache_path"`
 	AuthEndpoint   string `json:"auth_endpoint"`
+	// Logger Receives the messages of the client and of the token handling. Nothing is logged if nil
+	Logger Logger `json:"-"`
+	// Metrics Collects metrics about the requests and the searches. Nothing is collected if nil
+	Metrics *Metrics `json:"-"`
 }
 
-// ConfigFromJSON Loads configuration data from a JSON file
+// ConfigFromJSON Loads configuration data from a file. Despite the name YAML and TOML files are read too, see LoadConfig
 func ConfigFromJSON(fn string) (*Config, error) {
-
-	var cfg Config
-
-	fi, err := os.Stat(fn)
-	if err != nil {
-		return nil, err
-	}
-
-	if fi.IsDir() || !fi.Mode().IsRegular() {
-		return nil, fmt.Errorf("Config file %s is not a regular file", fi)
-	}
-
-	all, err := ioutil.ReadFile(fn)
-	if err != nil {
-		return nil, err
-	}
-
-	if err := json.NewDecoder(bytes.NewBuffer(all)).Decode(&cfg); err != nil {
-		return nil, err
-	}
-
-	err = validate(cfg)
-
-	return &cfg, err
-}
-
-// validate Validates that the configuration is vallid
-func validate(cfg Config) error {
-	for _, s := range cfg.Searches {
-		// check that the output file is not a directory
-		info, err := os.Stat(s.OutputFile)
-		// only check if it is a directory for now. If it is a file it is probably missing since it will be created later
-		if err == nil && info.IsDir() {
-			return fmt.Errorf("outputFile for Search %s should not be a directory", s.Name)
-		}
-
-		// check that the parent directory of the output file is writable
-		if !isDirWritable(filepath.Dir(s.OutputFile)) {
-			return fmt.Errorf("cannot write to file: %s", s.OutputFile)
-		}
-	}
-
-	return nil
+	return LoadConfig(fn)
 }
 
 // permission bits
@@ -88,19 +74,36 @@ const (
 	OthersWrite
 )
 
-// isDirWritable Checks that the directory specified as parameter is writable
+// isDirWritable Checks that the directory specified as parameter is writable, by creating and removing a file in it.
+// The permission bits alone don't tell, e.g. root can write anywhere and a read-only mount refuses everyone
 func isDirWritable(dirPath string) bool {
 
-	info, err := os.Stat(dirPath)
+	f, err := ioutil.TempFile(dirPath, ".thinknum-write-check-*")
 	if err != nil {
-		log.Println(err)
-		return false
-	}
-
-	mode := info.Mode().Perm()
-	if mode&OthersWrite == 0 && mode&UserWrite == 0 && mode&GroupWrite == 0 {
 		return false
 	}
+	f.Close()
+	os.Remove(f.Name())
 
 	return true
 }
+
+// canCreateDir Checks that the directory exists and is writable or that it can be created, in its closest existing parent
+func canCreateDir(dirPath string) bool {
+
+	for {
+		info, err := os.Stat(dirPath)
+		if err == nil {
+			return info.IsDir() && isDirWritable(dirPath)
+		}
+		if !os.IsNotExist(err) {
+			return false
+		}
+
+		parent := filepath.Dir(dirPath)
+		if parent == dirPath {
+			return false
+		}
+		dirPath = parent
+	}
+}
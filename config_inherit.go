@@ -0,0 +1,197 @@
+package thinknum
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	defaultsKey = "defaults"
+	extendsKey  = "extends"
+)
+
+// notInherited The fields of a search that are never copied from the defaults or from the search it extends
+var notInherited = map[string]bool{"name": true, "disabled": true, extendsKey: true}
+
+// inheritSearches Applies the `defaults` of the configuration and the `extends` field of the searches.
+//
+// Every search starts from the defaults, then from the search named in `extends` (itself resolved first) and finally
+// sets its own fields. A field set later overrides the same field set earlier, except for the filters: the `where`
+// expressions are joined with `and` and the `request.filters` are appended. The name and `disabled` are never inherited,
+// so a disabled search can be used as a base for others
+func inheritSearches(searches []interface{}, defaults interface{}) ([]interface{}, error) {
+
+	base := map[string]interface{}{}
+	if defaults != nil {
+		d, ok := defaults.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s should be an object", defaultsKey)
+		}
+		for k := range d {
+			if notInherited[k] {
+				return nil, fmt.Errorf("%s: %s cannot have a default", defaultsKey, k)
+			}
+		}
+		base = d
+	}
+
+	byName := make(map[string]int, len(searches))
+	for i, s := range searches {
+		if name, _ := s.(map[string]interface{})["name"].(string); name != "" {
+			byName[name] = i
+		}
+	}
+
+	r := &inheritance{searches: searches, byName: byName, defaults: base, resolved: make(map[int]map[string]interface{}), resolving: make(map[int]bool)}
+
+	out := make([]interface{}, len(searches))
+	for i := range searches {
+		s, err := r.resolve(i)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = s
+	}
+
+	return out, nil
+}
+
+type inheritance struct {
+	searches  []interface{}
+	byName    map[string]int
+	defaults  map[string]interface{}
+	resolved  map[int]map[string]interface{}
+	resolving map[int]bool
+}
+
+// resolve Returns the search `i` with the fields inherited from the defaults and from the search it extends
+func (r *inheritance) resolve(i int) (map[string]interface{}, error) {
+
+	if s, ok := r.resolved[i]; ok {
+		return s, nil
+	}
+
+	own := r.searches[i].(map[string]interface{})
+	name, _ := own["name"].(string)
+
+	if r.resolving[i] {
+		return nil, fmt.Errorf("search %q: %s cycle", name, extendsKey)
+	}
+	r.resolving[i] = true
+	defer delete(r.resolving, i)
+
+	parent := r.defaults
+	if v, ok := own[extendsKey]; ok {
+		pname, _ := v.(string)
+		p, ok := r.byName[pname]
+		if !ok {
+			return nil, fmt.Errorf("search %q: %s unknown search %q", name, extendsKey, v)
+		}
+		var err error
+		if parent, err = r.resolve(p); err != nil {
+			return nil, err
+		}
+	}
+
+	s, err := mergeSearch(parent, own)
+	if err != nil {
+		return nil, fmt.Errorf("search %q: %w", name, err)
+	}
+	r.resolved[i] = s
+
+	return s, nil
+}
+
+// mergeSearch Returns a copy of `base` with the fields of `own` set on top of it. See inheritSearches
+func mergeSearch(base, own map[string]interface{}) (map[string]interface{}, error) {
+
+	s := make(map[string]interface{}, len(base)+len(own))
+	for k, v := range base {
+		if !notInherited[k] {
+			s[k] = copyValue(v)
+		}
+	}
+
+	for k, v := range own {
+		if k == extendsKey {
+			continue
+		}
+
+		switch k {
+		case "where":
+			s[k] = joinWhere(s[k], v)
+		case "request":
+			req, err := mergeRequest(s[k], v)
+			if err != nil {
+				return nil, err
+			}
+			s[k] = req
+		default:
+			s[k] = copyValue(v)
+		}
+	}
+
+	return s, nil
+}
+
+// joinWhere Joins two filter expressions with `and`
+func joinWhere(base, own interface{}) interface{} {
+	b, _ := base.(string)
+	o, ok := own.(string)
+	if !ok || strings.TrimSpace(b) == "" {
+		return own
+	}
+	if strings.TrimSpace(o) == "" {
+		return b
+	}
+	return b + " and " + o
+}
+
+// mergeRequest Appends the filters of `own` to the filters of `base`. The tickers and the other fields of `own` replace those of `base`
+func mergeRequest(base, own interface{}) (interface{}, error) {
+
+	o, ok := own.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("request should be an object")
+	}
+	b, ok := base.(map[string]interface{})
+	if !ok {
+		return copyValue(o), nil
+	}
+
+	req := copyValue(b).(map[string]interface{})
+	for k, v := range o {
+		if k != "filters" {
+			req[k] = copyValue(v)
+			continue
+		}
+
+		filters, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("request: filters should be a list")
+		}
+		inherited, _ := req[k].([]interface{})
+		req[k] = append(inherited, copyValue(filters).([]interface{})...)
+	}
+
+	return req, nil
+}
+
+// copyValue Deep copies the generic maps and lists, so that the searches never share their filters or tickers
+func copyValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			m[k] = copyValue(e)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(t))
+		for i, e := range t {
+			l[i] = copyValue(e)
+		}
+		return l
+	}
+	return v
+}
@@ -0,0 +1,378 @@
+package thinknum
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// includeKey The key listing the files merged into a configuration file
+const includeKey = "include"
+
+// LoadConfig Loads the configuration from a YAML (.yaml, .yml), TOML (.toml) or JSON file (any other extension).
+// The searches of the files listed in `include` (glob patterns, relative to the including file) are added to the searches of the file.
+// The paths of the searches, e.g. `output` and `universe.file`, are relative to the working directory, wherever the search is defined.
+// Included files contain a `searches` list, and optionally an `include` list of their own, or just a list of searches.
+// Search names must be unique across all the files. See expandSearches for the search templates and the variables.
+// Unknown keys are errors. All the problems found are returned at once as ConfigErrors
+func LoadConfig(fn string) (*Config, error) {
+	return loadConfig(fn, validate)
+}
+
+// LoadAuthConfig Loads the configuration like LoadConfig, but only validates the authentication and cache parameters.
+// For the tools running their own searches, which don't need the searches of the file to be valid
+func LoadAuthConfig(fn string) (*Config, error) {
+	return loadConfig(fn, validateAuth)
+}
+
+func loadConfig(fn string, validate func(Config) error) (*Config, error) {
+
+	fi, err := os.Stat(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	if fi.IsDir() || !fi.Mode().IsRegular() {
+		return nil, fmt.Errorf("Config file %s is not a regular file", fn)
+	}
+
+	raw, err := loadConfigMap(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	var cfg Config
+	if err := json.NewDecoder(bytes.NewReader(b)).Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	// misspelled keys would otherwise be silently ignored
+	errs := ConfigErrors(unknownFields(raw, reflect.TypeOf(cfg), ""))
+	if err := validate(cfg); err != nil {
+		errs = append(errs, err.(ConfigErrors)...)
+	}
+
+	return &cfg, errs.orNil()
+}
+
+// loadConfigMap Reads a configuration file, with the searches of its included files merged, as a generic map.
+// Search templates are expanded, see expandSearches, then the defaults and `extends` are applied, see inheritSearches, then the variables are substituted
+func loadConfigMap(fn string) (map[string]interface{}, error) {
+
+	v, err := decodeConfigFile(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: expected an object at the top level", fn)
+	}
+
+	m := &searchMerger{visited: make(map[string]bool), templates: make(map[string]interface{})}
+	if _, err := m.enter(fn); err != nil {
+		return nil, err
+	}
+
+	if err := m.add(fn, raw[searchesKey], raw[templatesKey]); err != nil {
+		return nil, err
+	}
+	if err := m.include(fn, raw[includeKey]); err != nil {
+		return nil, err
+	}
+	m.leave()
+
+	vars, err := newVariables(raw[varsKey])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	searches, params, err := expandSearches(m.searches, m.files, m.templates, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	searches, err = inheritSearches(searches, raw[defaultsKey])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	// the placeholders are substituted once the defaults and the fields of the extended searches are applied,
+	// so that they can use placeholders too, e.g. `output: out/{{name}}` or the parameters of a template
+	for i, s := range searches {
+		search := s.(map[string]interface{})
+		if searches[i], err = vars.expand(search, params[i]); err != nil {
+			return nil, fmt.Errorf("%s: search %q: %w", fn, search["name"], err)
+		}
+		if err := filterValueStrings(searches[i]); err != nil {
+			return nil, fmt.Errorf("%s: search %q: %w", fn, search["name"], err)
+		}
+	}
+
+	raw[searchesKey] = searches
+	delete(raw, includeKey)
+	delete(raw, templatesKey)
+	delete(raw, varsKey)
+	delete(raw, defaultsKey)
+
+	return raw, nil
+}
+
+const (
+	searchesKey  = "searches"
+	templatesKey = "templates"
+	varsKey      = "vars"
+)
+
+// filterValueStrings Converts the numbers and booleans of the filter values of a search to strings, as the API expects them,
+// e.g. `value: [4]` in YAML or `"value": [4]` in JSON
+func filterValueStrings(search interface{}) error {
+
+	s, _ := search.(map[string]interface{})
+	req, _ := s["request"].(map[string]interface{})
+	filters, _ := req["filters"].([]interface{})
+
+	for i, f := range filters {
+		filter, _ := f.(map[string]interface{})
+		values, _ := filter["value"].([]interface{})
+		for j, v := range values {
+			str, err := scalarString(v)
+			if err != nil {
+				return fmt.Errorf("request.filters.%d.value.%d: %w", i, j, err)
+			}
+			values[j] = str
+		}
+	}
+
+	return nil
+}
+
+// searchMerger Collects the searches and the templates of all the files, making sure that every file is read once
+type searchMerger struct {
+	// visited The files already merged, a file included by several files is only merged once
+	visited map[string]bool
+	// reading The files being read, the including files before the included ones
+	reading  []string
+	searches []interface{}
+	// files The file defining each search
+	files     []string
+	templates map[string]interface{}
+	// origin The file defining each template
+	origin map[string]string
+}
+
+// enter Records that the file is being read. Returns false if the file was already merged, through another include.
+// Fails on include cycles: a file including itself, directly or not
+func (m *searchMerger) enter(fn string) (bool, error) {
+	abs, err := filepath.Abs(fn)
+	if err != nil {
+		return false, err
+	}
+	for i, r := range m.reading {
+		if r == abs {
+			return false, fmt.Errorf("include cycle: %s", strings.Join(append(m.reading[i:], abs), " -> "))
+		}
+	}
+	if m.visited[abs] {
+		return false, nil
+	}
+	m.visited[abs] = true
+	m.reading = append(m.reading, abs)
+	return true, nil
+}
+
+// leave Records that the file and its includes were read
+func (m *searchMerger) leave() {
+	m.reading = m.reading[:len(m.reading)-1]
+}
+
+// add Adds the searches and the templates defined in `fn`
+func (m *searchMerger) add(fn string, searches, templates interface{}) error {
+
+	if templates != nil {
+		tmpl, ok := templates.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: templates should be an object", fn)
+		}
+		if m.origin == nil {
+			m.origin = make(map[string]string)
+		}
+		for name, t := range tmpl {
+			if prev, ok := m.origin[name]; ok {
+				return fmt.Errorf("duplicate template name %q in %s and %s", name, prev, fn)
+			}
+			m.origin[name] = fn
+			m.templates[name] = t
+		}
+	}
+
+	if searches == nil {
+		return nil
+	}
+
+	list, ok := searches.([]interface{})
+	if !ok {
+		return fmt.Errorf("%s: searches should be a list", fn)
+	}
+
+	for _, s := range list {
+		m.searches = append(m.searches, s)
+		m.files = append(m.files, fn)
+	}
+
+	return nil
+}
+
+// include Reads the files matching the patterns of the `include` list of `fn` and adds their searches
+func (m *searchMerger) include(fn string, v interface{}) error {
+	if v == nil {
+		return nil
+	}
+
+	var patterns []string
+	switch t := v.(type) {
+	case string:
+		patterns = []string{t}
+	case []interface{}:
+		for _, p := range t {
+			s, ok := p.(string)
+			if !ok {
+				return fmt.Errorf("%s: include should be a list of file patterns", fn)
+			}
+			patterns = append(patterns, s)
+		}
+	default:
+		return fmt.Errorf("%s: include should be a list of file patterns", fn)
+	}
+
+	dir := filepath.Dir(fn)
+	for _, p := range patterns {
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(dir, p)
+		}
+
+		files, err := filepath.Glob(p)
+		if err != nil {
+			return fmt.Errorf("%s: include %q: %w", fn, p, err)
+		}
+		if len(files) == 0 {
+			return fmt.Errorf("%s: include %q: no such file", fn, p)
+		}
+		sort.Strings(files)
+
+		for _, f := range files {
+			if err := m.includeFile(f); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// includeFile Adds the searches and the templates of an included file, and of the files it includes
+func (m *searchMerger) includeFile(fn string) error {
+
+	if ok, err := m.enter(fn); !ok || err != nil {
+		return err
+	}
+	defer m.leave()
+
+	v, err := decodeConfigFile(fn)
+	if err != nil {
+		return err
+	}
+
+	var searches, templates, includes interface{}
+	switch t := v.(type) {
+	case []interface{}:
+		searches = t
+	case map[string]interface{}:
+		for k := range t {
+			if k != searchesKey && k != templatesKey && k != includeKey {
+				return fmt.Errorf("%s: included files can only contain %s, %s and %s, found %q", fn, searchesKey, templatesKey, includeKey, k)
+			}
+		}
+		searches, templates, includes = t[searchesKey], t[templatesKey], t[includeKey]
+	default:
+		return fmt.Errorf("%s: expected a list of searches or an object with searches", fn)
+	}
+
+	if err := m.add(fn, searches, templates); err != nil {
+		return err
+	}
+
+	return m.include(fn, includes)
+}
+
+// decodeConfigFile Decodes a JSON, YAML or TOML file, depending on its extension, into generic maps and lists
+func decodeConfigFile(fn string) (interface{}, error) {
+
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	var v interface{}
+	switch strings.ToLower(filepath.Ext(fn)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &v)
+		v = normalize(v)
+	case ".toml":
+		var m map[string]interface{}
+		_, err = toml.Decode(string(b), &m)
+		v = normalize(m)
+	default:
+		// JSON, whatever the extension, as before the other formats were supported
+		err = json.Unmarshal(b, &v)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return v, nil
+}
+
+// normalize Converts the values decoded from YAML and TOML to the generic maps, lists and scalars of JSON.
+// Dates are turned back into strings, e.g. `as_of_date >= 2020-01-01` keeps its date as written
+func normalize(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, e := range t {
+			t[k] = normalize(e)
+		}
+		return t
+	case []map[string]interface{}:
+		l := make([]interface{}, len(t))
+		for i, e := range t {
+			l[i] = normalize(e)
+		}
+		return l
+	case []interface{}:
+		for i, e := range t {
+			t[i] = normalize(e)
+		}
+		return t
+	case time.Time:
+		if t.Equal(t.Truncate(24 * time.Hour)) {
+			return t.Format("2006-01-02")
+		}
+		return t.Format(time.RFC3339)
+	}
+	return v
+}
@@ -0,0 +1,257 @@
+package thinknum
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		fn := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(fn), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(fn, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	if err := os.Mkdir(out, 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	writeFiles(t, dir, map[string]string{
+		"config.yaml": `
+# authentication
+hostname: data.thinknum.com
+client_id: id
+client_secret: secret
+workers: 4
+page_size: 1000
+include:
+  - teams/*.toml
+  - teams/*.json
+searches:
+  - name: golang jobs
+    dataset: job_listings
+    output: ` + out + `/golang
+    output_types: [csv]
+    where: as_of_date >= 2021-01-01
+`,
+		"teams/research.toml": `
+include = ["../more/stores.yml"]
+
+# the research team searches
+[[searches]]
+name = "apple jobs"
+dataset = "job_listings"
+output = "` + out + `/apple"
+output_types = ["json"]
+[searches.request]
+tickers = ["nasdaq:aapl"]
+`,
+		"teams/sales.json": `[{"name": "ibm jobs", "dataset": "job_listings", "output": "` + out + `/ibm"}]`,
+		"more/stores.yml": `
+searches:
+  - name: stores
+    dataset: store
+    output: ` + out + `/stores
+`,
+	})
+
+	cfg, err := LoadConfig(filepath.Join(dir, "config.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Hostname != "data.thinknum.com" || cfg.Workers != 4 || cfg.PageSize != 1000 {
+		t.Errorf("Wrong settings: %+v", cfg)
+	}
+
+	var names []string
+	for _, s := range cfg.Searches {
+		names = append(names, s.Name)
+	}
+	if strings.Join(names, ",") != "golang jobs,apple jobs,stores,ibm jobs" {
+		t.Errorf("Wrong searches: %v", names)
+	}
+	if cfg.Searches[0].Where != "as_of_date >= 2021-01-01" {
+		t.Errorf("Wrong where expression: %q", cfg.Searches[0].Where)
+	}
+	if ts := cfg.Searches[1].Request.Tickers; len(ts) != 1 || ts[0] != "nasdaq:aapl" {
+		t.Errorf("Wrong tickers from TOML: %v", ts)
+	}
+
+	writeFiles(t, dir, map[string]string{"teams/dup.json": `[{"name": "stores", "dataset": "store"}]`})
+	if _, err := LoadConfig(filepath.Join(dir, "config.yaml")); err == nil || !strings.Contains(err.Error(), `duplicate search name "stores"`) {
+		t.Errorf("Expected a duplicate name error, got: %v", err)
+	}
+	os.Remove(filepath.Join(dir, "teams/dup.json"))
+
+	// a file included twice is only merged once
+	writeFiles(t, dir, map[string]string{"teams/sales.json": `{"include": ["../more/stores.yml"], "searches": [{"name": "ibm jobs", "dataset": "job_listings", "output": "` + out + `/ibm"}]}`})
+	if cfg, err := LoadConfig(filepath.Join(dir, "config.yaml")); err != nil || len(cfg.Searches) != 4 {
+		t.Errorf("Expected the searches of the shared file once, got: %v", err)
+	}
+
+	writeFiles(t, dir, map[string]string{"more/stores.yml": "include: [../teams/research.toml]\n"})
+	if _, err := LoadConfig(filepath.Join(dir, "config.yaml")); err == nil || !strings.Contains(err.Error(), "include cycle") {
+		t.Errorf("Expected an include cycle error, got: %v", err)
+	}
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"out/.keep": "",
+		"config.yaml": `
+client_id: id
+client_secret: secret
+workers: 2
+page_size: 1000
+defaults:
+  output: ` + dir + `/out/{{name}}
+  output_types: [csv]
+  where: country = "US"
+searches:
+  - name: base
+    disabled: true
+    dataset: job_listings
+    request:
+      tickers: [nasdaq:aapl]
+      filters: [{column: a, type: "=", value: ["1"]}]
+  - name: child
+    extends: base
+    output_types: [json]
+    where: as_of_date >= 2021-01-01
+    request:
+      filters: [{column: b, type: "=", value: ["2"]}]
+`,
+	})
+
+	cfg, err := LoadConfig(filepath.Join(dir, "config.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base, child := cfg.Searches[0], cfg.Searches[1]
+	if !base.Disabled || child.Disabled {
+		t.Errorf("disabled should not be inherited")
+	}
+	if base.OutputFile != filepath.Join(dir, "out", "base") || child.OutputFile != filepath.Join(dir, "out", "child") {
+		t.Errorf("Wrong outputs: %s, %s", base.OutputFile, child.OutputFile)
+	}
+	if child.DatasetID != "job_listings" || len(child.OutputTypes) != 1 || child.OutputTypes[0] != "json" {
+		t.Errorf("Wrong inherited fields: %+v", child)
+	}
+	if child.Where != `country = "US" and as_of_date >= 2021-01-01` {
+		t.Errorf("Wrong where expression: %q", child.Where)
+	}
+	if fs := child.Request.Filters; len(fs) != 2 || fs[0].Column != "a" || fs[1].Column != "b" || len(base.Request.Filters) != 1 {
+		t.Errorf("Wrong filters: %v, base: %v", fs, base.Request.Filters)
+	}
+
+	writeFiles(t, dir, map[string]string{"config.yaml": `
+searches:
+  - {name: a, extends: b, dataset: x}
+  - {name: b, extends: a, dataset: x}
+`})
+	if _, err := LoadConfig(filepath.Join(dir, "config.yaml")); err == nil || !strings.Contains(err.Error(), "extends cycle") {
+		t.Errorf("Expected an extends cycle error, got: %v", err)
+	}
+
+	// the defaults can use the parameters of the templates
+	writeFiles(t, dir, map[string]string{"config.yaml": `
+client_id: id
+client_secret: secret
+workers: 2
+page_size: 1000
+templates:
+  jobs: {dataset: job_listings}
+defaults:
+  output: ` + dir + `/out/{{keyword}}
+  output_types: [csv]
+searches:
+  - {template: jobs, params: {keyword: [golang, rust]}}
+`})
+	cfg, err = LoadConfig(filepath.Join(dir, "config.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Searches) != 2 || cfg.Searches[1].OutputFile != filepath.Join(dir, "out", "rust") {
+		t.Errorf("Wrong searches: %+v", cfg.Searches)
+	}
+}
+
+func TestLoadAuthConfig(t *testing.T) {
+
+	fn := filepath.Join(t.TempDir(), "config.yaml")
+	conf := "client_id: id\nclient_secret: secret\nsearches:\n  - name: jobs\n"
+	if err := ioutil.WriteFile(fn, []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadConfig(fn); err == nil {
+		t.Error("Expected the incomplete search to be an error")
+	}
+	cfg, err := LoadAuthConfig(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ClientID != "id" {
+		t.Errorf("Wrong client_id: %s", cfg.ClientID)
+	}
+}
+
+func TestLoadConfigFilterValues(t *testing.T) {
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"out/.keep": "",
+		"config.yaml": `
+client_id: id
+client_secret: secret
+workers: 1
+page_size: 100
+searches:
+  - name: jobs
+    dataset: job_listings
+    output: ` + dir + `/out/jobs
+    request:
+      filters: [{column: level, type: "=", value: [4, true, 2.5, senior]}]
+`,
+	})
+
+	cfg, err := LoadConfig(filepath.Join(dir, "config.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := cfg.Searches[0].Request.Filters[0].Value; strings.Join(v, ",") != "4,true,2.5,senior" {
+		t.Errorf("Wrong filter values: %v", v)
+	}
+
+	writeFiles(t, dir, map[string]string{"config.yaml": `
+client_id: id
+client_secret: secret
+workers: 1
+page_size: 100
+searches:
+  - name: jobs
+    dataset: job_listings
+    output: out/jobs
+    request:
+      filters: [{column: level, type: "=", value: [[4]]}]
+`})
+	if _, err := LoadConfig(filepath.Join(dir, "config.yaml")); err == nil || !strings.Contains(err.Error(), "request.filters.0.value.0") {
+		t.Errorf("Expected an error for the list in the filter values, got: %v", err)
+	}
+}
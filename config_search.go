@@ -16,13 +16,47 @@ type SearchDefinition struct {
 	Disabled bool `json:"disabled"`
 	// Path to a file where the results will be written
 	// Since multiple formats are supported, this parameter should not have a type suffix.
-	// The suffix will be added when the file is created
+	// The suffix will be added when the file is created.
+	// The path can also be a template, e.g. `out/{dataset}/{name}/dt={run_date}/part-{slice}.{ext}`, see outputTokens
 	OutputFile string `json:"output"`
 	// Supported types: `json`, `csv`. Anything else will simply be ignored
 	OutputTypes []string `json:"output_types"`
 	DatasetID   string   `json:"dataset"`
+	// Optional partitioning of the output rows in directories by the value of a column, e.g. `as_of_date=2021-03/`
+	Partition *Partition `json:"partition,omitempty"`
+	// Write at most this many rows per file, in numbered slices. No limit if 0
+	RowsPerFile int `json:"rows_per_file,omitempty"`
+	// Compress the output files: gzip (`.csv.gz`) or zstd (`.csv.zst`). Not compressed if empty
+	Compression string `json:"compression,omitempty"`
+	// Append the rows of incremental csv outputs in place instead of rewriting a copy of the file. See stageAppend
+	AppendInPlace bool `json:"append_in_place,omitempty"`
 	// A request object as defined by the Thinknum API Docs
 	Request query.Request `json:"request"`
+	// Optional tickers added to the Request, read from a file or selected from the tickers of the dataset
+	Universe *Universe `json:"universe,omitempty"`
+	// Optional filter expression, e.g. `country = "US" and as_of_date >= 2020-01-01`
+	// The parsed filters are appended to the filters of the Request
+	Where string `json:"where,omitempty"`
+	// Only fetch the rows added since the last run and append them to the existing outputs
+	Incremental bool `json:"incremental,omitempty"`
+	// Column used to track the progress of an incremental search. Defaults to `as_of_date`
+	Watermark string `json:"watermark,omitempty"`
+	// Split the search in sub-searches of this many tickers. Overrides the ticker_batch_size of the configuration
+	TickerBatchSize int `json:"ticker_batch_size,omitempty"`
+	// Column holding the ticker of every row, e.g. `dataset__entity__entity_ticker__ticker__ticker`.
+	// With batches, the rows of every ticker are recorded with the batches, see Batch.TickerRows
+	TickerColumn string `json:"ticker_column,omitempty"`
+	// When to run the search in daemon mode (thinknumd), e.g. `30 6 * * 1-5` or `@daily`. See ParseSchedule
+	Schedule string `json:"schedule,omitempty"`
+}
+
+// WhereError A syntax error in a filter expression. Use `Caret()` to point at the offending token
+type WhereError = query.WhereError
+
+// ParseWhere Parses a filter expression into a list of filters
+// See `SearchDefinition.Where` for the expression format
+func ParseWhere(expr string) ([]query.Filter, error) {
+	return query.ParseWhere(expr)
 }
 
 type timespan struct {
@@ -41,6 +75,20 @@ func (s SearchDefinition) Clone() SearchDefinition {
 	return *newS
 }
 
+// BuildRequest Returns the request to send to the API: a copy of `Request` with the filters from `Where` appended
+func (s SearchDefinition) BuildRequest() (query.Request, error) {
+
+	req := s.Request.Clone()
+
+	filters, err := query.ParseWhere(s.Where)
+	if err != nil {
+		return req, fmt.Errorf("search %s: %w", s.Name, err)
+	}
+	req.Filters = append(req.Filters, filters...)
+
+	return req, nil
+}
+
 // Split Split the current search definition into smaller time frames.
 // It returns an array of search definitions, each having the same citeria as the original definition, plus a constraint on start and end time.
 // The `interval` parameter is of time.Duration, therefor the largest avaialable time unit is `h` (hour). So to specify a week you should translate that in hours: 7 * 24h
@@ -66,13 +114,42 @@ func (s SearchDefinition) Split(from, to time.Time, interval time.Duration) []Se
 			})
 
 		// each filter should write a different file
-		ns.OutputFile = fmt.Sprintf("%s_%03d", ns.OutputFile, index)
+		ns.OutputFile = withSuffix(ns.OutputFile, fmt.Sprintf("_%03d", index))
 		searches[index] = ns
 	}
 
 	return searches
 }
 
+// dateRange Returns the time frame covered by the search, based on its filters on the date column
+// A search without an upper bound ends at `now`. The range is unknown if the search has no lower bound
+func (s SearchDefinition) dateRange(now time.Time) (from, to time.Time, ok bool) {
+
+	req, err := s.BuildRequest()
+	if err != nil {
+		return from, to, false
+	}
+
+	to = now
+	for _, f := range req.Filters {
+		if f.Column != filterColDateName || len(f.Value) == 0 {
+			continue
+		}
+		t, err := time.Parse("2006-01-02", f.Value[0])
+		if err != nil {
+			continue
+		}
+		switch f.Type {
+		case ">=", ">":
+			from, ok = t, true
+		case "<", "<=":
+			to = t
+		}
+	}
+
+	return from, to, ok && from.Before(to)
+}
+
 // ReadSearchDefinition Reads a JSON object representing a SearchDefinition from an io.Reader.
 // Returns a SearchDefinition or a decoding error if any
 func ReadSearchDefinition(in io.Reader) (SearchDefinition, error) {
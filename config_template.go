@@ -0,0 +1,357 @@
+package thinknum
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	templateKey = "template"
+	paramsKey   = "params"
+)
+
+// placeholder A variable in a string, e.g. `{{country}}` or `{{ today-7d }}`
+var placeholder = regexp.MustCompile(`\{\{\s*([^{}]*?)\s*\}\}`)
+
+// dateExpr A date relative to today, e.g. `today`, `today-7d`, `today+2w`, `today-1m`, `today-1y`
+var dateExpr = regexp.MustCompile(`^today(?:([+-])(\d+)([dwmy]))?$`)
+
+// variables The values available to the placeholders of the configuration
+type variables struct {
+	vars  map[string]string
+	today time.Time
+}
+
+// newVariables Returns the variables of the `vars` object of the configuration
+func newVariables(v interface{}) (*variables, error) {
+
+	vs := &variables{vars: make(map[string]string), today: time.Now()}
+	if v == nil {
+		return vs, nil
+	}
+
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("vars should be an object")
+	}
+	for k, val := range m {
+		s, err := scalarString(val)
+		if err != nil {
+			return nil, fmt.Errorf("vars: %s: %w", k, err)
+		}
+		vs.vars[k] = s
+	}
+
+	return vs, nil
+}
+
+// lookup Returns the value of a placeholder. In order: the parameters of the search, the name of the search,
+// the configuration vars, environment variables (`env.HOME`) and dates relative to today (`today-7d`)
+func (vs *variables) lookup(name string, params map[string]string, search string) (string, error) {
+
+	if v, ok := params[name]; ok {
+		return v, nil
+	}
+	if name == "name" && search != "" {
+		return search, nil
+	}
+	if v, ok := vs.vars[name]; ok {
+		return v, nil
+	}
+	if strings.HasPrefix(name, "env.") {
+		v, ok := os.LookupEnv(strings.TrimPrefix(name, "env."))
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", strings.TrimPrefix(name, "env."))
+		}
+		return v, nil
+	}
+	if m := dateExpr.FindStringSubmatch(name); m != nil {
+		return relativeDate(vs.today, m[1], m[2], m[3]).Format("2006-01-02"), nil
+	}
+
+	return "", fmt.Errorf("unknown variable {{%s}}", name)
+}
+
+func relativeDate(today time.Time, sign, n, unit string) time.Time {
+	if sign == "" {
+		return today
+	}
+
+	v, _ := strconv.Atoi(n)
+	if sign == "-" {
+		v = -v
+	}
+
+	switch unit {
+	case "w":
+		return today.AddDate(0, 0, 7*v)
+	case "m":
+		return today.AddDate(0, v, 0)
+	case "y":
+		return today.AddDate(v, 0, 0)
+	default:
+		return today.AddDate(0, 0, v)
+	}
+}
+
+// substitute Returns a copy of `v` with the placeholders of all the strings replaced
+func (vs *variables) substitute(v interface{}, params map[string]string, search string) (interface{}, error) {
+	switch t := v.(type) {
+	case string:
+		var err error
+		s := placeholder.ReplaceAllStringFunc(t, func(p string) string {
+			val, lerr := vs.lookup(placeholder.FindStringSubmatch(p)[1], params, search)
+			if lerr != nil && err == nil {
+				err = lerr
+			}
+			return val
+		})
+		return s, err
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			ne, err := vs.substitute(e, params, search)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			m[k] = ne
+		}
+		return m, nil
+	case []interface{}:
+		l := make([]interface{}, len(t))
+		for i, e := range t {
+			ne, err := vs.substitute(e, params, search)
+			if err != nil {
+				return nil, err
+			}
+			l[i] = ne
+		}
+		return l, nil
+	}
+	return v, nil
+}
+
+// expandSearches Instantiates the templated searches and substitutes the variables of all the searches.
+//
+// A search with a `template` gets all the fields of the template, unless it sets them itself. Its `params` give the values
+// of the placeholders of the template: a parameter with a list of values creates one search per value, several lists create
+// one search per combination. Placeholders are written `{{param}}` and also accept the name of the search (`{{name}}`),
+// the configuration `vars`, environment variables (`{{env.HOME}}`) and dates relative to today (`{{today}}`, `{{today-7d}}`).
+// The tokens of the output paths in single braces, e.g. `{run_date}`, are left for the writes, see outputTokens.
+// Search names must be unique after the expansion.
+// Only the names are substituted here, so that the searches can be found by name for `extends`. Returns the parameters of every
+// search, to substitute the other fields once the defaults and `extends` are applied, see expand
+func expandSearches(searches []interface{}, files []string, templates map[string]interface{}, vs *variables) ([]interface{}, []map[string]string, error) {
+
+	var expanded []interface{}
+	var params []map[string]string
+	origin := make(map[string]string)
+
+	for i, s := range searches {
+		fn := files[i]
+
+		obj, ok := s.(map[string]interface{})
+		if !ok {
+			return nil, nil, fmt.Errorf("%s: search %d should be an object", fn, i+1)
+		}
+
+		instances, err := instantiate(obj, templates)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: search %d: %w", fn, i+1, err)
+		}
+
+		for _, inst := range instances {
+			search := make(map[string]interface{}, len(inst.search))
+			for k, v := range inst.search {
+				search[k] = v
+			}
+			if v, ok := search["name"]; ok {
+				if search["name"], err = vs.substitute(v, inst.params, ""); err != nil {
+					return nil, nil, fmt.Errorf("%s: search %d: name: %w", fn, i+1, err)
+				}
+			}
+
+			name, _ := search["name"].(string)
+			if name != "" {
+				if prev, ok := origin[name]; ok {
+					return nil, nil, fmt.Errorf("duplicate search name %q in %s and %s", name, prev, fn)
+				}
+				origin[name] = fn
+			}
+
+			expanded = append(expanded, search)
+			params = append(params, inst.params)
+		}
+	}
+
+	return expanded, params, nil
+}
+
+type instance struct {
+	search map[string]interface{}
+	params map[string]string
+}
+
+// instantiate Returns the searches defined by `obj`: itself if it doesn't use a template, otherwise one per combination of the parameters
+func instantiate(obj map[string]interface{}, templates map[string]interface{}) ([]instance, error) {
+
+	tmplName, hasTemplate := obj[templateKey]
+	if !hasTemplate {
+		if _, ok := obj[paramsKey]; ok {
+			return nil, fmt.Errorf("params without a template")
+		}
+		return []instance{{search: obj}}, nil
+	}
+
+	name, _ := tmplName.(string)
+	t, ok := templates[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown template %q", name)
+	}
+	tmpl, ok := t.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("template %q should be an object", name)
+	}
+
+	combinations, err := paramCombinations(obj[paramsKey])
+	if err != nil {
+		return nil, err
+	}
+
+	var instances []instance
+	for _, params := range combinations {
+		search := make(map[string]interface{}, len(tmpl)+len(obj))
+		for k, v := range tmpl {
+			search[k] = v
+		}
+		for k, v := range obj {
+			if k != templateKey && k != paramsKey {
+				search[k] = v
+			}
+		}
+
+		// without a name the instances are named after the template and their parameters
+		if _, ok := search["name"]; !ok {
+			search["name"] = defaultInstanceName(name, params)
+		}
+
+		instances = append(instances, instance{search: search, params: params})
+	}
+
+	return instances, nil
+}
+
+// paramCombinations Returns every combination of the values of the parameters, the first parameter (in alphabetical order) changing slowest
+func paramCombinations(v interface{}) ([]map[string]string, error) {
+
+	if v == nil {
+		return []map[string]string{{}}, nil
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("params should be an object")
+	}
+
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	combinations := []map[string]string{{}}
+	for _, k := range keys {
+		var values []string
+		switch t := m[k].(type) {
+		case []interface{}:
+			for _, e := range t {
+				s, err := scalarString(e)
+				if err != nil {
+					return nil, fmt.Errorf("params: %s: %w", k, err)
+				}
+				values = append(values, s)
+			}
+		default:
+			s, err := scalarString(t)
+			if err != nil {
+				return nil, fmt.Errorf("params: %s: %w", k, err)
+			}
+			values = []string{s}
+		}
+		if len(values) == 0 {
+			return nil, fmt.Errorf("params: %s has no values", k)
+		}
+
+		var next []map[string]string
+		for _, c := range combinations {
+			for _, val := range values {
+				nc := make(map[string]string, len(c)+1)
+				for ck, cv := range c {
+					nc[ck] = cv
+				}
+				nc[k] = val
+				next = append(next, nc)
+			}
+		}
+		combinations = next
+	}
+
+	return combinations, nil
+}
+
+func defaultInstanceName(template string, params map[string]string) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := []string{template}
+	for _, k := range keys {
+		parts = append(parts, params[k])
+	}
+	return strings.Join(parts, "_")
+}
+
+// expand Substitutes the placeholders of all the fields of the search but its name, which expandSearches already substituted.
+// Every placeholder is substituted once: values containing `{{` are kept as they are
+func (vs *variables) expand(search map[string]interface{}, params map[string]string) (map[string]interface{}, error) {
+
+	name, _ := search["name"].(string)
+
+	expanded := make(map[string]interface{}, len(search))
+	for k, v := range search {
+		if k == "name" {
+			expanded[k] = v
+			continue
+		}
+		nv, err := vs.substitute(v, params, name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", k, err)
+		}
+		expanded[k] = nv
+	}
+
+	return expanded, nil
+}
+
+// scalarString Formats a parameter or variable value, which must be a string, a number or a boolean
+func scalarString(v interface{}) (string, error) {
+	switch t := v.(type) {
+	case string:
+		return t, nil
+	case bool:
+		return strconv.FormatBool(t), nil
+	case int:
+		return strconv.Itoa(t), nil
+	case int64:
+		return strconv.FormatInt(t, 10), nil
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64), nil
+	}
+	return "", fmt.Errorf("expected a string, a number or a boolean, got %v", v)
+}
@@ -0,0 +1,89 @@
+package thinknum
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpandSearches(t *testing.T) {
+
+	os.Setenv("TN_TEST_OUT", "/data")
+	defer os.Unsetenv("TN_TEST_OUT")
+
+	templates := map[string]interface{}{
+		"jobs_by_keyword": map[string]interface{}{
+			"dataset": "job_listings",
+			"where":   `description (...) ["{{keyword}}"] and country = "{{country}}" and as_of_date >= {{today-7d}}`,
+			"output":  "{{env.TN_TEST_OUT}}/{{team}}/{{name}}",
+		},
+	}
+	searches := []interface{}{
+		map[string]interface{}{
+			"template": "jobs_by_keyword",
+			"params":   map[string]interface{}{"keyword": []interface{}{"golang", "rust"}, "country": []interface{}{"US", "DE"}},
+		},
+		map[string]interface{}{
+			"name":     "{{team}} stores",
+			"template": "jobs_by_keyword",
+			"dataset":  "store",
+			"params":   map[string]interface{}{"keyword": "coffee", "country": "FR"},
+		},
+	}
+
+	vs, err := newVariables(map[string]interface{}{"team": "research"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vs.today = time.Date(2021, 3, 5, 10, 0, 0, 0, time.UTC)
+
+	got, params, err := expandSearches(searches, []string{"a.yaml", "a.yaml"}, templates, vs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, s := range got {
+		if got[i], err = vs.expand(s.(map[string]interface{}), params[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var names []string
+	for _, s := range got {
+		names = append(names, s.(map[string]interface{})["name"].(string))
+	}
+	expected := "jobs_by_keyword_US_golang,jobs_by_keyword_US_rust,jobs_by_keyword_DE_golang,jobs_by_keyword_DE_rust,research stores"
+	if strings.Join(names, ",") != expected {
+		t.Errorf("Wrong searches. Expected: %s, got: %s", expected, strings.Join(names, ","))
+	}
+
+	first := got[0].(map[string]interface{})
+	if w := first["where"]; w != `description (...) ["golang"] and country = "US" and as_of_date >= 2021-02-26` {
+		t.Errorf("Wrong where expression: %s", w)
+	}
+	if o := first["output"]; o != "/data/research/jobs_by_keyword_US_golang" {
+		t.Errorf("Wrong output: %s", o)
+	}
+	if d := got[4].(map[string]interface{})["dataset"]; d != "store" {
+		t.Errorf("Expected the search to override the template dataset, got: %s", d)
+	}
+
+	bad := []interface{}{map[string]interface{}{"name": "x {{nope}}"}}
+	if _, _, err := expandSearches(bad, []string{"a.yaml"}, nil, vs); err == nil || !strings.Contains(err.Error(), "unknown variable {{nope}}") {
+		t.Errorf("Expected an unknown variable error, got: %v", err)
+	}
+	if _, err := vs.expand(map[string]interface{}{"name": "x", "output": "{{nope}}"}, nil); err == nil || !strings.Contains(err.Error(), "unknown variable {{nope}}") {
+		t.Errorf("Expected an unknown variable error, got: %v", err)
+	}
+
+	// values are substituted once, the braces of a value are kept
+	vs.vars["pattern"] = "{{team}}"
+	if s, err := vs.expand(map[string]interface{}{"name": "x", "where": `title = "{{pattern}}"`}, nil); err != nil || s["where"] != `title = "{{team}}"` {
+		t.Errorf("Expected the value to be substituted once, got: %v, %v", s, err)
+	}
+
+	dup := append(searches, map[string]interface{}{"name": "research stores"})
+	if _, _, err := expandSearches(dup, []string{"a.yaml", "a.yaml", "b.yaml"}, templates, vs); err == nil || !strings.Contains(err.Error(), "duplicate search name") {
+		t.Errorf("Expected a duplicate name error, got: %v", err)
+	}
+}
@@ -1,33 +1,135 @@
 package thinknum
 
 import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
+
+	"github.com/mehiX/thinknumV2/internal/query"
 )
 
 func TestCanWrite(t *testing.T) {
 
-	homePath, err := os.UserHomeDir()
-	if err != nil {
+	dir := t.TempDir()
+
+	readOnly := filepath.Join(dir, "read-only")
+	if err := os.Mkdir(readOnly, 0555); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, nil, 0666); err != nil {
 		t.Fatal(err)
 	}
 
 	var scenarios = []struct {
 		path     string
 		expected bool
+		create   bool
 	}{
-		{"/root", false},
-		{"/tmp", true},
-		{homePath, true},
-		{"out", true},
+		{"/tmp", true, true},
+		{dir, true, true},
+		// root writes anywhere, whatever the permission bits
+		{readOnly, os.Geteuid() == 0, os.Geteuid() == 0},
+		{filepath.Join(dir, "missing", "out"), false, true},
+		{filepath.Join(readOnly, "out"), false, os.Geteuid() == 0},
+		{filepath.Join(file, "out"), false, false},
 	}
 
 	for _, s := range scenarios {
 		t.Run(s.path, func(t *testing.T) {
-			got := isDirWritable(s.path)
-			if got != s.expected {
+			if got := isDirWritable(s.path); got != s.expected {
 				t.Errorf("Wrong result for %s. Expected: %v, got: %v", s.path, s.expected, got)
 			}
+			if got := canCreateDir(s.path); got != s.create {
+				t.Errorf("Wrong result for creating %s. Expected: %v, got: %v", s.path, s.create, got)
+			}
 		})
 	}
+
+	if files, _ := ioutil.ReadDir(dir); len(files) != 2 {
+		t.Errorf("The checks should not leave files behind: %v", files)
+	}
+}
+
+func TestValidate(t *testing.T) {
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"config.json": `{
+		"client_id": "id",
+		"workers": 0,
+		"page_size": 0,
+		"searches": [
+			{"name": "a", "dataset": "store", "output": "` + dir + `/a", "output_types": ["csv", "xml"]},
+			{"name": "c", "dataset": "", "output": "` + dir + `/a", "output_type": ["json"]},
+			{"name": "b", "dataset": "store", "output": "` + dir + `/b", "request": {"filter": []}}
+		]
+	}`})
+
+	_, err := LoadConfig(filepath.Join(dir, "config.json"))
+
+	var errs ConfigErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Expected ConfigErrors, got: %v", err)
+	}
+
+	expected := []string{
+		`searches[1]: unknown field "output_type"`,
+		`searches[2].request: unknown field "filter"`,
+		"client_id and client_secret are required",
+		"workers should be at least 1: 0",
+		"page_size should be between 1 and 100000: 0",
+		`search a: unknown output type "xml", expected json or csv`,
+		"search c: dataset is required",
+		"search c: same output as search a: " + dir + "/a",
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("Expected %d problems, got: %v", len(expected), err)
+	}
+	for i, e := range expected {
+		if errs[i].Error() != e {
+			t.Errorf("Wrong problem %d. Expected: %s, got: %s", i, e, errs[i])
+		}
+	}
+}
+
+// TestSchema Checks that config.schema.json knows all the fields of the configuration
+func TestSchema(t *testing.T) {
+
+	b, err := ioutil.ReadFile("config.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type object struct {
+		Properties map[string]json.RawMessage `json:"properties"`
+	}
+	var schema struct {
+		object
+		Definitions map[string]object `json:"definitions"`
+	}
+	if err := json.Unmarshal(b, &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	var scenarios = []struct {
+		name   string
+		typ    reflect.Type
+		schema object
+	}{
+		{"config", reflect.TypeOf(Config{}), schema.object},
+		{"search", reflect.TypeOf(SearchDefinition{}), schema.Definitions["search"]},
+		{"request", reflect.TypeOf(query.Request{}), schema.Definitions["request"]},
+	}
+
+	for _, s := range scenarios {
+		for field := range jsonFields(s.typ) {
+			if _, ok := s.schema.Properties[field]; !ok {
+				t.Errorf("%s: %s is missing from the schema", s.name, field)
+			}
+		}
+	}
 }
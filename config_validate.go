@@ -0,0 +1,279 @@
+package thinknum
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MaxPageSize The largest number of records the API returns in one page
+const MaxPageSize = 100000
+
+// ConfigErrors All the problems found in a configuration
+type ConfigErrors []error
+
+func (e ConfigErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = "  " + err.Error()
+	}
+	return fmt.Sprintf("%d problems in the configuration:\n%s", len(e), strings.Join(msgs, "\n"))
+}
+
+// orNil Returns nil if there is no problem, so that the result can be compared with nil
+func (e ConfigErrors) orNil() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// validateAuth Validates the credentials and the cache parameters, all that is needed to query the API
+func validateAuth(cfg Config) error {
+
+	var errs ConfigErrors
+	if cfg.ClientID == "" || cfg.ClientSecret == "" {
+		errs = append(errs, fmt.Errorf("client_id and client_secret are required"))
+	}
+	if _, err := cfg.Cache.ttl(); err != nil {
+		errs = append(errs, err)
+	}
+	if cfg.Cache.MaxSizeMB < 0 {
+		errs = append(errs, fmt.Errorf("cache max_size_mb should not be negative: %d", cfg.Cache.MaxSizeMB))
+	}
+
+	return errs.orNil()
+}
+
+// validate Validates that the configuration is vallid. All the problems are reported, not only the first one
+func validate(cfg Config) error {
+
+	var errs ConfigErrors
+	add := func(format string, a ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, a...))
+	}
+
+	if err := validateAuth(cfg); err != nil {
+		errs = append(errs, err.(ConfigErrors)...)
+	}
+
+	// without workers nothing reads the searches and the run never ends
+	if cfg.Workers < 1 {
+		add("workers should be at least 1: %d", cfg.Workers)
+	}
+
+	// an empty page never ends the search
+	if cfg.PageSize < 1 || cfg.PageSize > MaxPageSize {
+		add("page_size should be between 1 and %d: %d", MaxPageSize, cfg.PageSize)
+	}
+
+	switch cfg.PartialResults {
+	case "", PartialDiscard, PartialSave:
+	default:
+		add("unknown partial_results policy %q, expected %s or %s", cfg.PartialResults, PartialDiscard, PartialSave)
+	}
+
+	if cfg.TickerBatchSize < 0 {
+		add("ticker_batch_size should not be negative: %d", cfg.TickerBatchSize)
+	}
+
+	errs = append(errs, cfg.Upload.validate()...)
+
+	if cfg.FailPolicy != "" {
+		if err := ValidateFailPolicy(cfg.FailPolicy); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	names := make(map[string]bool)
+	outputs := make(map[string]string)
+
+	for i, s := range cfg.Searches {
+
+		if s.Name == "" {
+			add("search %d: name is required", i+1)
+		} else if names[s.Name] {
+			add("duplicate search name %q", s.Name)
+		}
+		names[s.Name] = true
+
+		if s.DatasetID == "" {
+			add("search %s: dataset is required", s.Name)
+		}
+
+		for _, t := range s.OutputTypes {
+			if t != "json" && t != "csv" {
+				add("search %s: unknown output type %q, expected json or csv", s.Name, t)
+			}
+		}
+
+		if s.OutputFile == "" {
+			add("search %s: output is required", s.Name)
+		} else if err := checkOutputPath(s.OutputFile); err != nil {
+			add("search %s: %w", s.Name, err)
+		} else {
+			// one of the files of the search, to compare the outputs of the searches and check the directory
+			sample := filepath.Clean(newOutputLayout(s, time.Now()).path("ext", "", 0))
+
+			// searches writing the same files would overwrite each other
+			if prev, ok := outputs[sample]; ok && !s.Disabled {
+				add("search %s: same output as search %s: %s", s.Name, prev, s.OutputFile)
+			} else if !s.Disabled {
+				outputs[sample] = s.Name
+			}
+
+			// check that the output file is not a directory
+			info, err := os.Stat(s.OutputFile)
+			// only check if it is a directory for now. If it is a file it is probably missing since it will be created later
+			if err == nil && info.IsDir() {
+				add("outputFile for Search %s should not be a directory", s.Name)
+			}
+
+			// check that the parent directory of the output file is writable, or can be created
+			if dir := filepath.Dir(sample); (cfg.CreateDirs || dynamicDirs(s)) && !canCreateDir(dir) {
+				add("cannot create the directory of file: %s", s.OutputFile)
+			} else if !cfg.CreateDirs && !dynamicDirs(s) && !isDirWritable(dir) {
+				add("cannot write to file: %s (create the directory or set create_dirs)", s.OutputFile)
+			}
+		}
+
+		if s.Partition != nil {
+			if err := s.Partition.validate(); err != nil {
+				add("search %s: %w", s.Name, err)
+			}
+		}
+
+		if err := validateCompression(s.Compression); err != nil {
+			add("search %s: %w", s.Name, err)
+		}
+
+		if s.RowsPerFile < 0 {
+			add("search %s: rows_per_file should not be negative", s.Name)
+		}
+
+		// fail early on filter expressions that would fail at run time
+		if _, err := s.BuildRequest(); err != nil {
+			errs = append(errs, err)
+		}
+
+		if s.Universe != nil && s.Universe.File != "" {
+			if _, err := os.Stat(s.Universe.File); err != nil {
+				add("search %s: universe file: %w", s.Name, err)
+			}
+		}
+
+		if s.TickerBatchSize < 0 {
+			add("search %s: ticker_batch_size should not be negative", s.Name)
+		}
+
+		if s.Schedule != "" {
+			if _, err := ParseSchedule(s.Schedule); err != nil {
+				add("search %s: %w", s.Name, err)
+			}
+		}
+	}
+
+	return errs.orNil()
+}
+
+// unknownFields Returns the keys of the decoded configuration `v` that don't match a field of the type `t`, e.g. a misspelled
+// `output_type`. `path` locates `v` in the configuration
+func unknownFields(v interface{}, t reflect.Type, path string) []error {
+
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+
+		fields := jsonFields(t)
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		var errs []error
+		for _, k := range keys {
+			ft, ok := fields[k]
+			if !ok {
+				errs = append(errs, fmt.Errorf("%sunknown field %q", prefix(path), k))
+				continue
+			}
+			errs = append(errs, unknownFields(m[k], ft, join(path, k))...)
+		}
+		return errs
+
+	case reflect.Slice:
+		l, ok := v.([]interface{})
+		if !ok {
+			return nil
+		}
+
+		var errs []error
+		for i, e := range l {
+			errs = append(errs, unknownFields(e, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+		return errs
+	}
+
+	return nil
+}
+
+// jsonFields Returns the type of the fields of the struct by their JSON name, including the fields of the embedded structs
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+
+		if f.Anonymous && name == "" {
+			for k, ft := range jsonFields(f.Type) {
+				fields[k] = ft
+			}
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f.Type
+	}
+
+	return fields
+}
+
+func prefix(path string) string {
+	if path == "" {
+		return ""
+	}
+	return path + ": "
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
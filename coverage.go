@@ -0,0 +1,229 @@
+package thinknum
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mehiX/thinknumV2/internal/query"
+)
+
+// CoverageOptions How the datasets of many tickers are requested
+type CoverageOptions struct {
+	// Workers Number of concurrent requests. Defaults to 5
+	Workers int
+	// Rate Maximum number of requests per second, for all the workers together. No limit if 0
+	Rate float64
+	// Retries Number of times a request is sent again when the API answers that too many requests were sent
+	Retries int
+	// OnTicker Called when the datasets of a ticker are known, or the request failed. Optional
+	OnTicker func(ticker string, err error)
+}
+
+// Coverage Which datasets cover each ticker
+type Coverage struct {
+	Tickers []string
+	// Datasets All the datasets covering at least one ticker, sorted by ID
+	Datasets []query.DatasetItem
+	// Covered The IDs of the datasets covering each ticker
+	Covered map[string]map[string]bool
+	// Errors The tickers whose datasets could not be requested
+	Errors map[string]error
+}
+
+// DatasetCoverage How many of the tickers a dataset covers
+type DatasetCoverage struct {
+	Dataset     string  `json:"dataset"`
+	DisplayName string  `json:"display_name"`
+	Tickers     int     `json:"tickers"`
+	Percent     float64 `json:"percent"`
+}
+
+// FetchCoverage Requests the datasets of every ticker, concurrently and within the rate limit
+func FetchCoverage(c Client, tickers []string, opts CoverageOptions) Coverage {
+
+	if opts.Workers <= 0 {
+		opts.Workers = 5
+	}
+
+	cv := Coverage{
+		Tickers: tickers,
+		Covered: make(map[string]map[string]bool),
+		Errors:  make(map[string]error),
+	}
+
+	// one tick per allowed request
+	var limit <-chan time.Time
+	if opts.Rate > 0 {
+		t := time.NewTicker(time.Duration(float64(time.Second) / opts.Rate))
+		defer t.Stop()
+		limit = t.C
+	}
+
+	var mu sync.Mutex
+	datasets := make(map[string]query.DatasetItem)
+
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ticker := range jobs {
+				ds, err := tickerDatasets(c, ticker, limit, opts.Retries)
+
+				mu.Lock()
+				if err != nil {
+					cv.Errors[ticker] = err
+				} else {
+					covered := make(map[string]bool, len(ds))
+					for _, d := range ds {
+						covered[d.ID] = true
+						datasets[d.ID] = d
+					}
+					cv.Covered[ticker] = covered
+				}
+				mu.Unlock()
+
+				if opts.OnTicker != nil {
+					opts.OnTicker(ticker, err)
+				}
+			}
+		}()
+	}
+
+	for _, t := range tickers {
+		jobs <- t
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, d := range datasets {
+		cv.Datasets = append(cv.Datasets, d)
+	}
+	sort.Slice(cv.Datasets, func(i, j int) bool { return cv.Datasets[i].ID < cv.Datasets[j].ID })
+
+	return cv
+}
+
+// tickerDatasets Requests the datasets of one ticker, waiting for the rate limit before every request
+func tickerDatasets(c Client, ticker string, limit <-chan time.Time, retries int) ([]query.DatasetItem, error) {
+	for attempt := 0; ; attempt++ {
+		if limit != nil {
+			<-limit
+		}
+
+		ds, err := c.Datasets(ticker)
+		if err == nil || !errors.Is(err, ErrRateLimited) || attempt >= retries {
+			return ds, err
+		}
+
+		// back off before trying again
+		time.Sleep(time.Duration(attempt+1) * time.Second)
+	}
+}
+
+// Summary Returns the number and the share of the tickers covered by each dataset, the best covering first
+func (cv Coverage) Summary() []DatasetCoverage {
+
+	var sum []DatasetCoverage
+	for _, d := range cv.Datasets {
+		n := 0
+		for _, covered := range cv.Covered {
+			if covered[d.ID] {
+				n++
+			}
+		}
+		dc := DatasetCoverage{Dataset: d.ID, DisplayName: d.DisplayName, Tickers: n}
+		if len(cv.Tickers) > 0 {
+			dc.Percent = 100 * float64(n) / float64(len(cv.Tickers))
+		}
+		sum = append(sum, dc)
+	}
+
+	sort.SliceStable(sum, func(i, j int) bool { return sum[i].Tickers > sum[j].Tickers })
+
+	return sum
+}
+
+// WriteCoverageCSV Writes the coverage matrix as CSV: one line per ticker, one column per dataset, with 1 when the dataset covers the ticker.
+// The cells of the tickers whose datasets could not be requested are empty
+func WriteCoverageCSV(w io.Writer, cv Coverage) error {
+
+	cw := csv.NewWriter(w)
+
+	header := []string{"ticker"}
+	for _, d := range cv.Datasets {
+		header = append(header, d.ID)
+	}
+	cw.Write(header)
+
+	for _, t := range cv.Tickers {
+		line := []string{t}
+		covered, ok := cv.Covered[t]
+		for _, d := range cv.Datasets {
+			switch {
+			case !ok:
+				line = append(line, "")
+			case covered[d.ID]:
+				line = append(line, "1")
+			default:
+				line = append(line, "0")
+			}
+		}
+		cw.Write(line)
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteCoverageJSON Writes the datasets covering each ticker, the summary per dataset and the errors as a JSON object
+func WriteCoverageJSON(w io.Writer, cv Coverage) error {
+
+	type tickerCoverage struct {
+		Ticker   string   `json:"ticker"`
+		Datasets []string `json:"datasets"`
+		Error    string   `json:"error,omitempty"`
+	}
+
+	out := struct {
+		Tickers []tickerCoverage  `json:"tickers"`
+		Summary []DatasetCoverage `json:"summary"`
+	}{Summary: cv.Summary()}
+
+	for _, t := range cv.Tickers {
+		tc := tickerCoverage{Ticker: t, Datasets: []string{}}
+		if err, ok := cv.Errors[t]; ok {
+			tc.Error = err.Error()
+		}
+		for _, d := range cv.Datasets {
+			if cv.Covered[t][d.ID] {
+				tc.Datasets = append(tc.Datasets, d.ID)
+			}
+		}
+		out.Tickers = append(out.Tickers, tc)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "    ")
+	return enc.Encode(out)
+}
+
+// WriteCoverageSummary Writes the summary per dataset as a text table
+func WriteCoverageSummary(w io.Writer, cv Coverage) error {
+
+	for _, s := range cv.Summary() {
+		if _, err := fmt.Fprintf(w, "%-40s %5d/%d  %5.1f%%  %s\n", s.Dataset, s.Tickers, len(cv.Tickers), s.Percent, s.DisplayName); err != nil {
+			return err
+		}
+	}
+
+	_, err := fmt.Fprintf(w, "%d tickers, %d datasets, %d errors\n", len(cv.Tickers), len(cv.Datasets), len(cv.Errors))
+	return err
+}
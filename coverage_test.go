@@ -0,0 +1,55 @@
+package thinknum
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/mehiX/thinknumV2/internal/query"
+)
+
+// datasetsClient Answers the datasets requests from a map, the other methods are not implemented
+type datasetsClient struct {
+	Client
+	datasets map[string][]query.DatasetItem
+}
+
+func (c datasetsClient) Datasets(ticker string) ([]query.DatasetItem, error) {
+	ds, ok := c.datasets[ticker]
+	if !ok {
+		return nil, &APIError{Endpoint: query.EndpointDatasets, StatusCode: 400, Message: "unknown ticker"}
+	}
+	return ds, nil
+}
+
+func TestCoverage(t *testing.T) {
+
+	jobs := query.DatasetItem{ID: "job_listings", DisplayName: "Job Listings"}
+	stores := query.DatasetItem{ID: "store", DisplayName: "Stores"}
+
+	c := datasetsClient{datasets: map[string][]query.DatasetItem{
+		"nasdaq:aapl": {jobs, stores},
+		"nyse:ibm":    {jobs},
+	}}
+
+	cv := FetchCoverage(c, []string{"nasdaq:aapl", "nyse:ibm", "xxx:none"}, CoverageOptions{Workers: 2})
+
+	if len(cv.Errors) != 1 || cv.Errors["xxx:none"] == nil {
+		t.Errorf("Expected an error for the unknown ticker. Got: %v", cv.Errors)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteCoverageCSV(&buf, cv); err != nil {
+		t.Fatal(err)
+	}
+	expected := "ticker,job_listings,store\nnasdaq:aapl,1,1\nnyse:ibm,1,0\nxxx:none,,\n"
+	if buf.String() != expected {
+		t.Errorf("Wrong matrix. Expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+
+	sum := cv.Summary()
+	got := fmt.Sprintf("%s %d, %s %d", sum[0].Dataset, sum[0].Tickers, sum[1].Dataset, sum[1].Tickers)
+	if got != "job_listings 2, store 1" {
+		t.Errorf("Wrong summary: %s", got)
+	}
+}
@@ -0,0 +1,18 @@
+package thinknum
+
+import "github.com/mehiX/thinknumV2/internal/query"
+
+// APIError The API responded with an unexpected status. Use `errors.Is` with the sentinel errors below to check the cause
+type APIError = query.APIError
+
+// DecodeError The response of the API could not be decoded
+type DecodeError = query.DecodeError
+
+// Errors matched by an *APIError, depending on the response status
+var (
+	ErrUnauthorized    = query.ErrUnauthorized
+	ErrRateLimited     = query.ErrRateLimited
+	ErrDatasetNotFound = query.ErrDatasetNotFound
+	ErrBadRequest      = query.ErrBadRequest
+	ErrServer          = query.ErrServer
+)
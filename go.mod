@@ -2,4 +2,9 @@ module github.com/mehiX/thinknumV2
 
 go 1.16
 
-require github.com/microcosm-cc/bluemonday v1.0.9 // indirect
+require (
+	github.com/BurntSushi/toml v1.3.2
+	github.com/klauspost/compress v1.15.9
+	github.com/microcosm-cc/bluemonday v1.0.9
+	gopkg.in/yaml.v3 v3.0.1
+)
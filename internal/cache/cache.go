@@ -0,0 +1,279 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	entryExt = ".json"
+	infoExt  = ".info"
+)
+
+// Cache Stores the bodies of API responses on disk, one file per entry
+type Cache struct {
+	Dir string
+	// TTL Entries older than this are ignored and removed. No expiry if 0
+	TTL time.Duration
+	// MaxBytes The oldest entries are removed when the entries use more space. No limit if 0
+	MaxBytes int64
+
+	mu sync.Mutex
+	// size The bytes used by the entries, counted from the first Put and corrected by every prune, see Put
+	size  int64
+	sized bool
+}
+
+// Entry One response stored in the cache
+type Entry struct {
+	Key     string
+	Path    string
+	Size    int64
+	ModTime time.Time
+	// Info What the entry holds. Empty for the entries stored without it
+	Info Info
+}
+
+// Info Describes the request of an entry, which its key, a hash, doesn't tell. Stored next to the entry
+type Info struct {
+	Dataset string `json:"dataset"`
+	Start   int    `json:"start"`
+	Limit   int    `json:"limit"`
+}
+
+// New Returns a cache storing its entries in `dir`, which is created if needed
+func New(dir string, ttl time.Duration, maxBytes int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+
+	return &Cache{Dir: dir, TTL: ttl, MaxBytes: maxBytes}, nil
+}
+
+// Key Returns the key of an entry, a hash of all the parts
+func Key(parts ...string) string {
+	h := sha256.New()
+	for _, p := range parts {
+		h.Write([]byte(p))
+		// separate the parts so that ("ab", "c") and ("a", "bc") differ
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.Dir, key+entryExt)
+}
+
+func (c *Cache) infoPath(key string) string {
+	return filepath.Join(c.Dir, key+infoExt)
+}
+
+// remove Removes the entry and its info. Returns the error of the entry, the info is only a description of it
+func (c *Cache) remove(key string) error {
+	os.Remove(c.infoPath(key))
+	return os.Remove(c.path(key))
+}
+
+// Get Returns the entry stored for `key`, if there is one and it didn't expire
+func (c *Cache) Get(key string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	fn := c.path(key)
+
+	fi, err := os.Stat(fn)
+	if err != nil {
+		return nil, false
+	}
+	if c.expired(fi.ModTime(), time.Now()) {
+		c.mu.Lock()
+		if c.remove(key) == nil && c.sized {
+			c.size -= fi.Size()
+		}
+		c.mu.Unlock()
+		return nil, false
+	}
+
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, false
+	}
+
+	return b, true
+}
+
+// Put Stores the entry for `key`, described by `info`, then removes the oldest entries if the cache is over its size limit.
+// The size of the cache is read from the directory once, then counted as entries are added, so that the directory
+// is only listed again when the limit is exceeded
+func (c *Cache) Put(key string, b []byte, info Info) error {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.MaxBytes > 0 && !c.sized {
+		entries, err := c.List()
+		if err != nil {
+			return err
+		}
+		c.size, c.sized = 0, true
+		for _, e := range entries {
+			c.size += e.Size
+		}
+	}
+	var prev int64
+	if fi, err := os.Stat(c.path(key)); err == nil {
+		prev = fi.Size()
+	}
+
+	// write to a temporary file first so that a concurrent Get never reads a partial entry
+	tmp, err := ioutil.TempFile(c.Dir, key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), c.path(key)); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	// an Info always encodes
+	ib, _ := json.Marshal(info)
+	infoErr := ioutil.WriteFile(c.infoPath(key), ib, 0666)
+
+	c.size += int64(len(b)) - prev
+	if c.MaxBytes > 0 && c.size > c.MaxBytes {
+		_, _, err = c.prune(0, c.MaxBytes)
+	}
+	if err == nil {
+		err = infoErr
+	}
+
+	return err
+}
+
+func (c *Cache) expired(t, now time.Time) bool {
+	return c.TTL > 0 && now.Sub(t) > c.TTL
+}
+
+// List Returns all the entries, the oldest first
+func (c *Cache) List() ([]Entry, error) {
+
+	files, err := ioutil.ReadDir(c.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	for _, fi := range files {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), entryExt) {
+			continue
+		}
+		e := Entry{
+			Key:     strings.TrimSuffix(fi.Name(), entryExt),
+			Path:    filepath.Join(c.Dir, fi.Name()),
+			Size:    fi.Size(),
+			ModTime: fi.ModTime(),
+		}
+		if b, err := ioutil.ReadFile(c.infoPath(e.Key)); err == nil {
+			json.Unmarshal(b, &e.Info)
+		}
+		entries = append(entries, e)
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ModTime.Before(entries[j].ModTime) })
+
+	return entries, nil
+}
+
+// Prune Removes the entries older than `maxAge`, then the oldest entries until the cache uses at most `maxBytes`.
+// A zero value disables the corresponding limit. Returns the number of entries and bytes removed
+func (c *Cache) Prune(maxAge time.Duration, maxBytes int64) (int, int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.prune(maxAge, maxBytes)
+}
+
+func (c *Cache) prune(maxAge time.Duration, maxBytes int64) (int, int64, error) {
+
+	entries, err := c.List()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var total int64
+	for _, e := range entries {
+		total += e.Size
+	}
+	// entries may have been added or removed by other processes since the size was counted
+	defer func() { c.size, c.sized = total, true }()
+
+	now := time.Now()
+	var removed int
+	var freed int64
+	for _, e := range entries {
+		tooOld := maxAge > 0 && now.Sub(e.ModTime) > maxAge
+		tooBig := maxBytes > 0 && total > maxBytes
+		if !tooOld && !tooBig {
+			continue
+		}
+		if err := c.remove(e.Key); err != nil && !os.IsNotExist(err) {
+			return removed, freed, err
+		}
+		removed++
+		freed += e.Size
+		total -= e.Size
+	}
+
+	return removed, freed, nil
+}
+
+// Clear Removes all the entries with their info, and the temporary files of interrupted Puts. Returns the number of entries and bytes removed
+func (c *Cache) Clear() (int, int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	files, err := ioutil.ReadDir(c.Dir)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var removed int
+	var freed int64
+	for _, fi := range files {
+		entry := strings.HasSuffix(fi.Name(), entryExt)
+		if fi.IsDir() || !entry && !strings.HasSuffix(fi.Name(), infoExt) && !strings.HasSuffix(fi.Name(), ".tmp") {
+			continue
+		}
+		if err := os.Remove(filepath.Join(c.Dir, fi.Name())); err != nil && !os.IsNotExist(err) {
+			return removed, freed, err
+		}
+		if entry {
+			removed++
+			freed += fi.Size()
+		}
+	}
+	c.size, c.sized = 0, true
+
+	return removed, freed, nil
+}
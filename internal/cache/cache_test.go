@@ -0,0 +1,145 @@
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+
+	c, err := New(t.TempDir(), time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := Key("job_listings", `{"tickers":["nasdaq:aapl"]}`, "0", "100")
+	if key == Key("job_listings", `{"tickers":["nasdaq:aapl"]}`, "0", "1000") {
+		t.Fatal("Different limits should give different keys")
+	}
+
+	if _, ok := c.Get(key); ok {
+		t.Fatal("Expected a miss on an empty cache")
+	}
+
+	if err := c.Put(key, []byte("page"), Info{Dataset: "job_listings", Limit: 100}); err != nil {
+		t.Fatal(err)
+	}
+	if b, ok := c.Get(key); !ok || string(b) != "page" {
+		t.Fatalf("Expected a hit. Got: %q, %v", b, ok)
+	}
+	if entries, _ := c.List(); len(entries) != 1 || entries[0].Info != (Info{Dataset: "job_listings", Limit: 100}) {
+		t.Errorf("Expected the entry with its info, got: %+v", entries)
+	}
+
+	// expire the entry
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(c.path(key), old, old); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Get(key); ok {
+		t.Error("Expected a miss on an expired entry")
+	}
+	if _, err := os.Stat(c.path(key)); !os.IsNotExist(err) {
+		t.Error("Expected the expired entry to be removed")
+	}
+	if _, err := os.Stat(c.infoPath(key)); !os.IsNotExist(err) {
+		t.Error("Expected the info of the expired entry to be removed")
+	}
+}
+
+func TestCacheGetExpired(t *testing.T) {
+
+	c, err := New(t.TempDir(), time.Hour, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"a", "b"} {
+		if err := c.Put(k, []byte("12345"), Info{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(c.path("a"), old, old)
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("Expected a miss on an expired entry")
+	}
+	if c.size != 5 {
+		t.Errorf("Expected the size of the expired entry to be subtracted, got: %d", c.size)
+	}
+}
+
+func TestCacheMaxBytes(t *testing.T) {
+
+	c, err := New(t.TempDir(), 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, k := range []string{"a", "b", "c"} {
+		if err := c.Put(k, []byte("12345"), Info{}); err != nil {
+			t.Fatal(err)
+		}
+		// make sure the entries are ordered by age
+		mt := time.Now().Add(time.Duration(i-10) * time.Minute)
+		os.Chtimes(c.path(k), mt, mt)
+	}
+
+	entries, err := c.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Key != "b" || entries[1].Key != "c" {
+		t.Errorf("Expected the oldest entry to be removed. Got: %v", entries)
+	}
+
+	removed, freed, err := c.Prune(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 2 || freed != 10 {
+		t.Errorf("Expected all the entries to be removed. Got: %d entries, %d bytes", removed, freed)
+	}
+}
+
+func TestCacheClear(t *testing.T) {
+
+	c, err := New(t.TempDir(), 0, 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, k := range []string{"a", "b"} {
+		if err := c.Put(k, []byte("12345"), Info{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// left by an interrupted Put
+	if err := ioutil.WriteFile(filepath.Join(c.Dir, "c.123.tmp"), []byte("1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, freed, err := c.Clear()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 2 || freed != 10 {
+		t.Errorf("Expected all the entries to be removed. Got: %d entries, %d bytes", removed, freed)
+	}
+	if files, _ := ioutil.ReadDir(c.Dir); len(files) != 0 {
+		t.Errorf("Expected an empty directory, got %d files", len(files))
+	}
+
+	// the size counted by Put starts again from zero
+	for _, k := range []string{"d", "e"} {
+		if err := c.Put(k, []byte("12345"), Info{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if entries, _ := c.List(); len(entries) != 2 {
+		t.Errorf("Expected no entry to be pruned under the limit, got: %v", entries)
+	}
+}
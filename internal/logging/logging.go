@@ -0,0 +1,175 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level Severity of a log message
+type Level int
+
+// Log levels, from the most to the least verbose
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	default:
+		return "error"
+	}
+}
+
+// ParseLevel Returns the level with the given name: debug, info, warn or error
+func ParseLevel(s string) (Level, error) {
+	for l := LevelDebug; l <= LevelError; l++ {
+		if strings.EqualFold(s, l.String()) {
+			return l, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level: %s", s)
+}
+
+// Logger A structured, leveled logger
+// `kv` is a list of alternating keys and values added as fields to the message, e.g. "search", "jobs", "page", 2
+type Logger interface {
+	Debug(msg string, kv ...interface{})
+	Info(msg string, kv ...interface{})
+	Warn(msg string, kv ...interface{})
+	Error(msg string, kv ...interface{})
+	// With Returns a logger that adds the fields to every message
+	With(kv ...interface{}) Logger
+}
+
+// Nop Returns a logger that discards everything
+func Nop() Logger {
+	return nop{}
+}
+
+type nop struct{}
+
+func (nop) Debug(string, ...interface{}) {}
+func (nop) Info(string, ...interface{})  {}
+func (nop) Warn(string, ...interface{})  {}
+func (nop) Error(string, ...interface{}) {}
+func (n nop) With(...interface{}) Logger { return n }
+
+// New Returns a logger writing the messages of at least level `min` to `w`, one per line.
+// Messages are written as `time LEVEL message key=value ...` or as JSON objects if `asJSON` is set
+func New(w io.Writer, min Level, asJSON bool) Logger {
+	return &logger{
+		out: &output{w: w},
+		min: min,
+		js:  asJSON,
+	}
+}
+
+// output Serializes the writes of a logger and of all the loggers derived from it
+type output struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+type logger struct {
+	out    *output
+	min    Level
+	js     bool
+	fields []interface{}
+}
+
+func (l *logger) Debug(msg string, kv ...interface{}) { l.log(LevelDebug, msg, kv) }
+func (l *logger) Info(msg string, kv ...interface{})  { l.log(LevelInfo, msg, kv) }
+func (l *logger) Warn(msg string, kv ...interface{})  { l.log(LevelWarn, msg, kv) }
+func (l *logger) Error(msg string, kv ...interface{}) { l.log(LevelError, msg, kv) }
+
+func (l *logger) With(kv ...interface{}) Logger {
+	nl := *l
+	nl.fields = append(append([]interface{}{}, l.fields...), kv...)
+	return &nl
+}
+
+func (l *logger) log(level Level, msg string, kv []interface{}) {
+	if level < l.min {
+		return
+	}
+
+	fields := append(append([]interface{}{}, l.fields...), kv...)
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	var line string
+	if l.js {
+		line = jsonLine(now, level, msg, fields)
+	} else {
+		line = textLine(now, level, msg, fields)
+	}
+
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	io.WriteString(l.out.w, line+"\n")
+}
+
+func textLine(now string, level Level, msg string, fields []interface{}) string {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "%s %-5s %s", now, strings.ToUpper(level.String()), msg)
+	for i := 0; i < len(fields); i += 2 {
+		v := "(missing)"
+		if i+1 < len(fields) {
+			v = valueString(fields[i+1])
+		}
+		if strings.ContainsAny(v, " \t\n\"=") {
+			v = fmt.Sprintf("%q", v)
+		}
+		fmt.Fprintf(&sb, " %v=%s", fields[i], v)
+	}
+
+	return sb.String()
+}
+
+func jsonLine(now string, level Level, msg string, fields []interface{}) string {
+	m := map[string]interface{}{
+		"time":  now,
+		"level": level.String(),
+		"msg":   msg,
+	}
+	for i := 0; i < len(fields); i += 2 {
+		var v interface{} = "(missing)"
+		if i+1 < len(fields) {
+			v = fields[i+1]
+			if err, ok := v.(error); ok {
+				v = err.Error()
+			}
+		}
+		m[fmt.Sprint(fields[i])] = v
+	}
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Sprintf(`{"time":%q,"level":"error","msg":"cannot encode log message","error":%q}`, now, err.Error())
+	}
+	return string(b)
+}
+
+func valueString(v interface{}) string {
+	switch t := v.(type) {
+	case error:
+		return t.Error()
+	case time.Duration:
+		return t.String()
+	default:
+		return fmt.Sprint(t)
+	}
+}
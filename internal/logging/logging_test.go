@@ -0,0 +1,105 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLevel(t *testing.T) {
+
+	for _, s := range []string{"debug", "INFO", "Warn", "error"} {
+		l, err := ParseLevel(s)
+		if err != nil || !strings.EqualFold(l.String(), s) {
+			t.Errorf("%s: got %v, %v", s, l, err)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("Expected an error for an unknown level")
+	}
+}
+
+func TestLevelFiltering(t *testing.T) {
+
+	var buf bytes.Buffer
+	l := New(&buf, LevelWarn, false)
+
+	l.Debug("debug")
+	l.Info("info")
+	l.Warn("warn")
+	l.Error("error")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], "WARN  warn") || !strings.HasSuffix(lines[1], "ERROR error") {
+		t.Errorf("Expected only the warn and error messages, got: %q", lines)
+	}
+}
+
+func TestTextOutput(t *testing.T) {
+
+	var buf bytes.Buffer
+	New(&buf, LevelDebug, false).Info("Page fetched", "dataset", "job_listings", "where", `country = "US"`, "took", 1500*time.Millisecond, "error", errors.New("timeout"), "odd")
+
+	line := strings.TrimSpace(buf.String())
+	if _, err := time.Parse(time.RFC3339, strings.Fields(line)[0]); err != nil {
+		t.Errorf("Expected the line to start with the time: %s", line)
+	}
+	expected := `INFO  Page fetched dataset=job_listings where="country = \"US\"" took=1.5s error=timeout odd=(missing)`
+	if !strings.HasSuffix(line, expected) {
+		t.Errorf("Wrong text line.\nExpected: ...%s\ngot:      %s", expected, line)
+	}
+}
+
+func TestJSONOutput(t *testing.T) {
+
+	var buf bytes.Buffer
+	New(&buf, LevelDebug, true).Error("Run failed", "rows", 3, "error", errors.New("forbidden"))
+
+	var m map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatalf("Expected a JSON object, got %q: %v", buf.String(), err)
+	}
+	if m["level"] != "error" || m["msg"] != "Run failed" || m["rows"] != float64(3) || m["error"] != "forbidden" || m["time"] == nil {
+		t.Errorf("Wrong JSON message: %v", m)
+	}
+	if strings.Count(buf.String(), "\n") != 1 {
+		t.Errorf("Expected one message per line: %q", buf.String())
+	}
+}
+
+func TestWith(t *testing.T) {
+
+	var buf bytes.Buffer
+	l := New(&buf, LevelInfo, true)
+	jobs := l.With("search", "jobs")
+	stores := l.With("search", "stores")
+
+	jobs.Info("Page fetched", "page", 2)
+	stores.With("batch", 1).Info("Page fetched")
+	l.Info("Done")
+
+	var msgs []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var m map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, m)
+	}
+
+	if len(msgs) != 3 {
+		t.Fatalf("Expected 3 messages, got: %v", msgs)
+	}
+	if msgs[0]["search"] != "jobs" || msgs[0]["page"] != float64(2) {
+		t.Errorf("Wrong fields of the search: %v", msgs[0])
+	}
+	if msgs[1]["search"] != "stores" || msgs[1]["batch"] != float64(1) {
+		t.Errorf("Wrong fields of the other search: %v", msgs[1])
+	}
+	if _, ok := msgs[2]["search"]; ok {
+		t.Errorf("The fields of the derived loggers leaked into the parent: %v", msgs[2])
+	}
+}
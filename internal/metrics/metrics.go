@@ -0,0 +1,237 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Names of the metrics collected by the client
+const (
+	Requests        = "thinknum_api_requests_total"
+	RequestDuration = "thinknum_api_request_duration_seconds"
+	Retries         = "thinknum_api_retries_total"
+	Timeouts        = "thinknum_api_gateway_timeouts_total"
+	TokenRefreshes  = "thinknum_token_refreshes_total"
+	RowsFetched     = "thinknum_rows_fetched_total"
+	PagesFetched    = "thinknum_pages_fetched_total"
+	BytesWritten    = "thinknum_output_bytes_written_total"
+	BytesUploaded   = "thinknum_output_bytes_uploaded_total"
+	CacheHits       = "thinknum_cache_hits_total"
+	Workers         = "thinknum_workers"
+	WorkersBusy     = "thinknum_workers_busy"
+)
+
+const (
+	kindCounter   = "counter"
+	kindGauge     = "gauge"
+	kindHistogram = "histogram"
+)
+
+type description struct {
+	kind string
+	help string
+}
+
+var descriptions = map[string]description{
+	Requests:        {kindCounter, "Requests sent to the Thinknum API, by endpoint and response status."},
+	RequestDuration: {kindHistogram, "Duration of the requests sent to the Thinknum API, by endpoint."},
+	Retries:         {kindCounter, "Requests sent again after a transport error, by dataset."},
+	Timeouts:        {kindCounter, "Gateway timeouts received while the API prepares the results, by dataset."},
+	TokenRefreshes:  {kindCounter, "New authentication tokens requested."},
+	RowsFetched:     {kindCounter, "Rows fetched, by search."},
+	PagesFetched:    {kindCounter, "Pages fetched, by search."},
+	BytesWritten:    {kindCounter, "Bytes written to the output files, by search and output type. Only the appended rows count for incremental outputs."},
+	BytesUploaded:   {kindCounter, "Bytes of the output files uploaded to the object storage, by search and output type."},
+	CacheHits:       {kindCounter, "Pages served from the local cache instead of the API, by dataset."},
+	Workers:         {kindGauge, "Number of workers running searches."},
+	WorkersBusy:     {kindGauge, "Number of workers currently running a search."},
+}
+
+// buckets Upper bounds of the histogram buckets, in seconds
+var buckets = []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// Registry Collects metrics and exposes them in the Prometheus text format
+// All the methods can be called on a nil registry, in which case they do nothing
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]map[string]*series
+}
+
+type series struct {
+	labels string
+	value  float64
+	// histograms only
+	counts []uint64
+	count  uint64
+}
+
+// New Returns an empty registry
+func New() *Registry {
+	return &Registry{families: make(map[string]map[string]*series)}
+}
+
+// Add Adds `v` to a counter. `labels` is a list of alternating label names and values
+func (r *Registry) Add(name string, v float64, labels ...string) {
+	r.update(name, labels, func(s *series) { s.value += v })
+}
+
+// Set Sets the value of a gauge
+func (r *Registry) Set(name string, v float64, labels ...string) {
+	r.update(name, labels, func(s *series) { s.value = v })
+}
+
+// Observe Adds an observation to a histogram
+func (r *Registry) Observe(name string, v float64, labels ...string) {
+	r.update(name, labels, func(s *series) {
+		if s.counts == nil {
+			s.counts = make([]uint64, len(buckets))
+		}
+		for i, b := range buckets {
+			if v <= b {
+				s.counts[i]++
+			}
+		}
+		s.count++
+		s.value += v
+	})
+}
+
+func (r *Registry) update(name string, labels []string, f func(*series)) {
+	if r == nil {
+		return
+	}
+
+	key := formatLabels(labels)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	fam, ok := r.families[name]
+	if !ok {
+		fam = make(map[string]*series)
+		r.families[name] = fam
+	}
+	s, ok := fam[key]
+	if !ok {
+		s = &series{labels: key}
+		fam[key] = s
+	}
+	f(s)
+}
+
+// formatLabels Returns the labels in the Prometheus format, e.g. `{endpoint="query",status="200"}`
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	parts := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		parts = append(parts, fmt.Sprintf("%s=%s", labels[i], strconv.Quote(labels[i+1])))
+	}
+
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// WriteText Writes all the metrics in the Prometheus text exposition format
+func (r *Registry) WriteText(w io.Writer) error {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := make([]string, 0, len(r.families))
+	for n := range r.families {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, n := range names {
+		desc, ok := descriptions[n]
+		if !ok {
+			desc = description{kind: "untyped", help: n}
+		}
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s %s\n", n, desc.help, n, desc.kind)
+
+		keys := make([]string, 0, len(r.families[n]))
+		for k := range r.families[n] {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			s := r.families[n][k]
+			if desc.kind != kindHistogram {
+				fmt.Fprintf(&buf, "%s%s %s\n", n, s.labels, formatValue(s.value))
+				continue
+			}
+			for i, b := range buckets {
+				fmt.Fprintf(&buf, "%s_bucket%s %d\n", n, withLabel(s.labels, "le", formatValue(b)), s.counts[i])
+			}
+			fmt.Fprintf(&buf, "%s_bucket%s %d\n", n, withLabel(s.labels, "le", "+Inf"), s.count)
+			fmt.Fprintf(&buf, "%s_sum%s %s\n", n, s.labels, formatValue(s.value))
+			fmt.Fprintf(&buf, "%s_count%s %d\n", n, s.labels, s.count)
+		}
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func withLabel(labels, name, value string) string {
+	l := fmt.Sprintf("%s=%q", name, value)
+	if labels == "" {
+		return "{" + l + "}"
+	}
+	return labels[:len(labels)-1] + "," + l + "}"
+}
+
+func formatValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Handler Serves the metrics for Prometheus to scrape
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		r.WriteText(w)
+	})
+}
+
+// Push Sends all the metrics to a Pushgateway compatible endpoint, grouped under `job`
+// `gateway` is the base URL of the gateway, e.g. http://localhost:9091
+func (r *Registry) Push(gateway, job string) error {
+
+	var buf bytes.Buffer
+	if err := r.WriteText(&buf); err != nil {
+		return err
+	}
+
+	URL := strings.TrimSuffix(gateway, "/") + "/metrics/job/" + url.PathEscape(job)
+
+	resp, err := http.Post(URL, "text/plain; version=0.0.4", &buf)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("pushgateway responded with code %d", resp.StatusCode)
+	}
+
+	return nil
+}
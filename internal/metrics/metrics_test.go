@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+
+	r := New()
+	r.Add(Requests, 1, "endpoint", "query", "status", "200")
+	r.Add(Requests, 2, "endpoint", "query", "status", "200")
+	r.Add(Requests, 1, "endpoint", "query", "status", "504")
+	r.Set(Workers, 4)
+	r.Observe(RequestDuration, 0.7, "endpoint", "query")
+
+	var buf bytes.Buffer
+	if err := r.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	got := buf.String()
+
+	expected := []string{
+		"# TYPE thinknum_api_requests_total counter\n",
+		`thinknum_api_requests_total{endpoint="query",status="200"} 3` + "\n",
+		`thinknum_api_requests_total{endpoint="query",status="504"} 1` + "\n",
+		"# TYPE thinknum_workers gauge\nthinknum_workers 4\n",
+		`thinknum_api_request_duration_seconds_bucket{endpoint="query",le="0.5"} 0` + "\n",
+		`thinknum_api_request_duration_seconds_bucket{endpoint="query",le="1"} 1` + "\n",
+		`thinknum_api_request_duration_seconds_bucket{endpoint="query",le="+Inf"} 1` + "\n",
+		`thinknum_api_request_duration_seconds_count{endpoint="query"} 1` + "\n",
+	}
+
+	for _, e := range expected {
+		if !strings.Contains(got, e) {
+			t.Errorf("Missing %q in:\n%s", e, got)
+		}
+	}
+}
+
+func TestNilRegistry(t *testing.T) {
+	var r *Registry
+	r.Add(Requests, 1)
+	r.Observe(RequestDuration, 1)
+
+	var buf bytes.Buffer
+	if err := r.WriteText(&buf); err != nil || buf.Len() != 0 {
+		t.Errorf("Expected no output from a nil registry, got: %q, %v", buf.String(), err)
+	}
+}
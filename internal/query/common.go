@@ -1,12 +1,106 @@
 package query
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mehiX/thinknumV2/internal/cache"
+	"github.com/mehiX/thinknumV2/internal/logging"
+	"github.com/mehiX/thinknumV2/internal/metrics"
+)
+
+// API Connection parameters for the Thinknum API, shared by all the queries
+type API struct {
+	Hostname string
+	Version  string
+	Token    string
+	// Log Receives the progress of the queries. Nothing is logged if nil
+	Log logging.Logger
+	// Observe Receives an event for every page fetched and every retry. Optional
+	Observe func(Event)
+	// Metrics Collects request counts and durations. Optional
+	Metrics *metrics.Registry
+	// Cache Stores the pages of results, so that identical queries are not sent again. Optional
+	Cache *cache.Cache
+}
+
+// EventKind What happened while running a query
+type EventKind int
+
+// Events sent to API.Observe
+const (
+	// EventPage A page of results was fetched
+	EventPage EventKind = iota
+	// EventRetry A request failed and is sent again
+	EventRetry
+	// EventTimeout The API responded with a gateway timeout, the request is sent again until data is returned
+	EventTimeout
 )
 
+// Event Progress of a query
+type Event struct {
+	Kind    EventKind
+	Dataset string
+	// Offset of the requested page
+	Start int
+	// Number of pages and rows fetched so far and total number of rows of the search
+	Page  int
+	Rows  int
+	Total int
+	// Number of the retry, for EventRetry
+	Attempt int
+	Err     error
+}
+
+// WithLogger Returns a copy of the API that logs to `l`
+func (a *API) WithLogger(l logging.Logger) *API {
+	na := *a
+	na.Log = l
+	return &na
+}
+
+// WithObserver Returns a copy of the API that sends its events to `f`
+func (a *API) WithObserver(f func(Event)) *API {
+	na := *a
+	na.Observe = f
+	return &na
+}
+
+// do Sends the request and records its outcome in the metrics, labeled with `endpoint`
+func (a *API) do(req *http.Request, endpoint string) (*http.Response, error) {
+
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+
+	status := "error"
+	if err == nil {
+		status = strconv.Itoa(resp.StatusCode)
+	}
+	a.Metrics.Add(metrics.Requests, 1, "endpoint", endpoint, "status", status)
+	a.Metrics.Observe(metrics.RequestDuration, time.Since(start).Seconds(), "endpoint", endpoint)
+
+	return resp, err
+}
+
+func (a *API) observe(e Event) {
+	if a.Observe != nil {
+		a.Observe(e)
+	}
+}
+
+func (a *API) log() logging.Logger {
+	if a.Log == nil {
+		return logging.Nop()
+	}
+	return a.Log
+}
+
 // Request A request deinition as defined by the Thinknum API Docs
 type Request struct {
 	Filters     []Filter `json:"filters,omitempty"`
@@ -33,6 +127,25 @@ func (r Request) Clone() Request {
 	return newR
 }
 
+// canonical Returns the request as JSON, with the tickers and the filters sorted so that equivalent requests give the same string
+func (r Request) canonical() string {
+	c := r.Clone()
+	sort.Strings(c.Tickers)
+	sort.SliceStable(c.Filters, func(i, j int) bool {
+		fi, fj := c.Filters[i], c.Filters[j]
+		if fi.Column != fj.Column {
+			return fi.Column < fj.Column
+		}
+		if fi.Type != fj.Type {
+			return fi.Type < fj.Type
+		}
+		return strings.Join(fi.Value, "\x00") < strings.Join(fj.Value, "\x00")
+	})
+
+	b, _ := json.Marshal(c)
+	return string(b)
+}
+
 // Filter A single filter used to filter data from a dataset
 type Filter struct {
 	Column string   `json:"column"`
@@ -65,7 +178,8 @@ func addRequestHeaders(r *http.Request, token, version string) {
 }
 
 // fetchAll handles pagination. `processResp` is a function that contains the logic for sending the request, receiving the response and persisting the data (usually appending it to a slice)
-// Fetch each new page by calling `processResp` and advance to the next page based on the response metadata
+// Fetch each new page by calling `processResp` and advance to the next page based on the response metadata.
+// Stops at the first empty page, even if the total announces more records: the gap is left to the completeness check
 func fetchAll(processResp func(url.Values) (ResponseMetadata, error), params url.Values) error {
 
 	for {
@@ -75,7 +189,7 @@ func fetchAll(processResp func(url.Values) (ResponseMetadata, error), params url
 		}
 
 		resp, err := processResp(params)
-		if err != nil || resp.Total <= resp.Count+start {
+		if err != nil || resp.Count == 0 || resp.Total <= resp.Count+start {
 			return err
 		}
 		params.Set("start", strconv.Itoa(start+resp.Count))
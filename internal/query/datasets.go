@@ -4,11 +4,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
+
+	"github.com/mehiX/thinknumV2/internal/cache"
+	"github.com/mehiX/thinknumV2/internal/metrics"
 )
 
 // DatasetResponse The json response when querying for the list of datasets
@@ -25,97 +28,254 @@ type DatasetItem struct {
 	Summary     string
 }
 
-// RunSearch Run a query in the current dataset based on the passed in `Request` definiton
+// RunSearch Run a query in the dataset `datasetID` based on the passed in `Request` definiton
 // `pageSize` defines the limit on the records to be returned
-func (d DatasetItem) RunSearch(hostname, version, token string, pageSize int, srch Request) RunResult {
+func (a *API) RunSearch(datasetID string, pageSize int, srch Request) RunResult {
 
 	var items RowsItems
 
 	f := func(params url.Values) (ResponseMetadata, error) {
-		URL := fmt.Sprintf("https://%s/connections/dataset/%s/query/new", hostname, d.ID)
 
-		req, err := http.NewRequest(http.MethodPost, URL, strings.NewReader(params.Encode()))
+		start, _ := strconv.Atoi(params.Get("start"))
+
+		dsresp, err := a.queryPage(datasetID, params)
 		if err != nil {
 			return ResponseMetadata{}, err
 		}
 
-		addRequestHeadersPOST(req, token, version)
+		// these are the fields metadata so we only need to save them once
+		if len(items.Fields) == 0 {
+			items.Fields = append(items.Fields, dsresp.Items.Fields...)
+		}
+		items.Rows = append(items.Rows, dsresp.Items.Rows...)
+		items.Fetched = append(items.Fetched, Range{start, start + len(dsresp.Items.Rows)})
+		items.Pages++
+		// The Total should be the same with each request so no problem re-writing the value
+		if dsresp.Total > 0 {
+			items.Total = dsresp.Total
+		}
 
-		var resp *http.Response
-		var statusCode int
-		var errCount, maxErrCount = 0, 3
+		a.log().Debug("Page fetched",
+			"dataset", datasetID,
+			"page", items.Pages,
+			"rows", len(items.Rows),
+			"total", items.Total)
+		a.observe(Event{
+			Kind:    EventPage,
+			Dataset: datasetID,
+			Start:   start,
+			Page:    items.Pages,
+			Rows:    len(items.Rows),
+			Total:   items.Total,
+		})
 
-		for statusCode != http.StatusOK {
+		return dsresp.ResponseMetadata, nil
+	}
 
-			resp, err = http.DefaultClient.Do(req)
-			if err != nil {
-				// allow maxErrCount retries on error, after which abort
-				log.Printf("Error: %v\n", err)
-				if errCount >= maxErrCount {
-					return ResponseMetadata{}, err
-				}
+	frm, err := queryParams(srch, 0, pageSize)
+	if err != nil {
+		return RunResult{RowsItems{}, err}
+	}
+
+	err = fetchAll(f, frm)
+
+	return RunResult{items, err}
+}
 
-				errCount++
+// RunPage Run a query in the dataset `datasetID` and return only one page of results
+// `start` is the offset of the first record and `limit` the maximum number of records to return
+func (a *API) RunPage(datasetID string, start, limit int, srch Request) RunResult {
 
-				fmt.Printf("Retry (%d/%d)...\n", errCount, maxErrCount)
+	frm, err := queryParams(srch, start, limit)
+	if err != nil {
+		return RunResult{RowsItems{}, err}
+	}
 
-				continue
+	dsresp, err := a.queryPage(datasetID, frm)
+	if err != nil {
+		return RunResult{RowsItems{}, err}
+	}
+
+	items := dsresp.Items
+	items.Pages = 1
+	items.Total = dsresp.Total
+	items.Fetched = []Range{{start, start + len(items.Rows)}}
+
+	return RunResult{items, nil}
+}
+
+// FetchMissing Fetches again the ranges of records missing from `items`, for example after a short page.
+// The records found are inserted in `items` at their offset. Ranges that the API doesn't return anymore are left missing
+func (a *API) FetchMissing(datasetID string, pageSize int, srch Request, items *RowsItems) error {
+
+	defer items.sortByOffset()
+
+	for _, r := range items.Missing() {
+		for start := r.Start; start < r.End; {
+			limit := r.End - start
+			if limit > pageSize {
+				limit = pageSize
 			}
 
-			statusCode = resp.StatusCode
+			a.log().Info("Fetching missing records", "dataset", datasetID, "start", start, "limit", limit)
 
-			// in case of timeout we can try again
-			// https://docs.thinknum.com/docs/query-api#http-response-status-code
-			// When you get 504 error, you can keep retrying until data is returned. Every retries will connect to existing queued query and does not start new query.
-			if statusCode != http.StatusOK && statusCode != http.StatusGatewayTimeout {
-				b, _ := ioutil.ReadAll(resp.Body)
-				return ResponseMetadata{}, fmt.Errorf("code: %d, body: %s", resp.StatusCode, string(b))
+			page := a.RunPage(datasetID, start, limit, srch)
+			if page.Error != nil {
+				return page.Error
+			}
+			if len(page.Data.Rows) == 0 {
+				break
 			}
 
-			if statusCode == http.StatusGatewayTimeout {
-				fmt.Printf("%s => request timeout. Retrying...\n", d.DisplayName)
+			if len(items.Fields) == 0 {
+				items.Fields = page.Data.Fields
 			}
+			items.Rows = append(items.Rows, page.Data.Rows...)
+			items.Fetched = append(items.Fetched, page.Data.Fetched...)
+			items.Pages++
+
+			start += len(page.Data.Rows)
 		}
+	}
+
+	return nil
+}
+
+// queryParams Builds the form values for a query request
+func queryParams(srch Request, start, limit int) (url.Values, error) {
+	frm := url.Values{}
+	paramsStr, err := json.Marshal(srch)
+	if err != nil {
+		return nil, err
+	}
+	frm["request"] = []string{string(paramsStr)}
+	frm["limit"] = []string{strconv.Itoa(limit)}
+	frm["start"] = []string{strconv.Itoa(start)}
+
+	return frm, nil
+}
+
+// cacheKey Returns the key of a page in the cache: the same dataset, request, start and limit return the same page
+func (a *API) cacheKey(datasetID string, params url.Values) string {
+	if a.Cache == nil {
+		return ""
+	}
+
+	req := params.Get("request")
+	var r Request
+	if err := json.Unmarshal([]byte(req), &r); err == nil {
+		req = r.canonical()
+	}
 
-		defer resp.Body.Close()
+	return cache.Key(a.Hostname, a.Version, datasetID, req, params.Get("start"), params.Get("limit"))
+}
 
+// queryPage Sends one query request and decodes the response
+// Gateway timeouts are retried until data is returned, other transport errors are retried a few times
+func (a *API) queryPage(datasetID string, params url.Values) (datasetBasicQueryResponse, error) {
+
+	URL := fmt.Sprintf("https://%s/connections/dataset/%s/query/new", a.Hostname, datasetID)
+	start, _ := strconv.Atoi(params.Get("start"))
+
+	key := a.cacheKey(datasetID, params)
+	if b, ok := a.Cache.Get(key); ok {
 		var dsresp datasetBasicQueryResponse
-		if err = json.NewDecoder(resp.Body).Decode(&dsresp); err != nil {
-			return ResponseMetadata{}, err
+		if err := json.Unmarshal(b, &dsresp); err == nil {
+			a.log().Debug("Page served from cache", "dataset", datasetID, "start", start)
+			a.Metrics.Add(metrics.CacheHits, 1, "dataset", datasetID)
+			return dsresp, nil
 		}
+	}
 
-		// these are the fields metadata so we only need to save them once
-		if len(items.Fields) == 0 {
-			items.Fields = append(items.Fields, dsresp.Items.Fields...)
+	var resp *http.Response
+	var statusCode int
+	var errCount, maxErrCount = 0, 3
+
+	for statusCode != http.StatusOK {
+
+		req, err := http.NewRequest(http.MethodPost, URL, strings.NewReader(params.Encode()))
+		if err != nil {
+			return datasetBasicQueryResponse{}, err
 		}
-		items.Rows = append(items.Rows, dsresp.Items.Rows...)
-		items.Pages++
-		// The Total should be the same with each request so no problem re-writing the value
-		if dsresp.Total > 0 {
-			items.Total = dsresp.Total
+
+		addRequestHeadersPOST(req, a.Token, a.Version)
+
+		resp, err = a.do(req, EndpointQuery)
+		if err != nil {
+			// allow maxErrCount retries on error, after which abort
+			if errCount >= maxErrCount {
+				return datasetBasicQueryResponse{}, err
+			}
+
+			errCount++
+
+			a.log().Warn("Request failed, retrying",
+				"dataset", datasetID,
+				"start", params.Get("start"),
+				"attempt", errCount,
+				"max_attempts", maxErrCount,
+				"error", err)
+			a.Metrics.Add(metrics.Retries, 1, "dataset", datasetID)
+			a.observe(Event{
+				Kind:    EventRetry,
+				Dataset: datasetID,
+				Start:   start,
+				Attempt: errCount,
+				Err:     err,
+			})
+
+			continue
 		}
 
-		return dsresp.ResponseMetadata, nil
+		statusCode = resp.StatusCode
+
+		// in case of timeout we can try again
+		// https://docs.thinknum.com/docs/query-api#http-response-status-code
+		// When you get 504 error, you can keep retrying until data is returned. Every retries will connect to existing queued query and does not start new query.
+		if statusCode != http.StatusOK && statusCode != http.StatusGatewayTimeout {
+			return datasetBasicQueryResponse{}, NewAPIError(resp, EndpointQuery)
+		}
+
+		if statusCode == http.StatusGatewayTimeout {
+			resp.Body.Close()
+			a.log().Info("Request timeout, retrying",
+				"dataset", datasetID,
+				"start", params.Get("start"))
+			a.Metrics.Add(metrics.Timeouts, 1, "dataset", datasetID)
+			a.observe(Event{
+				Kind:    EventTimeout,
+				Dataset: datasetID,
+				Start:   start,
+			})
+		}
 	}
 
-	frm := url.Values{}
-	paramsStr, err := json.Marshal(srch)
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return RunResult{RowsItems{}, err}
+		return datasetBasicQueryResponse{}, err
 	}
-	frm["request"] = []string{string(paramsStr)}
-	frm["limit"] = []string{strconv.Itoa(pageSize)}
-	frm["start"] = []string{"0"}
 
-	err = fetchAll(f, frm)
+	var dsresp datasetBasicQueryResponse
+	if err := json.Unmarshal(b, &dsresp); err != nil {
+		return datasetBasicQueryResponse{}, &DecodeError{Endpoint: EndpointQuery, Err: err}
+	}
 
-	return RunResult{items, err}
+	if a.Cache != nil {
+		limit, _ := strconv.Atoi(params.Get("limit"))
+		if err := a.Cache.Put(key, b, cache.Info{Dataset: datasetID, Start: start, Limit: limit}); err != nil {
+			a.log().Warn("Cannot cache page", "dataset", datasetID, "start", start, "error", err)
+		}
+	}
+
+	return dsresp, nil
 }
 
 // Datasets Query the list of datasets
-func Datasets(hostname, version, token string, tickerFilter string) ([]DatasetItem, error) {
+func (a *API) Datasets(tickerFilter string) ([]DatasetItem, error) {
 
-	URL := fmt.Sprintf("https://%s/connections/datasets", hostname)
+	URL := fmt.Sprintf("https://%s/connections/datasets", a.Hostname)
 	req, err := http.NewRequest(http.MethodGet, URL, nil)
 	if err != nil {
 		return nil, err
@@ -127,16 +287,19 @@ func Datasets(hostname, version, token string, tickerFilter string) ([]DatasetIt
 		req.URL.RawQuery = v.Encode()
 	}
 
-	addRequestHeaders(req, token, version)
+	addRequestHeaders(req, a.Token, a.Version)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := a.do(req, EndpointDatasets)
 	if err != nil {
 		return nil, err
 	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, NewAPIError(resp, EndpointDatasets)
+	}
 	defer resp.Body.Close()
 
 	var dsResp DatasetResponse
-	if err := json.NewDecoder(resp.Body).Decode(&dsResp); err != nil {
+	if err := decode(resp, EndpointDatasets, &dsResp); err != nil {
 		return nil, err
 	}
 
@@ -179,4 +342,69 @@ type RowItemsMetadata struct {
 	// The number of requests made to retrieve all the results.
 	// The actual value depends on the pageSize ('limit') set through configuration
 	Pages int
+	// The offset ranges of the records fetched, one per page
+	Fetched []Range `json:"-"`
+}
+
+// Range A range of record offsets, from Start (included) to End (excluded)
+type Range struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// Missing Returns the ranges of records between 0 and Total that were not fetched
+func (m RowItemsMetadata) Missing() []Range {
+
+	fetched := make([]Range, len(m.Fetched))
+	copy(fetched, m.Fetched)
+	sort.Slice(fetched, func(i, j int) bool { return fetched[i].Start < fetched[j].Start })
+
+	var missing []Range
+	next := 0
+	for _, r := range fetched {
+		if r.Start > next {
+			missing = append(missing, Range{next, r.Start})
+		}
+		if r.End > next {
+			next = r.End
+		}
+	}
+	if next < m.Total {
+		missing = append(missing, Range{next, m.Total})
+	}
+
+	return missing
+}
+
+// sortByOffset Puts the rows in the order of their offsets, e.g. after the missing ranges were fetched at the end.
+// The rows are left as they are if they don't match the fetched ranges
+func (d *RowsItems) sortByOffset() {
+
+	n := 0
+	for _, r := range d.Fetched {
+		n += r.End - r.Start
+	}
+	if n != len(d.Rows) || sort.SliceIsSorted(d.Fetched, func(i, j int) bool { return d.Fetched[i].Start < d.Fetched[j].Start }) {
+		return
+	}
+
+	type chunk struct {
+		r    Range
+		rows []Row
+	}
+	chunks := make([]chunk, len(d.Fetched))
+	i := 0
+	for k, r := range d.Fetched {
+		chunks[k] = chunk{r, d.Rows[i : i+r.End-r.Start]}
+		i += r.End - r.Start
+	}
+	sort.SliceStable(chunks, func(i, j int) bool { return chunks[i].r.Start < chunks[j].r.Start })
+
+	rows := make([]Row, 0, len(d.Rows))
+	fetched := make([]Range, 0, len(d.Fetched))
+	for _, c := range chunks {
+		rows = append(rows, c.rows...)
+		fetched = append(fetched, c.r)
+	}
+	d.Rows, d.Fetched = rows, fetched
 }
@@ -0,0 +1,103 @@
+package query
+
+import (
+	"encoding/json"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/mehiX/thinknumV2/internal/cache"
+)
+
+func TestMissing(t *testing.T) {
+
+	var scenarios = []struct {
+		name     string
+		meta     RowItemsMetadata
+		expected []Range
+	}{
+		{"complete", RowItemsMetadata{Total: 20, Fetched: []Range{{0, 10}, {10, 20}}}, nil},
+		{"no results", RowItemsMetadata{Total: 0}, nil},
+		{"short last page", RowItemsMetadata{Total: 25, Fetched: []Range{{0, 10}, {10, 20}}}, []Range{{20, 25}}},
+		{"gap", RowItemsMetadata{Total: 30, Fetched: []Range{{20, 30}, {0, 8}}}, []Range{{8, 20}}},
+		{"overlap", RowItemsMetadata{Total: 30, Fetched: []Range{{0, 15}, {10, 30}}}, nil},
+		{"nothing fetched", RowItemsMetadata{Total: 5}, []Range{{0, 5}}},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			got := s.meta.Missing()
+			if !reflect.DeepEqual(got, s.expected) {
+				t.Errorf("Wrong missing ranges. Expected: %v, got: %v", s.expected, got)
+			}
+		})
+	}
+}
+
+func TestFetchAllEmptyPage(t *testing.T) {
+
+	calls := 0
+	f := func(params url.Values) (ResponseMetadata, error) {
+		calls++
+		if calls > 3 {
+			t.Fatal("Expected fetchAll to stop at the empty page")
+		}
+		if params.Get("start") == "0" {
+			return ResponseMetadata{Count: 5, Total: 10}, nil
+		}
+		return ResponseMetadata{Count: 0, Total: 10}, nil
+	}
+
+	frm, _ := queryParams(Request{}, 0, 5)
+	if err := fetchAll(f, frm); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Errorf("Expected 2 pages to be requested, got: %d", calls)
+	}
+}
+
+func TestFetchMissing(t *testing.T) {
+
+	c, err := cache.New(t.TempDir(), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &API{Hostname: "api.example.com", Version: "20151130", Cache: c}
+	srch := Request{Tickers: []string{"nasdaq:aapl"}}
+
+	// the pages served by the API, through the cache
+	page := func(start, limit int, rows ...Row) {
+		frm, _ := queryParams(srch, start, limit)
+		var resp datasetBasicQueryResponse
+		resp.Count = len(rows)
+		resp.Total = 6
+		resp.Items.Rows = rows
+		b, _ := json.Marshal(resp)
+		if err := c.Put(a.cacheKey("job_listings", frm), b, cache.Info{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	page(2, 2, Row{"c"}, Row{"d"})
+
+	// the page of offsets 2 to 4 came back empty
+	items := RowsItems{
+		RowItemsMetadata: RowItemsMetadata{Total: 6, Pages: 2, Fetched: []Range{{0, 2}, {4, 6}}},
+		Rows:             []Row{{"a"}, {"b"}, {"e"}, {"f"}},
+	}
+
+	if err := a.FetchMissing("job_listings", 2, srch, &items); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Row{{"a"}, {"b"}, {"c"}, {"d"}, {"e"}, {"f"}}
+	if !reflect.DeepEqual(items.Rows, expected) {
+		t.Errorf("Wrong rows. Expected: %v, got: %v", expected, items.Rows)
+	}
+	if m := items.Missing(); len(m) != 0 {
+		t.Errorf("Expected no missing ranges, got: %v", m)
+	}
+	if items.Pages != 3 {
+		t.Errorf("Expected 3 pages, got: %d", items.Pages)
+	}
+}
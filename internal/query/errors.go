@@ -0,0 +1,127 @@
+package query
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// Errors matched by the *APIError returned for a response status, use them with `errors.Is`
+var (
+	// ErrUnauthorized The token or the client credentials were rejected (401, 403)
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrRateLimited Too many requests were sent (429)
+	ErrRateLimited = errors.New("rate limited")
+	// ErrDatasetNotFound The dataset of a query or of a tickers request doesn't exist (404)
+	ErrDatasetNotFound = errors.New("dataset not found")
+	// ErrBadRequest The request was rejected, usually because of an invalid filter (400, 422)
+	ErrBadRequest = errors.New("bad request")
+	// ErrServer The API failed to process the request (5xx)
+	ErrServer = errors.New("server error")
+)
+
+// Endpoints reported in the errors and in the metrics
+const (
+	EndpointAuth     = "auth"
+	EndpointDatasets = "datasets"
+	EndpointTickers  = "tickers"
+	EndpointQuery    = "query"
+)
+
+// APIError The API responded with an unexpected status
+type APIError struct {
+	Endpoint   string
+	StatusCode int
+	// RequestID The request ID sent back by the API, if any. Useful when reporting a problem to Thinknum
+	RequestID string
+	// Message The error message parsed from the response, or the raw body if it isn't JSON
+	Message string
+}
+
+func (e *APIError) Error() string {
+	s := fmt.Sprintf("%s api responded with code %d", e.Endpoint, e.StatusCode)
+	if e.Message != "" {
+		s += ": " + e.Message
+	}
+	if e.RequestID != "" {
+		s += " (request id " + e.RequestID + ")"
+	}
+	return s
+}
+
+// Unwrap Returns the sentinel error matching the status code, if any
+func (e *APIError) Unwrap() error {
+	switch {
+	case e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden:
+		return ErrUnauthorized
+	case e.StatusCode == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case e.StatusCode == http.StatusNotFound && (e.Endpoint == EndpointQuery || e.Endpoint == EndpointTickers):
+		return ErrDatasetNotFound
+	case e.StatusCode == http.StatusBadRequest || e.StatusCode == http.StatusUnprocessableEntity:
+		return ErrBadRequest
+	case e.StatusCode >= 500:
+		return ErrServer
+	}
+	return nil
+}
+
+// DecodeError The response of the API could not be decoded
+type DecodeError struct {
+	Endpoint string
+	Err      error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("cannot decode the %s api response: %v", e.Endpoint, e.Err)
+}
+
+// Unwrap Returns the JSON error
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// NewAPIError Builds the error for a response with an unexpected status. The body is read and closed
+func NewAPIError(resp *http.Response, endpoint string) *APIError {
+	defer resp.Body.Close()
+
+	e := &APIError{
+		Endpoint:   endpoint,
+		StatusCode: resp.StatusCode,
+		RequestID:  resp.Header.Get("X-Request-Id"),
+	}
+
+	b, _ := ioutil.ReadAll(resp.Body)
+	e.Message = errorMessage(b)
+
+	return e
+}
+
+// errorMessage Extracts the message from an error response body, e.g. `{"message": "Invalid filter"}`
+func errorMessage(b []byte) string {
+	var body struct {
+		Message string `json:"message"`
+		Error   string `json:"error"`
+		Detail  string `json:"detail"`
+	}
+	if err := json.Unmarshal(b, &body); err == nil {
+		for _, m := range []string{body.Message, body.Error, body.Detail} {
+			if m != "" {
+				return m
+			}
+		}
+	}
+
+	return strings.TrimSpace(string(b))
+}
+
+// decode Decodes the JSON body of a response into `v`
+func decode(resp *http.Response, endpoint string, v interface{}) error {
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return &DecodeError{Endpoint: endpoint, Err: err}
+	}
+	return nil
+}
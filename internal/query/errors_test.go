@@ -0,0 +1,63 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAPIError(t *testing.T) {
+
+	var scenarios = []struct {
+		name     string
+		endpoint string
+		status   int
+		body     string
+		sentinel error
+		message  string
+	}{
+		{"unauthorized", EndpointQuery, 401, `{"message": "Invalid token"}`, ErrUnauthorized, "Invalid token"},
+		{"forbidden", EndpointAuth, 403, `{"error": "Invalid client"}`, ErrUnauthorized, "Invalid client"},
+		{"rate limited", EndpointQuery, 429, "slow down\n", ErrRateLimited, "slow down"},
+		{"dataset not found", EndpointTickers, 404, `{"detail": "Not found"}`, ErrDatasetNotFound, "Not found"},
+		{"bad filter", EndpointQuery, 400, `{"message": "Unknown column"}`, ErrBadRequest, "Unknown column"},
+		{"server error", EndpointDatasets, 502, "", ErrServer, ""},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			resp := &http.Response{
+				StatusCode: s.status,
+				Header:     http.Header{"X-Request-Id": []string{"abc"}},
+				Body:       ioutil.NopCloser(strings.NewReader(s.body)),
+			}
+
+			err := fmt.Errorf("search jobs: %w", NewAPIError(resp, s.endpoint))
+
+			if !errors.Is(err, s.sentinel) {
+				t.Errorf("Expected the error to match %v: %v", s.sentinel, err)
+			}
+
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("Expected an *APIError: %v", err)
+			}
+			if apiErr.StatusCode != s.status || apiErr.Endpoint != s.endpoint || apiErr.RequestID != "abc" {
+				t.Errorf("Wrong error fields: %+v", apiErr)
+			}
+			if apiErr.Message != s.message {
+				t.Errorf("Wrong message. Expected: %q, got: %q", s.message, apiErr.Message)
+			}
+		})
+	}
+}
+
+func TestAPIErrorNotFoundDatasets(t *testing.T) {
+	err := &APIError{Endpoint: EndpointDatasets, StatusCode: 404}
+	if errors.Is(err, ErrDatasetNotFound) {
+		t.Error("A 404 on the datasets endpoint is not a missing dataset")
+	}
+}
@@ -1,9 +1,7 @@
 package query
 
 import (
-	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 )
 
@@ -23,34 +21,39 @@ type TickerItem struct {
 }
 
 // TickerList Returns the list of tickers for the provided `datasetID`
-func TickerList(hostname, version, token, datasetID string) ([]TickerItem, error) {
+func (a *API) TickerList(datasetID string) ([]TickerItem, error) {
 
 	if datasetID == "" {
 		return nil, fmt.Errorf("dataset not specified when requesting the list of tickers")
 	}
 
-	URL := fmt.Sprintf("https://%s/connections/dataset/%s/tickers", hostname, datasetID)
+	URL := fmt.Sprintf("https://%s/connections/dataset/%s/tickers", a.Hostname, datasetID)
 
 	req, err := http.NewRequest(http.MethodGet, URL, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	addRequestHeaders(req, token, version)
+	addRequestHeaders(req, a.Token, a.Version)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := a.do(req, EndpointTickers)
 	if err != nil {
 		return nil, err
 	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, NewAPIError(resp, EndpointTickers)
+	}
 	defer resp.Body.Close()
 
 	var tickerResp TickerResponse
-	if err := json.NewDecoder(resp.Body).Decode(&tickerResp); err != nil {
+	if err := decode(resp, EndpointTickers, &tickerResp); err != nil {
 		return nil, err
 	}
 
-	log.Println("Count", tickerResp.Count)
-	log.Println("Total", tickerResp.Total)
+	a.log().Debug("Tickers fetched",
+		"dataset", datasetID,
+		"count", tickerResp.Count,
+		"total", tickerResp.Total)
 
 	return tickerResp.Items, nil
 }
@@ -0,0 +1,307 @@
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// WhereError A syntax error found while parsing a `where` expression
+// Pos is the byte offset of the offending token in Input
+type WhereError struct {
+	Input string
+	Pos   int
+	Token string
+	Msg   string
+}
+
+func (e *WhereError) Error() string {
+	if e.Token == "" {
+		return fmt.Sprintf("where: column %d: %s", e.Pos+1, e.Msg)
+	}
+	return fmt.Sprintf("where: column %d: %s (near %q)", e.Pos+1, e.Msg, e.Token)
+}
+
+// Caret Returns the input expression followed by a line pointing at the offending token
+func (e *WhereError) Caret() string {
+	return e.Input + "\n" + strings.Repeat(" ", e.Pos) + "^"
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokWord
+	tokString
+	tokOp
+	tokLBrack
+	tokRBrack
+	tokComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// opChars Characters that can make up a filter type, e.g. `>=`, `!=` or `(...)`. The range types starting with `[` are lexed apart
+const opChars = "=!<>().~^$*"
+
+// FilterTypes The filter types supported by the Thinknum API
+var FilterTypes = []string{
+	"=", "!=", ">", ">=", "<", "<=",
+	// ranges, with the bounds included (`[`, `]`) or excluded (`(`, `)`)
+	"[]", "[)", "(]", "()",
+	// text contains, contains any of the values, starts with, ends with
+	"...", "(...)", "^...", "...$",
+}
+
+func isFilterType(s string) bool {
+	for _, t := range FilterTypes {
+		if s == t {
+			return true
+		}
+	}
+	return false
+}
+
+func isWordStart(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-' || r == '+'
+}
+
+func isWordChar(r rune) bool {
+	return isWordStart(r) || r == '.' || r == ':' || r == '/'
+}
+
+func lex(input string) ([]token, error) {
+	var tokens []token
+
+	for i := 0; i < len(input); {
+		r, size := utf8.DecodeRuneInString(input[i:])
+
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+		case r == '[' && i+1 < len(input) && (input[i+1] == ']' || input[i+1] == ')'):
+			// the range types `[]` and `[)`, an empty list is an error anyway
+			tokens = append(tokens, token{tokOp, input[i : i+2], i})
+			i += 2
+		case r == '[':
+			tokens = append(tokens, token{tokLBrack, "[", i})
+			i++
+		case r == ']':
+			tokens = append(tokens, token{tokRBrack, "]", i})
+			i++
+		case r == ',':
+			tokens = append(tokens, token{tokComma, ",", i})
+			i++
+		case r == '"' || r == '\'':
+			end := i + 1
+			for end < len(input) && rune(input[end]) != r {
+				if input[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(input) {
+				return nil, &WhereError{Input: input, Pos: i, Token: input[i:], Msg: "unterminated string"}
+			}
+			raw := input[i : end+1]
+			if r == '\'' {
+				// single quoted strings are unquoted as if they were double quoted
+				raw = singleToDoubleQuoted(input[i+1 : end])
+			}
+			s, err := strconv.Unquote(raw)
+			if err != nil {
+				return nil, &WhereError{Input: input, Pos: i, Token: input[i : end+1], Msg: "invalid string literal"}
+			}
+			tokens = append(tokens, token{tokString, s, i})
+			i = end + 1
+		case strings.ContainsRune(opChars, r):
+			end := i
+			for end < len(input) && strings.ContainsRune(opChars, rune(input[end])) {
+				end++
+			}
+			// the range type `(]`
+			if input[end-1] == '(' && end < len(input) && input[end] == ']' {
+				end++
+			}
+			tokens = append(tokens, token{tokOp, input[i:end], i})
+			i = end
+		case isWordStart(r):
+			end := i
+			for end < len(input) {
+				c, n := utf8.DecodeRuneInString(input[end:])
+				if !isWordChar(c) {
+					break
+				}
+				end += n
+			}
+			tokens = append(tokens, token{tokWord, input[i:end], i})
+			i = end
+		default:
+			return nil, &WhereError{Input: input, Pos: i, Token: string(r), Msg: "unexpected character"}
+		}
+	}
+
+	return append(tokens, token{tokEOF, "", len(input)}), nil
+}
+
+// singleToDoubleQuoted Returns the content of a single quoted string as a double quoted Go string: `\'` is a quote, `"` needs no escape
+func singleToDoubleQuoted(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] == '\'':
+			sb.WriteByte('\'')
+			i++
+		case s[i] == '\\' && i+1 < len(s):
+			sb.WriteString(s[i : i+2])
+			i++
+		case s[i] == '"':
+			sb.WriteString(`\"`)
+		default:
+			sb.WriteByte(s[i])
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// ParseWhere Parses a filter expression into a list of filters.
+// The expression is a list of conditions joined by `and`. Each condition has the form `column type value`,
+// where `type` is one of the FilterTypes supported by the Thinknum API (`=`, `>=`, `(...)`, `[]` etc.)
+// and `value` is a bare word, a quoted string or a list of those between square brackets:
+//
+//	country = "US" and description (...) ["Golang","Go"] and as_of_date >= 2020-01-01
+//
+// An empty expression returns no filters.
+func ParseWhere(input string) ([]Filter, error) {
+
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+
+	p := whereParser{input: input, tokens: tokens}
+
+	var filters []Filter
+
+	if p.peek().kind == tokEOF {
+		return filters, nil
+	}
+
+	for {
+		f, err := p.condition()
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+
+		t := p.next()
+		switch {
+		case t.kind == tokEOF:
+			return filters, nil
+		case t.kind == tokWord && strings.EqualFold(t.text, "and"):
+			continue
+		case t.kind == tokWord && strings.EqualFold(t.text, "or"):
+			return nil, p.errorAt(t, "only \"and\" is supported between conditions")
+		default:
+			return nil, p.errorAt(t, "expected \"and\" or end of expression")
+		}
+	}
+}
+
+type whereParser struct {
+	input  string
+	tokens []token
+	pos    int
+}
+
+func (p *whereParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *whereParser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *whereParser) errorAt(t token, msg string) error {
+	return &WhereError{Input: p.input, Pos: t.pos, Token: t.text, Msg: msg}
+}
+
+func (p *whereParser) condition() (Filter, error) {
+
+	col := p.next()
+	if col.kind != tokWord && col.kind != tokString {
+		return Filter{}, p.errorAt(col, "expected column name")
+	}
+
+	op := p.next()
+	if op.kind != tokOp {
+		return Filter{}, p.errorAt(op, "expected filter type after column "+strconv.Quote(col.text))
+	}
+	if !isFilterType(op.text) {
+		return Filter{}, p.errorAt(op, "unknown filter type, expected one of "+strings.Join(FilterTypes, " "))
+	}
+
+	values, err := p.values()
+	if err != nil {
+		return Filter{}, err
+	}
+
+	return Filter{
+		Column: col.text,
+		Type:   op.text,
+		Value:  values,
+	}, nil
+}
+
+func (p *whereParser) values() ([]string, error) {
+
+	t := p.next()
+
+	switch t.kind {
+	case tokWord, tokString:
+		return []string{t.text}, nil
+	case tokLBrack:
+	case tokOp:
+		if t.text == "[]" {
+			// lexed as the range type
+			return nil, &WhereError{Input: p.input, Pos: t.pos + 1, Token: "]", Msg: "empty value list"}
+		}
+		return nil, p.errorAt(t, "expected value")
+	default:
+		return nil, p.errorAt(t, "expected value")
+	}
+
+	var values []string
+	for {
+		v := p.next()
+		if v.kind == tokRBrack && len(values) == 0 {
+			return nil, p.errorAt(v, "empty value list")
+		}
+		if v.kind != tokWord && v.kind != tokString {
+			return nil, p.errorAt(v, "expected value in list")
+		}
+		values = append(values, v.text)
+
+		sep := p.next()
+		switch sep.kind {
+		case tokComma:
+			continue
+		case tokRBrack:
+			return values, nil
+		default:
+			return nil, p.errorAt(sep, "expected \",\" or \"]\"")
+		}
+	}
+}
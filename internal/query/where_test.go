@@ -0,0 +1,89 @@
+package query
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseWhere(t *testing.T) {
+
+	var scenarios = []struct {
+		input    string
+		expected []Filter
+	}{
+		{"", nil},
+		{"   ", nil},
+		{`country = "US"`, []Filter{{"country", "=", []string{"US"}}}},
+		{
+			`country = "US" and description (...) ["Golang","Go"] and as_of_date >= 2020-01-01`,
+			[]Filter{
+				{"country", "=", []string{"US"}},
+				{"description", "(...)", []string{"Golang", "Go"}},
+				{"as_of_date", ">=", []string{"2020-01-01"}},
+			},
+		},
+		{"price<-5.5 AND ticker=nasdaq:aapl", []Filter{
+			{"price", "<", []string{"-5.5"}},
+			{"ticker", "=", []string{"nasdaq:aapl"}},
+		}},
+		{`title != 'say "hi"'`, []Filter{{"title", "!=", []string{`say "hi"`}}}},
+		{`title = 'it\'s' and company = 'a\\b'`, []Filter{
+			{"title", "=", []string{"it's"}},
+			{"company", "=", []string{`a\b`}},
+		}},
+		{"price [] [1, 5] and price [) [1,5] and price (] [1,5] and price () [1,5]", []Filter{
+			{"price", "[]", []string{"1", "5"}},
+			{"price", "[)", []string{"1", "5"}},
+			{"price", "(]", []string{"1", "5"}},
+			{"price", "()", []string{"1", "5"}},
+		}},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.input, func(t *testing.T) {
+			got, err := ParseWhere(s.input)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, s.expected) {
+				t.Errorf("Wrong filters. Expected: %v, got: %v", s.expected, got)
+			}
+		})
+	}
+}
+
+func TestParseWhereErrors(t *testing.T) {
+
+	var scenarios = []struct {
+		input string
+		pos   int
+	}{
+		{`country "US"`, 8},
+		{`country = `, 10},
+		{`country = "US`, 10},
+		{`country = US or state = NY`, 13},
+		{`country = US state = NY`, 13},
+		{`description (...) ["Go" "Golang"]`, 24},
+		{`description (...) []`, 19},
+		{`country = US and`, 16},
+		{`country = US; state = NY`, 12},
+		{`a === 1`, 2},
+		{`a <>< 1`, 2},
+		{`a [ 1`, 2},
+		{`title = 'it\'s`, 8},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.input, func(t *testing.T) {
+			_, err := ParseWhere(s.input)
+			var we *WhereError
+			if !errors.As(err, &we) {
+				t.Fatalf("Expected a *WhereError, got: %v", err)
+			}
+			if we.Pos != s.pos {
+				t.Errorf("Wrong error position. Expected: %d, got: %d (%v)", s.pos, we.Pos, err)
+			}
+		})
+	}
+}
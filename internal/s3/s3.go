@@ -0,0 +1,354 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultPartSize Files larger than this are uploaded in parts of this size
+	DefaultPartSize = 64 << 20
+	// MinPartSize The smallest part accepted by S3, except for the last one
+	MinPartSize = 5 << 20
+
+	defaultRegion  = "us-east-1"
+	defaultRetries = 3
+)
+
+// Client Uploads files to an S3 compatible object storage, e.g. AWS S3 or MinIO
+type Client struct {
+	// Endpoint URL of the storage, e.g. `http://localhost:9000`. Defaults to AWS S3 in the region
+	Endpoint string
+	// Region Defaults to us-east-1
+	Region       string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	// PathStyle Address the buckets as `<endpoint>/<bucket>/<key>` instead of `<bucket>.<endpoint>/<key>`, as MinIO expects
+	PathStyle bool
+	// PartSize Files larger than this are uploaded in parts. Defaults to DefaultPartSize
+	PartSize int64
+	// Retries Number of times a request failing with a network or a server error is sent again. Defaults to 3, none if negative
+	Retries int
+	HTTP    *http.Client
+}
+
+// Object An uploaded file
+type Object struct {
+	Bucket string
+	Key    string
+	ETag   string
+	Size   int64
+	// Parts Number of parts of a multipart upload, 0 for a single request
+	Parts int
+}
+
+// URI Returns the address of the object, e.g. `s3://bucket/out/jobs.csv`
+func (o Object) URI() string {
+	return "s3://" + o.Bucket + "/" + o.Key
+}
+
+// Error An error returned by the storage
+type Error struct {
+	Op         string
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+	RequestID  string `xml:"RequestId"`
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("s3 %s: %d", e.Op, e.StatusCode)
+	if e.Code != "" {
+		msg += " " + e.Code
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.RequestID != "" {
+		msg += " (request id " + e.RequestID + ")"
+	}
+	return msg
+}
+
+func (c *Client) region() string {
+	if c.Region == "" {
+		return defaultRegion
+	}
+	return c.Region
+}
+
+func (c *Client) partSize() int64 {
+	if c.PartSize <= 0 {
+		return DefaultPartSize
+	}
+	return c.PartSize
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTP == nil {
+		return http.DefaultClient
+	}
+	return c.HTTP
+}
+
+// objectURL Returns the URL of an object, with the query parameters of the operation
+func (c *Client) objectURL(bucket, key string, q url.Values) (*url.URL, error) {
+
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + c.region() + ".amazonaws.com"
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	p := "/" + key
+	if c.PathStyle {
+		p = "/" + bucket + p
+	} else {
+		u.Host = bucket + "." + u.Host
+	}
+	u.Path = path.Join(u.Path, p)
+	u.RawPath = encode(u.Path, false)
+	u.RawQuery = q.Encode()
+
+	return u, nil
+}
+
+// Upload Uploads the file `fn` to `bucket` under `key`, in parts if it is larger than PartSize.
+// Every request carries the MD5 and the signed SHA-256 of its body, which the storage checks. The SHA-256 of the whole file is
+// stored in the `x-amz-meta-sha256` metadata of the object
+func (c *Client) Upload(bucket, key, fn string) (Object, error) {
+
+	obj := Object{Bucket: bucket, Key: key}
+
+	f, err := os.Open(fn)
+	if err != nil {
+		return obj, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return obj, err
+	}
+	obj.Size = info.Size()
+
+	_, sum, err := digest(io.NewSectionReader(f, 0, obj.Size))
+	if err != nil {
+		return obj, err
+	}
+
+	meta := http.Header{}
+	meta.Set("X-Amz-Meta-Sha256", sum)
+	contentType := mime.TypeByExtension(path.Ext(fn))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	meta.Set("Content-Type", contentType)
+
+	if obj.Size <= c.partSize() {
+		u, err := c.objectURL(bucket, key, nil)
+		if err != nil {
+			return obj, err
+		}
+		resp, err := c.send("PutObject", http.MethodPut, u, f, 0, obj.Size, meta)
+		if err != nil {
+			return obj, err
+		}
+		obj.ETag = resp.Header.Get("ETag")
+		return obj, nil
+	}
+
+	return c.multipart(obj, f, meta)
+}
+
+type initiateResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+type completedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+type completeUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+type completeResult struct {
+	XMLName xml.Name
+	ETag    string `xml:"ETag"`
+	Error
+}
+
+// multipart Uploads the file in parts. The upload is aborted if a part fails, so that the storage doesn't keep the parts
+func (c *Client) multipart(obj Object, f *os.File, meta http.Header) (Object, error) {
+
+	u, err := c.objectURL(obj.Bucket, obj.Key, url.Values{"uploads": {""}})
+	if err != nil {
+		return obj, err
+	}
+	resp, err := c.send("CreateMultipartUpload", http.MethodPost, u, nil, 0, 0, meta)
+	if err != nil {
+		return obj, err
+	}
+	var init initiateResult
+	if err := xml.Unmarshal(resp.body, &init); err != nil || init.UploadID == "" {
+		return obj, fmt.Errorf("s3 CreateMultipartUpload: no upload id in the response: %v", err)
+	}
+
+	abort := func(err error) (Object, error) {
+		if u, uerr := c.objectURL(obj.Bucket, obj.Key, url.Values{"uploadId": {init.UploadID}}); uerr == nil {
+			c.send("AbortMultipartUpload", http.MethodDelete, u, nil, 0, 0, nil)
+		}
+		return obj, err
+	}
+
+	var done completeUpload
+	size := c.partSize()
+	for off, n := int64(0), 1; off < obj.Size; off, n = off+size, n+1 {
+		length := size
+		if off+length > obj.Size {
+			length = obj.Size - off
+		}
+
+		u, err := c.objectURL(obj.Bucket, obj.Key, url.Values{"partNumber": {strconv.Itoa(n)}, "uploadId": {init.UploadID}})
+		if err != nil {
+			return abort(err)
+		}
+		resp, err := c.send("UploadPart", http.MethodPut, u, f, off, length, nil)
+		if err != nil {
+			return abort(err)
+		}
+		done.Parts = append(done.Parts, completedPart{PartNumber: n, ETag: resp.Header.Get("ETag")})
+	}
+
+	body, err := xml.Marshal(done)
+	if err != nil {
+		return abort(err)
+	}
+	u, err = c.objectURL(obj.Bucket, obj.Key, url.Values{"uploadId": {init.UploadID}})
+	if err != nil {
+		return abort(err)
+	}
+	resp, err = c.send("CompleteMultipartUpload", http.MethodPost, u, bytes.NewReader(body), 0, int64(len(body)), nil)
+	if err != nil {
+		return abort(err)
+	}
+
+	// the completion can fail after the response status was sent, the error is then in the body
+	var res completeResult
+	if err := xml.Unmarshal(resp.body, &res); err != nil {
+		return abort(fmt.Errorf("s3 CompleteMultipartUpload: %w", err))
+	}
+	if res.XMLName.Local == "Error" {
+		res.Error.Op = "CompleteMultipartUpload"
+		res.Error.StatusCode = resp.StatusCode
+		return abort(&res.Error)
+	}
+
+	obj.ETag = res.ETag
+	obj.Parts = len(done.Parts)
+
+	return obj, nil
+}
+
+type response struct {
+	*http.Response
+	body []byte
+}
+
+// send Sends a signed request with `length` bytes of `body` from `off` as its body, retrying on network and server errors
+func (c *Client) send(op, method string, u *url.URL, body io.ReaderAt, off, length int64, header http.Header) (*response, error) {
+
+	md5sum, sha, err := "", emptySHA256, error(nil)
+	if body != nil {
+		if md5sum, sha, err = digest(io.NewSectionReader(body, off, length)); err != nil {
+			return nil, err
+		}
+	}
+
+	retries := c.Retries
+	if retries == 0 {
+		retries = defaultRetries
+	}
+
+	for attempt := 0; ; attempt++ {
+		var r io.Reader
+		if body != nil {
+			r = io.NewSectionReader(body, off, length)
+		}
+		req, err := http.NewRequest(method, u.String(), r)
+		if err != nil {
+			return nil, err
+		}
+		req.ContentLength = length
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		if body != nil {
+			req.Header.Set("Content-Md5", md5sum)
+		}
+		c.sign(req, sha, time.Now())
+
+		resp, err := c.httpClient().Do(req)
+		if err == nil {
+			var b []byte
+			b, err = ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err == nil && resp.StatusCode < 300 {
+				return &response{Response: resp, body: b}, nil
+			}
+			if err == nil {
+				e := &Error{Op: op, StatusCode: resp.StatusCode}
+				xml.Unmarshal(b, e)
+				err = e
+				if resp.StatusCode < 500 {
+					return nil, err
+				}
+			}
+		}
+
+		if attempt >= retries {
+			return nil, err
+		}
+		time.Sleep(time.Duration(attempt+1) * time.Second)
+	}
+}
+
+// digest Returns the base64 MD5 and the hex SHA-256 of the content
+func digest(r io.Reader) (string, string, error) {
+	m, s := md5.New(), sha256.New()
+	if _, err := io.Copy(io.MultiWriter(m, s), r); err != nil {
+		return "", "", err
+	}
+	return base64.StdEncoding.EncodeToString(m.Sum(nil)), hex.EncodeToString(s.Sum(nil)), nil
+}
+
+// Key Joins a prefix and a relative path into an object key, with `/` separators
+func Key(prefix, rel string) string {
+	rel = strings.TrimLeft(strings.ReplaceAll(rel, `\`, "/"), "/")
+	if prefix == "" {
+		return rel
+	}
+	return strings.TrimRight(prefix, "/") + "/" + rel
+}
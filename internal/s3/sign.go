@@ -0,0 +1,137 @@
+package s3
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	signAlgorithm = "AWS4-HMAC-SHA256"
+	amzDateFormat = "20060102T150405Z"
+	service       = "s3"
+
+	// emptySHA256 The hash of an empty payload
+	emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// sign Adds the AWS Signature Version 4 of the request to its headers.
+// `payloadHash` is the hex encoded SHA-256 of the body, checked by the server against the body it receives
+func (c *Client) sign(req *http.Request, payloadHash string, now time.Time) {
+
+	amzDate := now.UTC().Format(amzDateFormat)
+	date := amzDate[:8]
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if c.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.SessionToken)
+	}
+
+	headers, signed := canonicalHeaders(req)
+
+	canonical := strings.Join([]string{
+		req.Method,
+		canonicalPath(req.URL.Path),
+		canonicalQuery(req.URL.Query()),
+		headers,
+		signed,
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{date, c.region(), service, "aws4_request"}, "/")
+	toSign := strings.Join([]string{signAlgorithm, amzDate, scope, hexSHA256([]byte(canonical))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+c.SecretKey), date)
+	key = hmacSHA256(key, c.region())
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		signAlgorithm, c.AccessKey, scope, signed, signature))
+}
+
+// canonicalHeaders Returns the signed headers, lower case and sorted, one `name:value` per line, and the list of their names.
+// The host, the content headers and all the x-amz- headers are signed
+func canonicalHeaders(req *http.Request) (string, string) {
+
+	values := map[string]string{"host": req.URL.Host}
+	if req.Host != "" {
+		values["host"] = req.Host
+	}
+	for k, v := range req.Header {
+		name := strings.ToLower(k)
+		if strings.HasPrefix(name, "x-amz-") || name == "content-md5" || name == "content-type" || name == "range" {
+			values[name] = strings.Join(strings.Fields(strings.Join(v, ",")), " ")
+		}
+	}
+
+	names := make([]string, 0, len(values))
+	for k := range values {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	for _, k := range names {
+		sb.WriteString(k + ":" + values[k] + "\n")
+	}
+
+	return sb.String(), strings.Join(names, ";")
+}
+
+// canonicalPath Encodes every segment of the path. S3 paths are encoded once, unlike the other AWS services
+func canonicalPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	return encode(p, false)
+}
+
+// canonicalQuery Returns the query parameters sorted by name, then value, encoded
+func canonicalQuery(q url.Values) string {
+
+	var params []string
+	for k, vs := range q {
+		for _, v := range vs {
+			params = append(params, encode(k, true)+"="+encode(v, true))
+		}
+	}
+	sort.Strings(params)
+
+	return strings.Join(params, "&")
+}
+
+// encode Percent-encodes everything but the unreserved characters, and `/` unless `slash` is set
+func encode(s string, slash bool) string {
+	var sb strings.Builder
+	for _, b := range []byte(s) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9', b == '-', b == '_', b == '.', b == '~':
+			sb.WriteByte(b)
+		case b == '/' && !slash:
+			sb.WriteByte(b)
+		default:
+			fmt.Fprintf(&sb, "%%%02X", b)
+		}
+	}
+	return sb.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func hexSHA256(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
@@ -0,0 +1,33 @@
+package thinknum
+
+import (
+	"io"
+
+	"github.com/mehiX/thinknumV2/internal/logging"
+)
+
+// Logger A structured, leveled logger. Set it in the configuration to follow what the client is doing.
+// By default the client does not log anything
+type Logger = logging.Logger
+
+// NewLogger Returns a logger writing to `w` the messages of at least the given level (debug, info, warn or error).
+// Messages are written as text lines or as JSON objects if `asJSON` is set
+func NewLogger(w io.Writer, level string, asJSON bool) (Logger, error) {
+	l, err := logging.ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	return logging.New(w, l, asJSON), nil
+}
+
+// NopLogger Returns a logger that discards everything
+func NopLogger() Logger {
+	return logging.Nop()
+}
+
+func (ca ConfigAuth) log() Logger {
+	if ca.Logger == nil {
+		return logging.Nop()
+	}
+	return ca.Logger
+}
@@ -0,0 +1,15 @@
+package thinknum
+
+import (
+	"github.com/mehiX/thinknumV2/internal/metrics"
+)
+
+// Metrics Collects metrics about the runs: API requests by endpoint and status, request durations, retries,
+// gateway timeouts, token refreshes, rows and pages fetched, bytes written and worker utilization.
+// Serve them with `Handler()` or send them to a Pushgateway with `Push()`
+type Metrics = metrics.Registry
+
+// NewMetrics Returns an empty metrics registry. Set it in the configuration to instrument the client
+func NewMetrics() *Metrics {
+	return metrics.New()
+}
@@ -0,0 +1,335 @@
+package thinknum
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mehiX/thinknumV2/internal/query"
+)
+
+// outputToken A placeholder of an output path, e.g. `{dataset}`
+var outputToken = regexp.MustCompile(`\{([a-z_]*)\}`)
+
+// outputTokens The placeholders of the output paths, substituted for every write. Not to be confused with the `{{placeholders}}`
+// of the configuration, substituted once when it is loaded, see expandSearches:
+//
+//	{name}       the name of the search, with `/` replaced by `_`
+//	{dataset}    the dataset ID
+//	{run_date}   the day the search started, e.g. 2021-03-05
+//	{run_time}   the time the search started, in UTC, e.g. 20210305T063000Z
+//	{partition}  the partition of the rows, e.g. as_of_date=2021-03. Added as a directory above the file if missing
+//	{slice}      the number of the file within its partition, e.g. 000. Added before the extension if missing
+//	{ext}        the output type, e.g. csv. Added at the end of the path if missing
+var outputTokens = map[string]bool{
+	"name":      true,
+	"dataset":   true,
+	"run_date":  true,
+	"run_time":  true,
+	"partition": true,
+	"slice":     true,
+	"ext":       true,
+}
+
+// hivePartitionDefault The partition of the rows without a value, as named by Hive
+const hivePartitionDefault = "__HIVE_DEFAULT_PARTITION__"
+
+// Partition Splits the rows of the outputs in Hive style directories by the value of a column, e.g. `as_of_date=2021-03/`
+type Partition struct {
+	Column string `json:"column"`
+	// By For date columns: day, month or year. The rows are partitioned by the whole value if empty
+	By string `json:"by,omitempty"`
+}
+
+func (p *Partition) validate() error {
+	if p.Column == "" {
+		return fmt.Errorf("partition column is required")
+	}
+	switch p.By {
+	case "", "day", "month", "year":
+		return nil
+	}
+	return fmt.Errorf("unknown partition granularity %q, expected day, month or year", p.By)
+}
+
+// dir Returns the directory of the partition of a value, e.g. `as_of_date=2021-03` for `2021-03-05` by month
+func (p *Partition) dir(v interface{}) string {
+
+	s := ""
+	if v != nil {
+		s = strings.TrimSpace(fmt.Sprintf("%v", v))
+	}
+
+	n := 0
+	switch p.By {
+	case "day":
+		n = len("2006-01-02")
+	case "month":
+		n = len("2006-01")
+	case "year":
+		n = len("2006")
+	}
+	if n > 0 && len(s) >= n {
+		s = s[:n]
+	}
+
+	if s == "" {
+		s = hivePartitionDefault
+	}
+
+	return p.Column + "=" + strings.NewReplacer("/", "_", `\`, "_").Replace(s)
+}
+
+// checkOutputPath Checks that the output path only uses known placeholders
+func checkOutputPath(p string) error {
+	for _, m := range outputToken.FindAllStringSubmatch(p, -1) {
+		if !outputTokens[m[1]] {
+			return fmt.Errorf("unknown placeholder %s in output %s", m[0], p)
+		}
+	}
+	return nil
+}
+
+// withSuffix Adds a suffix to the output file name, before the extension, e.g. `out/jobs.partial` or `out/{name}.partial.{ext}`
+func withSuffix(output, suffix string) string {
+	if i := strings.LastIndex(output, ".{ext}"); i >= 0 {
+		return output[:i] + suffix + output[i:]
+	}
+	if i := strings.LastIndex(output, "{ext}"); i >= 0 {
+		return output[:i] + suffix + "." + output[i:]
+	}
+	return output + suffix
+}
+
+// outputLayout Where the files of a search are written
+type outputLayout struct {
+	pattern string
+	values  map[string]string
+}
+
+// newOutputLayout Returns the layout of the outputs of a search started at `run`
+func newOutputLayout(sd SearchDefinition, run time.Time) outputLayout {
+
+	if run.IsZero() {
+		run = time.Now()
+	}
+
+	p := sd.OutputFile
+	if !strings.Contains(p, "{ext}") {
+		p += ".{ext}"
+	}
+	if sd.RowsPerFile > 0 && !strings.Contains(p, "{slice}") {
+		p = withSuffix(p, "_{slice}")
+	}
+	if sd.Partition != nil && !strings.Contains(p, "{partition}") {
+		dir, file := filepath.Split(p)
+		p = filepath.Join(dir, "{partition}", file)
+	}
+
+	return outputLayout{
+		pattern: p,
+		values: map[string]string{
+			"name":     strings.NewReplacer("/", "_", `\`, "_").Replace(sd.Name),
+			"dataset":  sd.DatasetID,
+			"run_date": run.Format("2006-01-02"),
+			"run_time": run.UTC().Format("20060102T150405Z"),
+		},
+	}
+}
+
+// dynamicDirs Checks if the directories of the outputs depend on the search, the run or the rows, in which case they are created when needed
+func dynamicDirs(sd SearchDefinition) bool {
+	return outputToken.MatchString(filepath.Dir(sd.OutputFile)) || sd.Partition != nil
+}
+
+// path Returns the path of a file of the output type, for a partition directory (empty if not partitioned) and a slice number
+func (l outputLayout) path(ext, partition string, slice int) string {
+
+	p := outputToken.ReplaceAllStringFunc(l.pattern, func(t string) string {
+		switch name := t[1 : len(t)-1]; name {
+		case "ext":
+			return ext
+		case "partition":
+			return partition
+		case "slice":
+			return fmt.Sprintf("%03d", slice)
+		default:
+			return l.values[name]
+		}
+	})
+
+	// an empty partition leaves an empty directory name
+	if partition == "" && strings.Contains(l.pattern, "{partition}") {
+		p = filepath.Clean(p)
+	}
+
+	return p
+}
+
+// nextSlice Returns the number of the first slice without a file in the partition, where the rows appended to a sliced output go
+func (l outputLayout) nextSlice(ext, partition string) int {
+	n := 0
+	for {
+		if _, err := os.Stat(l.path(ext, partition, n)); os.IsNotExist(err) {
+			return n
+		}
+		n++
+	}
+}
+
+// removeStale Removes the files of the output type written by a previous run and not by this one, e.g. `part-003.csv` when this run
+// wrote 3 slices, or the files of a partition without rows anymore, and the partition directories left empty.
+// Only the partitions and the slices vary: the outputs of other runs in paths with `{run_date}` or `{run_time}` are kept
+func (l outputLayout) removeStale(ext string, partition *Partition, written map[string]bool) error {
+
+	if partition == nil && !strings.Contains(l.pattern, "{slice}") {
+		return nil
+	}
+
+	pattern := filepath.Clean(l.pattern)
+
+	var glob, match strings.Builder
+	match.WriteString("^")
+	last := 0
+	for _, loc := range outputToken.FindAllStringSubmatchIndex(pattern, -1) {
+		literal := pattern[last:loc[0]]
+		glob.WriteString(globEscape(literal))
+		match.WriteString(regexp.QuoteMeta(literal))
+		last = loc[1]
+
+		switch name := pattern[loc[2]:loc[3]]; name {
+		case "partition":
+			if partition != nil {
+				glob.WriteString("*")
+				match.WriteString(regexp.QuoteMeta(partition.Column+"=") + `[^/\\]+`)
+			}
+		case "slice":
+			glob.WriteString("*")
+			match.WriteString("[0-9]{3,}")
+		case "ext":
+			glob.WriteString(globEscape(ext))
+			match.WriteString(regexp.QuoteMeta(ext))
+		default:
+			glob.WriteString(globEscape(l.values[name]))
+			match.WriteString(regexp.QuoteMeta(l.values[name]))
+		}
+	}
+	glob.WriteString(globEscape(pattern[last:]))
+	match.WriteString(regexp.QuoteMeta(pattern[last:]) + "$")
+
+	files, err := filepath.Glob(filepath.Clean(glob.String()))
+	if err != nil {
+		return err
+	}
+	re, err := regexp.Compile(match.String())
+	if err != nil {
+		return err
+	}
+
+	for _, fn := range files {
+		if written[fn] || !re.MatchString(fn) {
+			continue
+		}
+		if err := os.Remove(fn); err != nil {
+			return err
+		}
+		if partition != nil {
+			// fails, as it should, if the directory is not empty
+			os.Remove(filepath.Dir(fn))
+		}
+	}
+
+	return nil
+}
+
+// globEscape Escapes the characters with a special meaning in filepath.Match patterns
+func globEscape(s string) string {
+	return strings.NewReplacer("*", "[*]", "?", "[?]", "[", "[[]").Replace(s)
+}
+
+// sliceToken The slice placeholder, with the separator before it
+var sliceToken = regexp.MustCompile(`[_\-.]?\{slice\}`)
+
+// sidecar Returns the path of a file describing all the outputs, e.g. `out/jobs.batches.json`, next to the outputs
+// but outside of the partitions and without a slice number
+func (l outputLayout) sidecar(ext string) string {
+	return outputLayout{pattern: sliceToken.ReplaceAllString(l.pattern, ""), values: l.values}.path(ext, "", 0)
+}
+
+// writeSidecar Writes a file describing the outputs of the search, see outputLayout.sidecar. Returns its path
+func writeSidecar(sr SearchResult, ext string, b []byte) (string, error) {
+
+	fn := newOutputLayout(sr.Search, sr.Started).sidecar(ext)
+	if dynamicDirs(sr.Search) {
+		if err := os.MkdirAll(filepath.Dir(fn), 0777); err != nil {
+			return fn, err
+		}
+	}
+
+	return fn, writeFile(fn, b)
+}
+
+// outputPart The rows written to one file
+type outputPart struct {
+	partition string
+	slice     int
+	rows      []query.Row
+}
+
+// outputParts Splits the rows of the results in partitions, then in slices of at most RowsPerFile rows.
+// Returns a single part with all the rows if the search is not partitioned nor sliced
+func outputParts(sd SearchDefinition, d query.RowsItems) ([]outputPart, error) {
+
+	groups := map[string][]query.Row{"": d.Rows}
+	keys := []string{""}
+
+	if sd.Partition != nil {
+		col := -1
+		for i, f := range d.Fields {
+			if f.ID == sd.Partition.Column {
+				col = i
+				break
+			}
+		}
+		if col < 0 {
+			return nil, fmt.Errorf("partition column %s is not in the results", sd.Partition.Column)
+		}
+
+		groups = make(map[string][]query.Row)
+		keys = nil
+		for _, r := range d.Rows {
+			var v interface{}
+			if col < len(r) {
+				v = r[col]
+			}
+			k := sd.Partition.dir(v)
+			if _, ok := groups[k]; !ok {
+				keys = append(keys, k)
+			}
+			groups[k] = append(groups[k], r)
+		}
+		sort.Strings(keys)
+	}
+
+	var parts []outputPart
+	for _, k := range keys {
+		rows := groups[k]
+		if sd.RowsPerFile <= 0 || len(rows) <= sd.RowsPerFile {
+			parts = append(parts, outputPart{partition: k, rows: rows})
+			continue
+		}
+		for i := 0; i*sd.RowsPerFile < len(rows); i++ {
+			end := (i + 1) * sd.RowsPerFile
+			if end > len(rows) {
+				end = len(rows)
+			}
+			parts = append(parts, outputPart{partition: k, slice: i, rows: rows[i*sd.RowsPerFile : end]})
+		}
+	}
+
+	return parts, nil
+}
@@ -0,0 +1,172 @@
+package thinknum
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mehiX/thinknumV2/internal/query"
+)
+
+func TestOutputLayout(t *testing.T) {
+
+	run := time.Date(2021, 3, 5, 6, 30, 0, 0, time.UTC)
+
+	var scenarios = []struct {
+		output    string
+		partition *Partition
+		rows      int
+		suffix    string
+		expected  string
+	}{
+		{"out/jobs", nil, 0, "", "out/jobs.csv"},
+		{"out/jobs", nil, 0, partialSuffix, "out/jobs.partial.csv"},
+		{"out/jobs", nil, 10, "", "out/jobs_002.csv"},
+		{"out/jobs", &Partition{Column: "as_of_date"}, 0, "", "out/as_of_date=2021-03/jobs.csv"},
+		{"out/{dataset}/{name}/dt={run_date}/part-{slice}.{ext}", nil, 0, "", "out/job_listings/golang_rust/dt=2021-03-05/part-002.csv"},
+		{"out/{dataset}/{partition}/{run_time}.{ext}", &Partition{Column: "as_of_date"}, 0, partialSuffix, "out/job_listings/as_of_date=2021-03/20210305T063000Z.partial.csv"},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.output, func(t *testing.T) {
+			sd := SearchDefinition{Name: "golang/rust", DatasetID: "job_listings", OutputFile: withSuffix(s.output, s.suffix), Partition: s.partition, RowsPerFile: s.rows}
+			partition := ""
+			if s.partition != nil {
+				partition = "as_of_date=2021-03"
+			}
+			if got := newOutputLayout(sd, run).path("csv", partition, 2); got != s.expected {
+				t.Errorf("Wrong path. Expected: %s, got: %s", s.expected, got)
+			}
+		})
+	}
+}
+
+func TestWriteOutputPartitioned(t *testing.T) {
+
+	dir := t.TempDir()
+
+	sr := SearchResult{
+		Search: SearchDefinition{
+			Name:        "jobs",
+			DatasetID:   "job_listings",
+			OutputFile:  filepath.Join(dir, "{dataset}", "part-{slice}.{ext}"),
+			OutputTypes: []string{"csv"},
+			Partition:   &Partition{Column: "as_of_date", By: "month"},
+			RowsPerFile: 2,
+		},
+		Started: time.Now(),
+	}
+	sr.Data.Fields = []query.Field{{ID: "title", DisplayName: "Title"}, {ID: "as_of_date", DisplayName: "Date"}}
+	sr.Data.Rows = []query.Row{
+		{"a", "2021-03-01"},
+		{"b", "2021-02-10"},
+		{"c", "2021-03-02"},
+		{"d", "2021-03-03"},
+		{"e", nil},
+	}
+	sr.Data.Total = 5
+
+	results, _ := saveOutputs(sr, false)
+
+	var files []string
+	for _, r := range results {
+		if r.Error != nil {
+			t.Fatal(r.Error)
+		}
+		rel, _ := filepath.Rel(dir, r.Path)
+		files = append(files, filepath.ToSlash(rel))
+	}
+	sort.Strings(files)
+
+	expected := []string{
+		"job_listings/as_of_date=2021-02/part-000.csv",
+		"job_listings/as_of_date=2021-03/part-000.csv",
+		"job_listings/as_of_date=2021-03/part-001.csv",
+		"job_listings/as_of_date=" + hivePartitionDefault + "/part-000.csv",
+	}
+	if strings.Join(files, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Wrong files. Expected: %v, got: %v", expected, files)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, expected[2]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "Title,Date\nd,2021-03-03\n" {
+		t.Errorf("Wrong content of the second slice: %q", b)
+	}
+
+	sr.Search.Partition.Column = "country"
+	if res, failed := saveOutputs(sr, false); len(res) != 1 || !failed {
+		t.Errorf("Expected an error for a partition column missing from the results, got: %v", res)
+	}
+}
+
+func TestWriteOutputSlicedRuns(t *testing.T) {
+
+	dir := t.TempDir()
+
+	run := func(watermark string, rows ...query.Row) []SaveResult {
+		sr := SearchResult{
+			Search: SearchDefinition{
+				Name:        "jobs",
+				OutputFile:  filepath.Join(dir, "part-{slice}.{ext}"),
+				OutputTypes: []string{"csv"},
+				Partition:   &Partition{Column: "country"},
+				RowsPerFile: 2,
+			},
+			Watermark: watermark,
+			Started:   time.Now(),
+		}
+		sr.Data.Fields = []query.Field{{ID: "title", DisplayName: "Title"}, {ID: "country", DisplayName: "Country"}}
+		sr.Data.Rows = rows
+		saved, failed := saveOutputs(sr, false)
+		if failed {
+			t.Fatal("Expected the outputs to be saved")
+		}
+		return saved
+	}
+
+	files := func() string {
+		var files []string
+		filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+			if err == nil && p != dir {
+				rel, _ := filepath.Rel(dir, p)
+				files = append(files, filepath.ToSlash(rel))
+			}
+			return nil
+		})
+		return strings.Join(files, ",")
+	}
+
+	run("", query.Row{"a", "US"}, query.Row{"b", "US"}, query.Row{"c", "US"}, query.Row{"d", "DE"})
+	// appended rows go to new slices, the existing ones keep at most 2 rows
+	run("2021-03-05", query.Row{"e", "US"})
+
+	expected := "country=DE,country=DE/part-000.csv,country=US,country=US/part-000.csv,country=US/part-001.csv,country=US/part-002.csv"
+	if got := files(); got != expected {
+		t.Errorf("Wrong files after the incremental run.\nExpected: %s\ngot:      %s", expected, got)
+	}
+	b, _ := ioutil.ReadFile(filepath.Join(dir, "country=US", "part-002.csv"))
+	if string(b) != "Title,Country\ne,US\n" {
+		t.Errorf("Wrong content of the appended slice: %q", b)
+	}
+
+	// a rewrite removes the slices and the partitions it doesn't write anymore
+	run("", query.Row{"f", "US"})
+	if got := files(); got != "country=US,country=US/part-000.csv" {
+		t.Errorf("Wrong files after the rewrite: %s", got)
+	}
+
+	// a rewrite without rows writes no partition, the previous ones are kept
+	if saved := run(""); len(saved) != 1 || saved[0].Skipped == "" || saved[0].Path != "" {
+		t.Errorf("Expected the empty write to be reported, got: %+v", saved)
+	}
+	if got := files(); got != "country=US,country=US/part-000.csv" {
+		t.Errorf("Wrong files after an empty rewrite: %s", got)
+	}
+}
@@ -0,0 +1,57 @@
+package thinknum
+
+import (
+	"math"
+	"time"
+)
+
+// DefaultMaxPages Searches that need more pages than this are good candidates for splitting, unless another limit is given to PlanSearch
+const DefaultMaxPages = 10
+
+// SearchPlan The estimated size of a search, as reported by a dry run
+type SearchPlan struct {
+	Search SearchDefinition
+	// Number of records the search returns
+	Total int
+	// Number of requests needed to fetch all the records at the configured page size
+	Pages int
+	// Number of smaller searches this search should be split into. 1 means no split is needed
+	Slices int
+	// Suggested `interval` for `splitsrch`. Zero if no split is needed or the date range of the search is unknown
+	Interval time.Duration
+}
+
+// PlanSearch Estimates the number of pages for a search returning `total` records and suggests how to split it
+// in searches of at most `maxPages` pages. DefaultMaxPages is used if `maxPages` is not positive
+func PlanSearch(sd SearchDefinition, total, pageSize, maxPages int) SearchPlan {
+
+	if maxPages <= 0 {
+		maxPages = DefaultMaxPages
+	}
+
+	p := SearchPlan{
+		Search: sd,
+		Total:  total,
+		Slices: 1,
+	}
+
+	if pageSize > 0 {
+		p.Pages = int(math.Ceil(float64(total) / float64(pageSize)))
+	}
+
+	if p.Pages <= maxPages {
+		return p
+	}
+
+	p.Slices = int(math.Ceil(float64(p.Pages) / float64(maxPages)))
+
+	if from, to, ok := sd.dateRange(time.Now()); ok {
+		days := math.Ceil(to.Sub(from).Hours() / 24 / float64(p.Slices))
+		if days < 1 {
+			days = 1
+		}
+		p.Interval = time.Duration(days) * 24 * time.Hour
+	}
+
+	return p
+}
@@ -0,0 +1,77 @@
+package thinknum
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDateRange(t *testing.T) {
+
+	now := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	day := func(s string) time.Time {
+		d, _ := time.Parse("2006-01-02", s)
+		return d
+	}
+
+	var scenarios = []struct {
+		where    string
+		from, to string
+		ok       bool
+	}{
+		{"as_of_date >= 2021-01-01 and as_of_date < 2021-03-01", "2021-01-01", "2021-03-01", true},
+		{"as_of_date > 2021-01-01 and as_of_date <= 2021-03-01", "2021-01-01", "2021-03-01", true},
+		{"as_of_date >= 2021-01-01", "2021-01-01", "2021-06-01", true},
+		{"as_of_date < 2021-03-01", "", "", false},
+		{"as_of_date >= 2021-03-01 and as_of_date < 2021-03-01", "", "", false},
+		{"as_of_date >= 2021-07-01", "", "", false},
+		{`country = "US" and as_of_date >= 2021-01-01`, "2021-01-01", "2021-06-01", true},
+		{`as_of_date >= "yesterday"`, "", "", false},
+		{"", "", "", false},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.where, func(t *testing.T) {
+			from, to, ok := SearchDefinition{Where: s.where}.dateRange(now)
+			if ok != s.ok {
+				t.Fatalf("Expected ok: %v, got: %v (%s - %s)", s.ok, ok, from, to)
+			}
+			if ok && (!from.Equal(day(s.from)) || !to.Equal(day(s.to))) {
+				t.Errorf("Wrong range. Expected: %s - %s, got: %s - %s", s.from, s.to, from, to)
+			}
+		})
+	}
+}
+
+func TestPlanSearch(t *testing.T) {
+
+	const where = "as_of_date >= 2021-01-01 and as_of_date < 2021-03-01"
+
+	var scenarios = []struct {
+		where    string
+		total    int
+		pageSize int
+		maxPages int
+		pages    int
+		slices   int
+		interval int
+	}{
+		{where, 0, 100, 0, 0, 1, 0},
+		{where, 1, 100, 0, 1, 1, 0},
+		{where, 1000, 100, 0, 10, 1, 0},
+		{where, 1001, 100, 0, 11, 2, 30},
+		{where, 10000, 100, 0, 100, 10, 6},
+		{where, 100000, 100, 0, 1000, 100, 1},
+		{"", 1001, 100, 0, 11, 2, 0},
+		{where, 1001, 0, 0, 0, 1, 0},
+		{where, 1001, 100, 20, 11, 1, 0},
+		{where, 1001, 100, 5, 11, 3, 20},
+	}
+
+	for _, s := range scenarios {
+		p := PlanSearch(SearchDefinition{Where: s.where}, s.total, s.pageSize, s.maxPages)
+		if p.Total != s.total || p.Pages != s.pages || p.Slices != s.slices || p.Interval != time.Duration(s.interval)*24*time.Hour {
+			t.Errorf("Wrong plan for %d records by %d, at most %d pages (%q). Expected: %d pages, %d slices, %d days, got: %d pages, %d slices, %s",
+				s.total, s.pageSize, s.maxPages, s.where, s.pages, s.slices, s.interval, p.Pages, p.Slices, p.Interval)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package thinknum
+
+import (
+	"time"
+
+	"github.com/mehiX/thinknumV2/internal/query"
+)
+
+// ProgressKind What happened to a search
+type ProgressKind int
+
+// Progress events, in the order they happen for a search
+const (
+	// ProgressStarted The search started
+	ProgressStarted ProgressKind = iota
+	// ProgressPage A page of results was fetched
+	ProgressPage
+	// ProgressRetry A request failed and is sent again
+	ProgressRetry
+	// ProgressTimeout The API is still preparing the results (gateway timeout). The request is sent again
+	ProgressTimeout
+	// ProgressFinished All the pages were fetched, or the search failed
+	ProgressFinished
+	// ProgressWritten The results were written in one of the output types
+	ProgressWritten
+)
+
+func (k ProgressKind) String() string {
+	switch k {
+	case ProgressStarted:
+		return "started"
+	case ProgressPage:
+		return "page"
+	case ProgressRetry:
+		return "retry"
+	case ProgressTimeout:
+		return "timeout"
+	case ProgressFinished:
+		return "finished"
+	default:
+		return "written"
+	}
+}
+
+// ProgressEvent Reports the progress of a running search. Set `Config.OnProgress` to receive them
+type ProgressEvent struct {
+	Kind    ProgressKind
+	Time    time.Time
+	Search  string
+	Dataset string
+	// Number of pages and rows fetched so far, and the total number of rows of the search
+	Page  int
+	Rows  int
+	Total int
+	// Number of the retry, for ProgressRetry
+	Attempt int
+	// The output type written, for ProgressWritten
+	OutputType string
+	Err        error
+}
+
+// progress Sends an event to the progress handler, if there is one
+func (c *client) progress(sd SearchDefinition, e ProgressEvent) {
+	if c.OnProgress == nil {
+		return
+	}
+
+	e.Time = time.Now()
+	e.Search = sd.Name
+	e.Dataset = sd.DatasetID
+
+	c.OnProgress(e)
+}
+
+// queryObserver Translates the events of the query package to progress events for the search
+func (c *client) queryObserver(sd SearchDefinition) func(query.Event) {
+	if c.OnProgress == nil {
+		return nil
+	}
+
+	return func(qe query.Event) {
+		e := ProgressEvent{
+			Page:    qe.Page,
+			Rows:    qe.Rows,
+			Total:   qe.Total,
+			Attempt: qe.Attempt,
+			Err:     qe.Err,
+		}
+
+		switch qe.Kind {
+		case query.EventPage:
+			e.Kind = ProgressPage
+		case query.EventRetry:
+			e.Kind = ProgressRetry
+		case query.EventTimeout:
+			e.Kind = ProgressTimeout
+		}
+
+		c.progress(sd, e)
+	}
+}
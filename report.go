@@ -0,0 +1,220 @@
+package thinknum
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Exit code policies for a run
+const (
+	// FailAny The run fails if at least one search fails
+	FailAny = "fail-any"
+	// FailAll The run only fails if all the searches fail
+	FailAll = "fail-all"
+	// FailNever The run never fails
+	FailNever = "never"
+)
+
+// RunReport A summary of a run of several searches
+type RunReport struct {
+	Started   time.Time `json:"started"`
+	Finished  time.Time `json:"finished"`
+	Duration  float64   `json:"duration_seconds"`
+	Succeeded int       `json:"succeeded"`
+	Failed    int       `json:"failed"`
+	// UploadErrors Number of files saved but not uploaded. They don't fail their search, the next run uploads them again
+	UploadErrors int            `json:"upload_errors"`
+	Searches     []SearchReport `json:"searches"`
+}
+
+// SearchReport The outcome of one search
+type SearchReport struct {
+	Name    string `json:"name"`
+	Dataset string `json:"dataset"`
+	// ok or failed. A search fails if fetching the results or writing any of the outputs failed
+	Status   string         `json:"status"`
+	Rows     int            `json:"rows"`
+	Total    int            `json:"total"`
+	Pages    int            `json:"pages"`
+	Duration float64        `json:"duration_seconds"`
+	Retries  int            `json:"retries"`
+	Timeouts int            `json:"timeouts"`
+	Outputs  []OutputReport `json:"outputs,omitempty"`
+	Error    string         `json:"error,omitempty"`
+	Warning  string         `json:"warning,omitempty"`
+	// For searches split by tickers
+	Batches []Batch `json:"batches,omitempty"`
+}
+
+// OutputReport A file written by a search
+type OutputReport struct {
+	Type   string `json:"type"`
+	Path   string `json:"path,omitempty"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256,omitempty"`
+	// URI The object the file was uploaded to, e.g. `s3://bucket/out/jobs.csv`
+	URI   string `json:"uri,omitempty"`
+	Error string `json:"error,omitempty"`
+	// UploadError The file was saved but could not be uploaded
+	UploadError string `json:"upload_error,omitempty"`
+	// Skipped Why no file was written, see SaveResult.Skipped
+	Skipped string `json:"skipped,omitempty"`
+}
+
+// NewRunReport Starts a report for a run starting now
+func NewRunReport() *RunReport {
+	return &RunReport{Started: time.Now()}
+}
+
+// Add Adds a search to the report, with the results of saving its outputs (if they were saved)
+func (r *RunReport) Add(sr SearchResult, saved []SaveResult) {
+
+	s := SearchReport{
+		Name:     sr.Search.Name,
+		Dataset:  sr.Search.DatasetID,
+		Status:   "ok",
+		Rows:     len(sr.Data.Rows),
+		Total:    sr.Data.Total,
+		Pages:    sr.Data.Pages,
+		Duration: sr.Duration.Seconds(),
+		Retries:  sr.Retries,
+		Timeouts: sr.Timeouts,
+		Batches:  sr.Batches,
+	}
+
+	if sr.Error != nil {
+		s.Status = "failed"
+		s.Error = sr.Error.Error()
+	}
+	if sr.Warning != nil {
+		s.Warning = sr.Warning.Error()
+	}
+
+	for _, sv := range saved {
+		o := OutputReport{
+			Type:    sv.Type,
+			Path:    sv.Path,
+			Size:    sv.Size,
+			SHA256:  sv.SHA256,
+			URI:     sv.URI,
+			Skipped: sv.Skipped,
+		}
+		if sv.Error != nil {
+			o.Error = sv.Error.Error()
+			s.Status = "failed"
+			if s.Error == "" {
+				s.Error = fmt.Sprintf("output %s: %v", sv.Type, sv.Error)
+			}
+		}
+		if sv.UploadError != nil {
+			o.UploadError = sv.UploadError.Error()
+			r.UploadErrors++
+			if s.Warning == "" {
+				s.Warning = fmt.Sprintf("output %s: %v", sv.Type, sv.UploadError)
+			}
+		}
+		s.Outputs = append(s.Outputs, o)
+	}
+
+	if s.Status == "ok" {
+		r.Succeeded++
+	} else {
+		r.Failed++
+	}
+
+	r.Searches = append(r.Searches, s)
+}
+
+// Finish Records the end of the run
+func (r *RunReport) Finish() {
+	r.Finished = time.Now()
+	r.Duration = r.Finished.Sub(r.Started).Seconds()
+}
+
+// ExitCode Returns the exit code for the run according to the policy: fail-any, fail-all or never
+func (r *RunReport) ExitCode(policy string) int {
+	switch policy {
+	case FailAll:
+		if r.Failed > 0 && r.Succeeded == 0 {
+			return 1
+		}
+	case FailNever:
+	default:
+		if r.Failed > 0 {
+			return 1
+		}
+	}
+	return 0
+}
+
+// ValidateFailPolicy Checks that the policy is one of fail-any, fail-all or never
+func ValidateFailPolicy(policy string) error {
+	switch policy {
+	case FailAny, FailAll, FailNever:
+		return nil
+	}
+	return fmt.Errorf("unknown fail policy %q, expected one of %s, %s, %s", policy, FailAny, FailAll, FailNever)
+}
+
+// Save Writes the report to file, as Markdown if the file has a `.md` extension and as JSON otherwise
+func (r *RunReport) Save(fn string) error {
+
+	return WriteFileAtomic(fn, 0644, func(w io.Writer) error {
+		if strings.EqualFold(filepath.Ext(fn), ".md") {
+			return r.WriteMarkdown(w)
+		}
+		return r.WriteJSON(w)
+	})
+}
+
+// WriteJSON Writes the report as an indented JSON object
+func (r *RunReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "    ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(r)
+}
+
+// WriteMarkdown Writes the report as Markdown tables
+func (r *RunReport) WriteMarkdown(w io.Writer) error {
+
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "# Thinknum run report\n\n")
+	fmt.Fprintf(&sb, "- Started: %s\n", r.Started.Format(time.RFC3339))
+	fmt.Fprintf(&sb, "- Finished: %s\n", r.Finished.Format(time.RFC3339))
+	fmt.Fprintf(&sb, "- Duration: %s\n", time.Duration(r.Duration*float64(time.Second)).Round(time.Second))
+	fmt.Fprintf(&sb, "- Searches: %d succeeded, %d failed\n", r.Succeeded, r.Failed)
+	fmt.Fprintf(&sb, "- Upload errors: %d\n\n", r.UploadErrors)
+
+	fmt.Fprintf(&sb, "| Search | Dataset | Status | Rows | Total | Pages | Duration | Retries | Timeouts | Error | Warning |\n")
+	fmt.Fprintf(&sb, "|---|---|---|---:|---:|---:|---:|---:|---:|---|---|\n")
+	for _, s := range r.Searches {
+		fmt.Fprintf(&sb, "| %s | %s | %s | %d | %d | %d | %s | %d | %d | %s | %s |\n",
+			mdEscape(s.Name), mdEscape(s.Dataset), s.Status, s.Rows, s.Total, s.Pages,
+			time.Duration(s.Duration*float64(time.Second)).Round(time.Millisecond), s.Retries, s.Timeouts,
+			mdEscape(s.Error), mdEscape(s.Warning))
+	}
+
+	fmt.Fprintf(&sb, "\n## Outputs\n\n")
+	fmt.Fprintf(&sb, "| Search | Type | Path | Size | SHA-256 | URI | Error | Upload error |\n")
+	fmt.Fprintf(&sb, "|---|---|---|---:|---|---|---|---|\n")
+	for _, s := range r.Searches {
+		for _, o := range s.Outputs {
+			fmt.Fprintf(&sb, "| %s | %s | %s | %d | %s | %s | %s | %s |\n",
+				mdEscape(s.Name), o.Type, mdEscape(o.Path), o.Size, o.SHA256, mdEscape(o.URI), mdEscape(o.Error), mdEscape(o.UploadError))
+		}
+	}
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func mdEscape(s string) string {
+	s = strings.ReplaceAll(s, "|", "\\|")
+	return strings.ReplaceAll(s, "\n", " ")
+}
@@ -0,0 +1,129 @@
+package thinknum
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata")
+
+func TestExitCode(t *testing.T) {
+
+	var scenarios = []struct {
+		succeeded, failed int
+		policy            string
+		expected          int
+	}{
+		{3, 0, FailAny, 0},
+		{2, 1, FailAny, 1},
+		{0, 3, FailAny, 1},
+		{0, 0, FailAny, 0},
+		{2, 1, "", 1},
+		{3, 0, FailAll, 0},
+		{2, 1, FailAll, 0},
+		{0, 3, FailAll, 1},
+		{0, 0, FailAll, 0},
+		{2, 1, FailNever, 0},
+		{0, 3, FailNever, 0},
+	}
+
+	for _, s := range scenarios {
+		r := RunReport{Succeeded: s.succeeded, Failed: s.failed}
+		if got := r.ExitCode(s.policy); got != s.expected {
+			t.Errorf("Wrong exit code for %d succeeded, %d failed with %q. Expected: %d, got: %d", s.succeeded, s.failed, s.policy, s.expected, got)
+		}
+	}
+}
+
+func TestRunReportStatus(t *testing.T) {
+
+	search := SearchResult{Search: SearchDefinition{Name: "jobs", DatasetID: "job_listings"}}
+	failed := search
+	failed.Error = errors.New("forbidden")
+	incomplete := search
+	incomplete.Warning = &IncompleteError{Fetched: 1, Total: 2}
+
+	var scenarios = []struct {
+		name   string
+		sr     SearchResult
+		saved  []SaveResult
+		status string
+		err    string
+	}{
+		{"ok", search, []SaveResult{{Type: "csv"}}, "ok", ""},
+		{"not saved", search, nil, "ok", ""},
+		{"incomplete", incomplete, []SaveResult{{Type: "csv"}}, "ok", ""},
+		{"search error", failed, nil, "failed", "forbidden"},
+		{"write error", search, []SaveResult{{Type: "csv"}, {Type: "json", Error: errors.New("disk full")}}, "failed", "output json: disk full"},
+		{"upload error", search, []SaveResult{{Type: "csv", UploadError: errors.New("access denied")}}, "ok", ""},
+		{"search error first", failed, []SaveResult{{Type: "csv", Error: errors.New("disk full")}}, "failed", "forbidden"},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			r := NewRunReport()
+			r.Add(s.sr, s.saved)
+			got := r.Searches[0]
+			if got.Status != s.status || got.Error != s.err {
+				t.Errorf("Wrong status. Expected: %s (%q), got: %s (%q)", s.status, s.err, got.Status, got.Error)
+			}
+			if ok := s.status == "ok"; (r.Succeeded == 1) != ok || (r.Failed == 1) == ok {
+				t.Errorf("Wrong counts: %d succeeded, %d failed", r.Succeeded, r.Failed)
+			}
+			if len(got.Outputs) != len(s.saved) {
+				t.Errorf("Expected %d outputs, got: %d", len(s.saved), len(got.Outputs))
+			}
+			// upload errors are counted apart, the files are saved
+			if upload := s.name == "upload error"; (r.UploadErrors == 1) != upload || (got.Warning == "output csv: access denied") != upload {
+				t.Errorf("Wrong upload errors: %d, warning %q", r.UploadErrors, got.Warning)
+			}
+		})
+	}
+}
+
+func TestWriteMarkdown(t *testing.T) {
+
+	started := time.Date(2021, 3, 5, 6, 30, 0, 0, time.UTC)
+
+	r := &RunReport{Started: started}
+	r.Add(SearchResult{
+		Search:   SearchDefinition{Name: "golang | rust", DatasetID: "job_listings"},
+		Duration: 1500 * time.Millisecond,
+		Retries:  2,
+		Timeouts: 3,
+		Warning:  &IncompleteError{Fetched: 9, Total: 10, Missing: []Range{{Start: 9, End: 10}}},
+	}, []SaveResult{
+		{Type: "csv", Path: "out/golang|rust.csv", Size: 120, SHA256: "abc123", URI: "s3://research/out/golang|rust.csv"},
+		{Type: "csv", Path: "out/golang|rust.2.csv", Size: 80, SHA256: "def456", UploadError: errors.New("access denied")},
+		{Type: "json", Path: "out/golang|rust.json", Error: errors.New("disk full\nretry later")},
+	})
+	failed := SearchResult{Search: SearchDefinition{Name: "stores", DatasetID: "store"}}
+	failed.Error = errors.New("unauthorized")
+	r.Add(failed, nil)
+	r.Finished = started.Add(90 * time.Second)
+	r.Duration = 90
+
+	var buf bytes.Buffer
+	if err := r.WriteMarkdown(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	golden := filepath.Join("testdata", "report.md")
+	if *updateGolden {
+		if err := ioutil.WriteFile(golden, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("Wrong Markdown report.\nExpected:\n%s\ngot:\n%s", expected, buf.Bytes())
+	}
+}
@@ -0,0 +1,215 @@
+package thinknum
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule Tells when a recurring search should run next
+type Schedule interface {
+	// Next Returns the first time after `t` when the search should run
+	Next(t time.Time) time.Time
+}
+
+// ParseSchedule Parses a cron-like schedule specification. Supported formats:
+//
+//	"30 6 * * 1-5"  minute, hour, day of month, month and day of week, as for cron
+//	"@daily"        also @hourly, @midnight, @weekly, @monthly, @yearly and @annually
+//	"@every 90m"    a fixed interval, expressed as a Golang duration
+//
+// Fields accept `*`, single values, ranges (`1-5`), steps (`*/15`, `0-30/10`) and lists (`1,15`).
+// Months and days of the week can also be written as names (`jan`, `mon`).
+func ParseSchedule(spec string) (Schedule, error) {
+
+	spec = strings.TrimSpace(spec)
+
+	if strings.HasPrefix(spec, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every ")))
+		if err != nil {
+			return nil, fmt.Errorf("schedule %q: %w", spec, err)
+		}
+		if d < time.Minute {
+			return nil, fmt.Errorf("schedule %q: the interval should be at least one minute", spec)
+		}
+		return everySchedule(d), nil
+	}
+
+	if m, ok := scheduleMacros[spec]; ok {
+		spec = m
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("schedule %q: expected 5 fields (minute hour day-of-month month day-of-week), got %d", spec, len(fields))
+	}
+
+	var cs cronSchedule
+	var err error
+
+	if cs.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("schedule %q: minute: %w", spec, err)
+	}
+	if cs.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("schedule %q: hour: %w", spec, err)
+	}
+	if cs.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("schedule %q: day of month: %w", spec, err)
+	}
+	if cs.month, err = parseCronField(fields[3], 1, 12, monthNames); err != nil {
+		return nil, fmt.Errorf("schedule %q: month: %w", spec, err)
+	}
+	if cs.dow, err = parseCronField(fields[4], 0, 7, dayNames); err != nil {
+		return nil, fmt.Errorf("schedule %q: day of week: %w", spec, err)
+	}
+
+	// both 0 and 7 mean Sunday
+	if cs.dow[7] {
+		cs.dow[0] = true
+	}
+
+	cs.domStar = fields[2] == "*"
+	cs.dowStar = fields[4] == "*"
+
+	return cs, nil
+}
+
+var scheduleMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var monthNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var dayNames = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+type everySchedule time.Duration
+
+func (e everySchedule) Next(t time.Time) time.Time {
+	return t.Truncate(time.Second).Add(time.Duration(e))
+}
+
+type cronSchedule struct {
+	minute, hour, dom, month, dow []bool
+	// cron semantics: when both days are restricted, a day matching either of them is a match
+	domStar, dowStar bool
+}
+
+// Next Advances field by field until all the fields match. Gives up after 5 years (e.g. for February 30th)
+func (cs cronSchedule) Next(t time.Time) time.Time {
+
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if !cs.month[t.Month()] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !cs.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !cs.hour[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if !cs.minute[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+
+	return time.Time{}
+}
+
+func (cs cronSchedule) dayMatches(t time.Time) bool {
+	dom := cs.dom[t.Day()]
+	dow := cs.dow[t.Weekday()]
+
+	switch {
+	case cs.domStar && cs.dowStar:
+		return true
+	case cs.domStar:
+		return dow
+	case cs.dowStar:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// parseCronField Parses one field of a cron specification into the set of allowed values
+func parseCronField(field string, min, max int, names map[string]int) ([]bool, error) {
+
+	set := make([]bool, max+1)
+
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+
+		if i := strings.Index(part, "/"); i >= 0 {
+			rng = part[:i]
+			s, err := strconv.Atoi(part[i+1:])
+			if err != nil || s < 1 {
+				return nil, fmt.Errorf("invalid step in %q", part)
+			}
+			step = s
+		}
+
+		lo, hi := min, max
+		if rng != "*" {
+			bounds := strings.SplitN(rng, "-", 2)
+			var err error
+			if lo, err = cronValue(bounds[0], min, max, names); err != nil {
+				return nil, err
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = cronValue(bounds[1], min, max, names); err != nil {
+					return nil, err
+				}
+			} else if step > 1 {
+				// `5/15` means from 5 to the end in steps of 15
+				hi = max
+			}
+			if hi < lo {
+				return nil, fmt.Errorf("invalid range %q", rng)
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+
+	return set, nil
+}
+
+func cronValue(s string, min, max int, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	if v < min || v > max {
+		return 0, fmt.Errorf("value %d out of range [%d-%d]", v, min, max)
+	}
+
+	return v, nil
+}
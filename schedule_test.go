@@ -0,0 +1,55 @@
+package thinknum
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleNext(t *testing.T) {
+
+	// a Wednesday
+	from := time.Date(2021, 3, 3, 10, 17, 30, 0, time.UTC)
+
+	var scenarios = []struct {
+		spec     string
+		expected time.Time
+	}{
+		{"* * * * *", time.Date(2021, 3, 3, 10, 18, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2021, 3, 3, 10, 30, 0, 0, time.UTC)},
+		{"30 6 * * *", time.Date(2021, 3, 4, 6, 30, 0, 0, time.UTC)},
+		{"0 8 * * mon-fri", time.Date(2021, 3, 4, 8, 0, 0, 0, time.UTC)},
+		{"0 0 * * 0", time.Date(2021, 3, 7, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2021, 3, 7, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1,15 * *", time.Date(2021, 3, 15, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 * fri", time.Date(2021, 3, 5, 0, 0, 0, 0, time.UTC)},
+		{"0 12 29 feb *", time.Date(2024, 2, 29, 12, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2021, 3, 3, 11, 0, 0, 0, time.UTC)},
+		{"@every 90m", time.Date(2021, 3, 3, 11, 47, 30, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.spec, func(t *testing.T) {
+			sched, err := ParseSchedule(s.spec)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			got := sched.Next(from)
+			if !got.Equal(s.expected) {
+				t.Errorf("Wrong next time. Expected: %v, got: %v", s.expected, got)
+			}
+		})
+	}
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "*/0 * * * *", "5-1 * * * *", "@every 1s", "@every soon", "@often"} {
+		t.Run(spec, func(t *testing.T) {
+			if _, err := ParseSchedule(spec); err == nil {
+				t.Errorf("Expected an error for %q", spec)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package thinknum
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/mehiX/thinknumV2/internal/query"
+)
+
+const (
+	defaultStateFile     = ".thinknum_state.json"
+	defaultWatermarkName = filterColDateName
+)
+
+// State Remembers between runs the highest watermark value fetched by each incremental search
+type State struct {
+	mu   sync.Mutex
+	path string
+	// Watermarks The highest watermark value per search name
+	Watermarks map[string]string `json:"watermarks"`
+	// Seen The fingerprints of the rows with the watermark value per search name. The next run fetches these rows again,
+	// with the rows arriving late with the same value, and skips them
+	Seen map[string][]string `json:"seen,omitempty"`
+}
+
+// LoadState Loads the state from file. A missing file results in an empty state
+func LoadState(fn string) (*State, error) {
+
+	st := &State{
+		path:       fn,
+		Watermarks: make(map[string]string),
+		Seen:       make(map[string][]string),
+	}
+
+	b, err := ioutil.ReadFile(fn)
+	if os.IsNotExist(err) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, st); err != nil {
+		return nil, err
+	}
+
+	if st.Watermarks == nil {
+		st.Watermarks = make(map[string]string)
+	}
+	if st.Seen == nil {
+		st.Seen = make(map[string][]string)
+	}
+
+	return st, nil
+}
+
+// Watermark Returns the watermark recorded for a search or an empty string if the search never ran
+func (st *State) Watermark(search string) string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	return st.Watermarks[search]
+}
+
+// SeenRows Returns the fingerprints of the rows with the watermark value written by the previous runs of a search
+func (st *State) SeenRows(search string) []string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	return st.Seen[search]
+}
+
+// stateFileMu Serializes the updates of state files between all the clients of the process
+var stateFileMu sync.Mutex
+
+// SetWatermark Records a new watermark for a search, with the fingerprints of the rows written with that value, and saves the state to disk
+// The file is read again before writing, so updates made by other clients to other searches are kept
+func (st *State) SetWatermark(search, value string, seen []string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	stateFileMu.Lock()
+	defer stateFileMu.Unlock()
+
+	onDisk, err := LoadState(st.path)
+	if err != nil {
+		return err
+	}
+	for k, v := range onDisk.Watermarks {
+		st.Watermarks[k] = v
+	}
+	for k, v := range onDisk.Seen {
+		st.Seen[k] = v
+	}
+	st.Watermarks[search] = value
+	st.Seen[search] = seen
+	if len(seen) == 0 {
+		delete(st.Seen, search)
+	}
+
+	b, err := json.MarshalIndent(st, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return writeFile(st.path, b)
+}
+
+// watermarkColumn Returns the column used to track the progress of an incremental search
+func (s SearchDefinition) watermarkColumn() string {
+	if s.Watermark != "" {
+		return s.Watermark
+	}
+	return defaultWatermarkName
+}
+
+// afterWatermark Returns a copy of the search that only fetches the rows with a watermark from `wm` on.
+// The rows with the value `wm` are fetched again, so that the rows arriving late with that value are not missed, see skipSeen
+func (s SearchDefinition) afterWatermark(wm string) SearchDefinition {
+	ns := s.Clone()
+	ns.Request.Filters = append(ns.Request.Filters, query.Filter{
+		Column: s.watermarkColumn(),
+		Type:   ">=",
+		Value:  []string{wm},
+	})
+	return ns
+}
+
+// columnIndex Returns the index of the column `col` in the results, -1 if it is missing
+func columnIndex(d query.RowsItems, col string) int {
+	for i, f := range d.Fields {
+		if f.ID == col {
+			return i
+		}
+	}
+	return -1
+}
+
+// rowFingerprint Identifies a row by its content
+func rowFingerprint(r query.Row) string {
+	b, _ := json.Marshal(r)
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:8])
+}
+
+// watermarkRows Returns the fingerprints of the rows with the watermark value `wm` in the column `col`
+func watermarkRows(d query.RowsItems, col, wm string) []string {
+
+	idx := columnIndex(d, col)
+	if idx < 0 {
+		return nil
+	}
+
+	var fps []string
+	for _, r := range d.Rows {
+		if idx < len(r) && r[idx] != nil && watermarkEqual(toString(r[idx]), wm) {
+			fps = append(fps, rowFingerprint(r))
+		}
+	}
+
+	return fps
+}
+
+// skipSeen Removes the rows with the watermark value `wm` that were written by the previous run, see afterWatermark.
+// Returns the number of rows removed
+func skipSeen(d *query.RowsItems, col, wm string, seen []string) int {
+
+	idx := columnIndex(*d, col)
+	if idx < 0 || len(seen) == 0 {
+		return 0
+	}
+
+	// the same row can appear several times
+	count := make(map[string]int, len(seen))
+	for _, fp := range seen {
+		count[fp]++
+	}
+
+	rows := d.Rows[:0]
+	for _, r := range d.Rows {
+		if idx < len(r) && r[idx] != nil && watermarkEqual(toString(r[idx]), wm) {
+			if fp := rowFingerprint(r); count[fp] > 0 {
+				count[fp]--
+				continue
+			}
+		}
+		rows = append(rows, r)
+	}
+	removed := len(d.Rows) - len(rows)
+	d.Rows = rows
+
+	return removed
+}
+
+// maxWatermark Returns the highest value of the column `col` in the results, or an empty string if there are no values
+// Values are compared as numbers when both are numeric and as strings otherwise (ISO dates sort correctly as strings)
+func maxWatermark(d query.RowsItems, col string) string {
+
+	idx := columnIndex(d, col)
+	if idx < 0 {
+		return ""
+	}
+
+	var max string
+	for _, r := range d.Rows {
+		if idx >= len(r) || r[idx] == nil {
+			continue
+		}
+		v := toString(r[idx])
+		if max == "" || watermarkLess(max, v) {
+			max = v
+		}
+	}
+
+	return max
+}
+
+func watermarkLess(a, b string) bool {
+	fa, errA := strconv.ParseFloat(a, 64)
+	fb, errB := strconv.ParseFloat(b, 64)
+	if errA == nil && errB == nil {
+		return fa < fb
+	}
+	return a < b
+}
+
+func watermarkEqual(a, b string) bool {
+	return !watermarkLess(a, b) && !watermarkLess(b, a)
+}
+
+func toString(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	default:
+		b, _ := json.Marshal(t)
+		return string(b)
+	}
+}
@@ -0,0 +1,139 @@
+package thinknum
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/mehiX/thinknumV2/internal/query"
+)
+
+func TestWatermarkLess(t *testing.T) {
+
+	var scenarios = []struct {
+		a, b string
+		less bool
+	}{
+		{"2021-03-05", "2021-03-06", true},
+		{"2021-03-06", "2021-03-05", false},
+		{"2021-03-05", "2021-03-05", false},
+		{"2021-03-05", "2021-03-05T10:00:00", true},
+		{"9", "10", true},
+		{"10", "9", false},
+		{"1.5", "1.25", false},
+		{"-2", "1", true},
+		{"10", "9a", true},
+	}
+
+	for _, s := range scenarios {
+		if got := watermarkLess(s.a, s.b); got != s.less {
+			t.Errorf("watermarkLess(%q, %q): expected %v, got %v", s.a, s.b, s.less, got)
+		}
+	}
+}
+
+func TestMaxWatermark(t *testing.T) {
+
+	d := query.RowsItems{
+		Fields: []query.Field{{ID: "title"}, {ID: "as_of_date"}, {ID: "id"}},
+		Rows: []query.Row{
+			{"a", "2021-03-05", float64(9)},
+			{"b", "2021-03-07", float64(10)},
+			{"c", nil, float64(2)},
+			{"d", "2021-03-06"},
+		},
+	}
+
+	var scenarios = []struct {
+		col, expected string
+	}{
+		{"as_of_date", "2021-03-07"},
+		{"id", "10"},
+		{"country", ""},
+	}
+
+	for _, s := range scenarios {
+		if got := maxWatermark(d, s.col); got != s.expected {
+			t.Errorf("Wrong watermark for %s. Expected: %q, got: %q", s.col, s.expected, got)
+		}
+	}
+}
+
+func TestStateRoundTrip(t *testing.T) {
+
+	fn := filepath.Join(t.TempDir(), "state.json")
+
+	st, err := LoadState(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wm := st.Watermark("jobs"); wm != "" {
+		t.Errorf("Expected no watermark in a new state, got: %q", wm)
+	}
+
+	// another client records the watermark of another search in the meantime
+	other, _ := LoadState(fn)
+	if err := other.SetWatermark("news", "2021-01-01", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.SetWatermark("jobs", "2021-03-05", []string{"abc"}); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadState(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Watermark("jobs") != "2021-03-05" || loaded.Watermark("news") != "2021-01-01" {
+		t.Errorf("Wrong watermarks: %v", loaded.Watermarks)
+	}
+	if seen := loaded.SeenRows("jobs"); len(seen) != 1 || seen[0] != "abc" || len(loaded.SeenRows("news")) != 0 {
+		t.Errorf("Wrong rows seen: %v", loaded.Seen)
+	}
+}
+
+func TestAfterWatermark(t *testing.T) {
+
+	sd := SearchDefinition{Name: "jobs", Watermark: "id"}
+	sd.Request.Filters = []query.Filter{{Column: "country", Type: "=", Value: []string{"US"}}}
+
+	after := sd.afterWatermark("10")
+	if len(after.Request.Filters) != 2 || after.Request.Filters[1].Column != "id" || after.Request.Filters[1].Type != ">=" || after.Request.Filters[1].Value[0] != "10" {
+		t.Errorf("Wrong filters: %v", after.Request.Filters)
+	}
+	if len(sd.Request.Filters) != 1 {
+		t.Errorf("The original search was changed: %v", sd.Request.Filters)
+	}
+}
+
+func TestSkipSeen(t *testing.T) {
+
+	d := query.RowsItems{
+		Fields: []query.Field{{ID: "title"}, {ID: "as_of_date"}},
+		Rows: []query.Row{
+			{"a", "2021-03-05"},
+			{"b", "2021-03-05"},
+			{"c", "2021-03-06"},
+		},
+	}
+	seen := watermarkRows(d, "as_of_date", "2021-03-05")
+	if len(seen) != 2 {
+		t.Fatalf("Expected the 2 rows of the watermark, got: %v", seen)
+	}
+
+	// the next run fetches the rows of the watermark again, with a row arriving late
+	next := query.RowsItems{
+		Fields: d.Fields,
+		Rows: []query.Row{
+			{"a", "2021-03-05"},
+			{"b", "2021-03-05"},
+			{"late", "2021-03-05"},
+			{"c", "2021-03-06"},
+		},
+	}
+	if n := skipSeen(&next, "as_of_date", "2021-03-05", seen); n != 2 {
+		t.Errorf("Expected 2 rows to be skipped, got: %d", n)
+	}
+	if len(next.Rows) != 2 || next.Rows[0][0] != "late" || next.Rows[1][0] != "c" {
+		t.Errorf("Wrong rows: %v", next.Rows)
+	}
+}
@@ -3,12 +3,16 @@ package thinknum
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"time"
+
+	"github.com/mehiX/thinknumV2/internal/metrics"
+	"github.com/mehiX/thinknumV2/internal/query"
 )
 
 const (
@@ -30,13 +34,9 @@ type AuthToken struct {
 // The cache file should be treated as a secret and not checked into version control systems or shared with others.
 func (t *AuthToken) Cache(fn string) error {
 
-	f, err := os.OpenFile(fn, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	return json.NewEncoder(f).Encode(t)
+	return WriteFileAtomic(fn, 0600, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(t)
+	})
 }
 
 // IsExpired Checks if the token is expired
@@ -60,7 +60,7 @@ func GetToken(configAuth ConfigAuth) (*AuthToken, error) {
 	if err == nil {
 		if v, err := token.IsExpired(); !v && err == nil {
 			// token from file is still valid, we can use it
-			fmt.Println("Found cached valid token")
+			configAuth.log().Debug("Found cached valid token", "path", configAuth.TokenCachePath)
 			return token, nil
 		}
 	}
@@ -68,12 +68,13 @@ func GetToken(configAuth ConfigAuth) (*AuthToken, error) {
 	// token not present in local file or it is already expired
 	token, err = RequestNewToken(configAuth)
 	if err == nil {
+		configAuth.Metrics.Add(metrics.TokenRefreshes, 1)
 		// save token for later use
-		log.Println("Got new token. Try to cache it.")
+		configAuth.log().Info("Got new token", "expires", token.Expires)
 		if err := token.Cache(configAuth.TokenCachePath); err != nil {
-			log.Printf("Error saving token to file: %s. Error: %v\n", configAuth.TokenCachePath, err)
+			configAuth.log().Warn("Cannot cache token", "path", configAuth.TokenCachePath, "error", err)
 		} else {
-			log.Println("Token successfully cached")
+			configAuth.log().Debug("Token successfully cached", "path", configAuth.TokenCachePath)
 		}
 	}
 
@@ -91,19 +92,24 @@ func RequestNewToken(ca ConfigAuth) (*AuthToken, error) {
 	data.Set("client_secret", ca.ClientSecret)
 
 	authURL := fmt.Sprintf("https://%s%s", ca.Hostname, ca.AuthEndpoint)
+
+	start := time.Now()
 	resp, err := http.PostForm(authURL, data)
+	ca.Metrics.Observe(metrics.RequestDuration, time.Since(start).Seconds(), "endpoint", query.EndpointAuth)
 	if err != nil {
+		ca.Metrics.Add(metrics.Requests, 1, "endpoint", query.EndpointAuth, "status", "error")
 		return nil, err
 	}
+	ca.Metrics.Add(metrics.Requests, 1, "endpoint", query.EndpointAuth, "status", strconv.Itoa(resp.StatusCode))
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("auth api responded with code %d", resp.StatusCode)
+		return nil, query.NewAPIError(resp, query.EndpointAuth)
 	}
 	defer resp.Body.Close()
 
 	var a AuthToken
 	if err := json.NewDecoder(resp.Body).Decode(&a); err != nil {
-		return nil, err
+		return nil, &query.DecodeError{Endpoint: query.EndpointAuth, Err: err}
 	}
 
 	return &a, nil
@@ -0,0 +1,313 @@
+package thinknum
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultCatalogDir Directory where the dataset and ticker catalogs are stored, unless configured otherwise
+const DefaultCatalogDir = ".thinknum_catalog"
+
+// catalogMaxAge How long a stored tickers catalog is used to resolve the universes
+const catalogMaxAge = 24 * time.Hour
+
+// Universe The tickers of a search, added to the tickers of the request.
+// The tickers are read from a file, selected from the tickers of the dataset by sector, industry and country, or both.
+// When both are set only the tickers of the file that match the filters are kept
+type Universe struct {
+	// File A text file with one ticker per line, or a CSV file with a `ticker`, `symbol` or `name` column.
+	// Tickers are Thinknum IDs (`nasdaq:aapl`), plain symbols (`AAPL`) or company names (`Apple Inc.`)
+	File string `json:"file,omitempty"`
+	// Only keep the tickers in one of these sectors, industries and countries. Case insensitive
+	Sectors    []string `json:"sectors,omitempty"`
+	Industries []string `json:"industries,omitempty"`
+	Countries  []string `json:"countries,omitempty"`
+}
+
+func (u *Universe) hasFilters() bool {
+	return len(u.Sectors)+len(u.Industries)+len(u.Countries) > 0
+}
+
+// ResolveError Some tickers of a universe could not be resolved to a Thinknum ticker ID
+type ResolveError struct {
+	Dataset string
+	// Unknown The symbols that match no ticker of the dataset
+	Unknown []string
+	// Ambiguous The symbols that match several tickers, with the candidates
+	Ambiguous map[string][]string
+}
+
+func (e *ResolveError) Error() string {
+	var parts []string
+	if len(e.Unknown) > 0 {
+		parts = append(parts, fmt.Sprintf("unknown tickers: %s", strings.Join(e.Unknown, ", ")))
+	}
+
+	ambiguous := make([]string, 0, len(e.Ambiguous))
+	for s := range e.Ambiguous {
+		ambiguous = append(ambiguous, s)
+	}
+	sort.Strings(ambiguous)
+	for _, s := range ambiguous {
+		parts = append(parts, fmt.Sprintf("ambiguous ticker %q: one of %s", s, strings.Join(e.Ambiguous[s], ", ")))
+	}
+
+	return fmt.Sprintf("dataset %s: %s", e.Dataset, strings.Join(parts, "; "))
+}
+
+// ReadUniverseFile Reads the tickers of a universe file. See Universe.File
+func ReadUniverseFile(fn string) ([]string, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if strings.EqualFold(filepath.Ext(fn), ".csv") {
+		return readUniverseCSV(f)
+	}
+
+	var tickers []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		tickers = append(tickers, line)
+	}
+
+	return tickers, sc.Err()
+}
+
+// readUniverseCSV Reads the `ticker`, `symbol` or `name` column, in this order of preference, or the first column
+func readUniverseCSV(r io.Reader) ([]string, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	col := -1
+	for _, name := range []string{"ticker", "symbol", "name"} {
+		for i, h := range records[0] {
+			if col < 0 && strings.EqualFold(strings.TrimSpace(h), name) {
+				col = i
+			}
+		}
+	}
+	if col < 0 {
+		return nil, fmt.Errorf("no ticker, symbol or name column in the header: %s", strings.Join(records[0], ","))
+	}
+
+	var tickers []string
+	for _, rec := range records[1:] {
+		if col < len(rec) {
+			if t := strings.TrimSpace(rec[col]); t != "" {
+				tickers = append(tickers, t)
+			}
+		}
+	}
+
+	return tickers, nil
+}
+
+// ResolveTickers Resolves symbols to the IDs of the tickers of a catalog.
+// A symbol is a ticker ID (`nasdaq:aapl`), the part after the exchange (`AAPL`) or a display name (`Apple Inc.`), case insensitive.
+// Returns a *ResolveError listing all the unknown and ambiguous symbols
+func ResolveTickers(catalog Catalog, symbols []string) ([]string, error) {
+
+	byID := make(map[string]string)
+	bySymbol := make(map[string][]string)
+	byName := make(map[string][]string)
+	for _, e := range catalog.Entries {
+		id := strings.ToLower(e.ID)
+		byID[id] = e.ID
+		if i := strings.LastIndex(id, ":"); i >= 0 {
+			bySymbol[id[i+1:]] = append(bySymbol[id[i+1:]], e.ID)
+		}
+		if name := strings.ToLower(strings.TrimSpace(e.DisplayName)); name != "" {
+			byName[name] = append(byName[name], e.ID)
+		}
+	}
+
+	rerr := &ResolveError{Dataset: catalog.Dataset, Ambiguous: make(map[string][]string)}
+	var ids []string
+
+	for _, s := range symbols {
+		key := strings.ToLower(strings.TrimSpace(s))
+
+		if id, ok := byID[key]; ok {
+			ids = append(ids, id)
+			continue
+		}
+
+		candidates := bySymbol[key]
+		if len(candidates) == 0 {
+			candidates = byName[key]
+		}
+
+		switch len(candidates) {
+		case 0:
+			rerr.Unknown = append(rerr.Unknown, s)
+		case 1:
+			ids = append(ids, candidates[0])
+		default:
+			rerr.Ambiguous[s] = candidates
+		}
+	}
+
+	if len(rerr.Unknown) > 0 || len(rerr.Ambiguous) > 0 {
+		return nil, rerr
+	}
+
+	return ids, nil
+}
+
+// filterCatalog Returns the IDs of the tickers in the sectors, industries and countries of the universe
+func (u *Universe) filterCatalog(catalog Catalog) []string {
+
+	in := func(v string, list []string) bool {
+		if len(list) == 0 {
+			return true
+		}
+		for _, l := range list {
+			if strings.EqualFold(strings.TrimSpace(l), v) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var ids []string
+	for _, e := range catalog.Entries {
+		if in(e.Sector, u.Sectors) && in(e.Industry, u.Industries) && in(e.Country, u.Countries) {
+			ids = append(ids, e.ID)
+		}
+	}
+
+	return ids
+}
+
+// resolve Returns the ticker IDs of the universe in the catalog of the dataset
+func (u *Universe) resolve(catalog Catalog) ([]string, error) {
+
+	var ids []string
+	if u.File != "" {
+		symbols, err := ReadUniverseFile(u.File)
+		if err != nil {
+			return nil, fmt.Errorf("universe file: %w", err)
+		}
+		if ids, err = ResolveTickers(catalog, symbols); err != nil {
+			return nil, err
+		}
+	}
+
+	if !u.hasFilters() {
+		return ids, nil
+	}
+
+	selected := u.filterCatalog(catalog)
+	if u.File == "" {
+		return selected, nil
+	}
+
+	keep := make(map[string]bool, len(selected))
+	for _, id := range selected {
+		keep[id] = true
+	}
+	var kept []string
+	for _, id := range ids {
+		if keep[id] {
+			kept = append(kept, id)
+		}
+	}
+
+	return kept, nil
+}
+
+// tickerCatalogs The tickers catalogs used by a client, fetched once per dataset
+type tickerCatalogs struct {
+	mu       sync.Mutex
+	catalogs map[string]Catalog
+}
+
+// tickerCatalog Returns the tickers catalog of the dataset, from the catalog directory if it is recent enough
+func (c *client) tickerCatalog(dataset string) (Catalog, error) {
+	c.catalogs.mu.Lock()
+	defer c.catalogs.mu.Unlock()
+
+	if cat, ok := c.catalogs.catalogs[dataset]; ok {
+		return cat, nil
+	}
+
+	dir := c.CatalogDir
+	if dir == "" {
+		dir = DefaultCatalogDir
+	}
+	store := CatalogStore{Dir: dir, MaxAge: catalogMaxAge}
+
+	cat, _, err := store.Get(Catalog{Kind: CatalogTickers, Dataset: dataset}, false, func() (Catalog, error) {
+		tickers, err := c.Tickers(dataset)
+		if err != nil {
+			return Catalog{}, err
+		}
+		return NewTickerCatalog(dataset, tickers), nil
+	})
+	if err != nil {
+		return cat, err
+	}
+
+	if c.catalogs.catalogs == nil {
+		c.catalogs.catalogs = make(map[string]Catalog)
+	}
+	c.catalogs.catalogs[dataset] = cat
+
+	return cat, nil
+}
+
+// resolveUniverse Returns a copy of the search with the tickers of its universe added to the request
+func (c *client) resolveUniverse(sd SearchDefinition) (SearchDefinition, error) {
+
+	if sd.Universe == nil {
+		return sd, nil
+	}
+
+	cat, err := c.tickerCatalog(sd.DatasetID)
+	if err != nil {
+		return sd, fmt.Errorf("search %s: tickers of %s: %w", sd.Name, sd.DatasetID, err)
+	}
+
+	ids, err := sd.Universe.resolve(cat)
+	if err != nil {
+		return sd, fmt.Errorf("search %s: %w", sd.Name, err)
+	}
+	if len(ids) == 0 {
+		return sd, fmt.Errorf("search %s: the universe has no tickers", sd.Name)
+	}
+
+	ns := sd.Clone()
+	seen := make(map[string]bool)
+	var tickers []string
+	for _, t := range append(ns.Request.Tickers, ids...) {
+		if !seen[t] {
+			seen[t] = true
+			tickers = append(tickers, t)
+		}
+	}
+	ns.Request.Tickers = tickers
+
+	c.searchLog(sd).Info("Universe resolved", "tickers", len(ids))
+
+	return ns, nil
+}
@@ -0,0 +1,49 @@
+package thinknum
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestUniverse(t *testing.T) {
+
+	cat := Catalog{Kind: CatalogTickers, Dataset: "job_listings", Entries: []CatalogEntry{
+		{ID: "nasdaq:aapl", DisplayName: "Apple Inc.", Sector: "Technology", Country: "US"},
+		{ID: "nyse:ibm", DisplayName: "IBM", Sector: "Technology", Country: "US"},
+		{ID: "nyse:ge", DisplayName: "General Electric", Sector: "Industrials", Country: "US"},
+		{ID: "lse:ge", DisplayName: "GE Plc", Sector: "Industrials", Country: "GB"},
+	}}
+
+	ids, err := ResolveTickers(cat, []string{"NASDAQ:AAPL", "ibm", "General Electric"})
+	if err != nil || !reflect.DeepEqual(ids, []string{"nasdaq:aapl", "nyse:ibm", "nyse:ge"}) {
+		t.Errorf("Wrong resolution: %v, %v", ids, err)
+	}
+
+	_, err = ResolveTickers(cat, []string{"ge", "msft", "aapl"})
+	var rerr *ResolveError
+	if !errors.As(err, &rerr) {
+		t.Fatalf("Expected a *ResolveError, got: %v", err)
+	}
+	if !reflect.DeepEqual(rerr.Unknown, []string{"msft"}) || !reflect.DeepEqual(rerr.Ambiguous["ge"], []string{"nyse:ge", "lse:ge"}) {
+		t.Errorf("Wrong unknown or ambiguous tickers: %+v", rerr)
+	}
+
+	fn := filepath.Join(t.TempDir(), "universe.csv")
+	if err := ioutil.WriteFile(fn, []byte("Company,Symbol\nApple,AAPL\nGE,nyse:ge\nIBM,IBM\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	u := Universe{File: fn, Sectors: []string{"technology"}}
+	ids, err = u.resolve(cat)
+	if err != nil || !reflect.DeepEqual(ids, []string{"nasdaq:aapl", "nyse:ibm"}) {
+		t.Errorf("Expected the technology tickers of the file: %v, %v", ids, err)
+	}
+
+	u = Universe{Sectors: []string{"Industrials"}, Countries: []string{"gb"}}
+	if ids, _ := u.resolve(cat); !reflect.DeepEqual(ids, []string{"lse:ge"}) {
+		t.Errorf("Wrong tickers selected from the catalog: %v", ids)
+	}
+}
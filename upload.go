@@ -0,0 +1,122 @@
+package thinknum
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mehiX/thinknumV2/internal/metrics"
+	"github.com/mehiX/thinknumV2/internal/s3"
+)
+
+// UploadConfig Where the output files are uploaded after they are written, in an S3 compatible object storage (AWS S3, MinIO...).
+// Nothing is uploaded if Bucket is empty
+type UploadConfig struct {
+	Bucket string `json:"bucket"`
+	// Prefix Added to the keys of the objects, e.g. `thinknum/` for `s3://bucket/thinknum/out/jobs.csv`
+	Prefix string `json:"prefix"`
+	// Endpoint URL of the storage, e.g. `http://localhost:9000` for MinIO. Defaults to AWS S3 in the region
+	Endpoint string `json:"endpoint"`
+	// Region Defaults to AWS_REGION, then us-east-1
+	Region string `json:"region"`
+	// Credentials Default to the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment variables
+	AccessKeyID     string `json:"access_key_id"`
+	SecretAccessKey string `json:"secret_access_key"`
+	SessionToken    string `json:"session_token"`
+	// PathStyle Address the buckets in the path of the URLs instead of the host name, as MinIO expects
+	PathStyle bool `json:"path_style"`
+	// PartSizeMB Files larger than this are uploaded in parts. Defaults to 64, at least 5
+	PartSizeMB int64 `json:"part_size_mb"`
+	// BaseDir The keys are the paths of the files relative to this directory. Defaults to the working directory
+	BaseDir string `json:"base_dir"`
+}
+
+// Enabled Checks if a bucket is configured
+func (uc UploadConfig) Enabled() bool {
+	return uc.Bucket != ""
+}
+
+// client Returns the storage client, with the credentials from the environment if they are not configured
+func (uc UploadConfig) client() *s3.Client {
+
+	env := func(v, name string) string {
+		if v == "" {
+			return os.Getenv(name)
+		}
+		return v
+	}
+
+	return &s3.Client{
+		Endpoint:     uc.Endpoint,
+		Region:       env(uc.Region, "AWS_REGION"),
+		AccessKey:    env(uc.AccessKeyID, "AWS_ACCESS_KEY_ID"),
+		SecretKey:    env(uc.SecretAccessKey, "AWS_SECRET_ACCESS_KEY"),
+		SessionToken: env(uc.SessionToken, "AWS_SESSION_TOKEN"),
+		PathStyle:    uc.PathStyle,
+		PartSize:     uc.PartSizeMB * 1024 * 1024,
+	}
+}
+
+func (uc UploadConfig) validate() []error {
+
+	if !uc.Enabled() {
+		return nil
+	}
+
+	var errs []error
+	if uc.PartSizeMB != 0 && uc.PartSizeMB*1024*1024 < s3.MinPartSize {
+		errs = append(errs, fmt.Errorf("upload part_size_mb should be at least %d: %d", s3.MinPartSize/1024/1024, uc.PartSizeMB))
+	}
+	c := uc.client()
+	if c.AccessKey == "" || c.SecretKey == "" {
+		errs = append(errs, fmt.Errorf("upload credentials missing: set access_key_id and secret_access_key or AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY"))
+	}
+
+	return errs
+}
+
+// key Returns the key of the object for a file: its path relative to BaseDir, after the prefix
+func (uc UploadConfig) key(fn string) (string, error) {
+
+	base := uc.BaseDir
+	if base == "" {
+		base = "."
+	}
+	base, err := filepath.Abs(base)
+	if err != nil {
+		return "", err
+	}
+	abs, err := filepath.Abs(fn)
+	if err != nil {
+		return "", err
+	}
+
+	rel, err := filepath.Rel(base, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("upload: %s is outside of the base directory %s", fn, base)
+	}
+
+	return s3.Key(uc.Prefix, filepath.ToSlash(rel)), nil
+}
+
+// uploadResult Uploads the file of a saved result and records the URI of the object, or the upload error
+func (c *client) uploadResult(r *SaveResult) {
+
+	if !c.Upload.Enabled() || r.Error != nil || r.Path == "" {
+		return
+	}
+
+	key, err := c.Upload.key(r.Path)
+	if err == nil {
+		var obj s3.Object
+		obj, err = c.Upload.client().Upload(c.Upload.Bucket, key, r.Path)
+		if err == nil {
+			r.URI = obj.URI()
+			c.Metrics.Add(metrics.BytesUploaded, float64(obj.Size), "search", r.Search.Name, "type", r.Type)
+			return
+		}
+	}
+
+	r.UploadError = fmt.Errorf("upload %s: %w", r.Path, err)
+}
@@ -0,0 +1,110 @@
+package thinknum
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mehiX/thinknumV2/internal/query"
+)
+
+func TestUploadOutputs(t *testing.T) {
+
+	var mu sync.Mutex
+	uploaded := make(map[string]string)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		uploaded[r.URL.Path] = string(b)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	c := &client{Config: Config{CreateDirs: true, Upload: UploadConfig{
+		Bucket:          "research",
+		Prefix:          "thinknum",
+		Endpoint:        srv.URL,
+		AccessKeyID:     "key",
+		SecretAccessKey: "secret",
+		PathStyle:       true,
+		BaseDir:         dir,
+	}}}
+
+	sr := SearchResult{
+		Search: SearchDefinition{
+			Name:        "jobs",
+			DatasetID:   "job_listings",
+			OutputFile:  filepath.Join(dir, "out", "jobs"),
+			OutputTypes: []string{"csv"},
+		},
+		Started: time.Now(),
+	}
+	sr.Data.Fields = []query.Field{{ID: "title", DisplayName: "Title"}}
+	sr.Data.Rows = []query.Row{{"golang"}}
+	sr.Data.Total = 1
+
+	results, failed := c.writeOutputs(sr)
+	if failed || len(results) != 1 {
+		t.Fatalf("Expected one output, got: %v", results)
+	}
+	if results[0].URI != "s3://research/thinknum/out/jobs.csv" {
+		t.Errorf("Wrong URI: %s", results[0].URI)
+	}
+	if got := uploaded["/research/thinknum/out/jobs.csv"]; got != "Title\ngolang\n" {
+		t.Errorf("Wrong uploaded content: %q", got)
+	}
+
+	if _, err := c.Upload.key(filepath.Join(t.TempDir(), "jobs.csv")); err == nil {
+		t.Error("Expected an error for a file outside of the base directory")
+	}
+}
+
+// TestUploadFailure Checks that a failed upload is reported apart from the write, which succeeded
+func TestUploadFailure(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	c := &client{Config: Config{Upload: UploadConfig{
+		Bucket:          "research",
+		Endpoint:        srv.URL,
+		AccessKeyID:     "key",
+		SecretAccessKey: "secret",
+		PathStyle:       true,
+		BaseDir:         dir,
+	}}}
+
+	sr := SearchResult{
+		Search: SearchDefinition{
+			Name:        "jobs",
+			OutputFile:  filepath.Join(dir, "jobs"),
+			OutputTypes: []string{"csv"},
+		},
+		Started: time.Now(),
+	}
+	sr.Data.Fields = []query.Field{{ID: "title", DisplayName: "Title"}}
+	sr.Data.Rows = []query.Row{{"golang"}}
+
+	results, failed := c.writeOutputs(sr)
+	if failed || len(results) != 1 {
+		t.Fatalf("Expected the output to be saved, got: %v", results)
+	}
+	if r := results[0]; r.Error != nil || r.UploadError == nil || r.URI != "" || r.Path == "" {
+		t.Errorf("Expected only an upload error, got: %+v", r)
+	}
+
+	report := NewRunReport()
+	report.Add(sr, results)
+	// the file is saved, the upload error doesn't fail the search
+	if s := report.Searches[0]; s.Status != "ok" || s.Outputs[0].UploadError == "" || s.Outputs[0].Error != "" || report.UploadErrors != 1 {
+		t.Errorf("Expected the upload error in the report, got: %+v", s)
+	}
+}
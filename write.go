@@ -1,39 +1,469 @@
 package thinknum
 
 import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
-	"io/ioutil"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
 
 	"github.com/mehiX/thinknumV2/internal/query"
 )
 
-func writeToFile(srchRes SearchResult, ftype string) SaveResult {
+// checksum Returns the size and the SHA-256 checksum of the file
+func checksum(fn string) (int64, string, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", err
+	}
+
+	return n, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// saveOutputs Writes the results in all the output types of the search, in parallel, then commits the files.
+// The files of an output type are committed together, only if all of them were written. The rows of incremental searches are
+// only appended if all the outputs take them: otherwise the next run would append the same rows again to the outputs that did.
+// Returns the results for every file, with its size and checksum, and whether any of them failed
+func saveOutputs(sr SearchResult, createDirs bool) ([]SaveResult, bool) {
+
+	types := sr.Search.OutputTypes
+	results := make([][]SaveResult, len(types))
+	pending := make([][]pendingWrite, len(types))
+
+	var wg sync.WaitGroup
+	for i, t := range types {
+		wg.Add(1)
+		go func(i int, t string) {
+			defer wg.Done()
+			results[i], pending[i] = writeOutput(sr, t, createDirs)
+		}(i, t)
+	}
+	wg.Wait()
+
+	// the first error of every output type, and of the search
+	var searchErr error
+	typeErr := make([]error, len(types))
+	for i := range types {
+		for _, r := range results[i] {
+			if r.Error != nil && typeErr[i] == nil {
+				typeErr[i] = r.Error
+			}
+		}
+		if searchErr == nil {
+			searchErr = typeErr[i]
+		}
+	}
+
+	// the files of the output types that failed are not committed
+	causes := make([]error, len(types))
+	for i := range types {
+		causes[i] = typeErr[i]
+		if causes[i] == nil && sr.Watermark != "" {
+			causes[i] = searchErr
+		}
+		if causes[i] != nil {
+			for j := range results[i] {
+				if results[i][j].Error == nil && pending[i][j] != nil {
+					pending[i][j].rollback()
+					notSaved(&results[i][j], causes[i])
+				}
+			}
+		}
+	}
+
+	// the files of an output type are committed together, and all the types are for appends
+	if sr.Watermark != "" {
+		commitTypes(results, pending, causes, nil)
+	} else {
+		for i := range types {
+			commitTypes(results, pending, causes, []int{i})
+		}
+	}
+
+	layout := newOutputLayout(sr.Search, sr.Started)
+
+	saved := make([]SaveResult, 0)
+	failed := false
+	for i, t := range types {
+		first := len(saved)
+		typeFailed := false
+		written := make(map[string]bool)
+
+		for _, r := range results[i] {
+			if r.Path != "" {
+				written[filepath.Clean(r.Path)] = true
+			}
+			saved = append(saved, r)
+			typeFailed = typeFailed || r.Error != nil
+		}
+		failed = failed || typeFailed
+
+		// a rewrite replaces all the files of the previous run, the files with fewer slices or partitions now would look current
+		if !typeFailed && sr.Watermark == "" && len(written) > 0 {
+			ext := t + compressionExt(sr.Search.Compression)
+			if err := layout.removeStale(ext, sr.Search.Partition, written); err != nil && len(saved) > first {
+				saved[first].Error = fmt.Errorf("cannot remove the files of the previous run: %w", err)
+				failed = true
+			}
+		}
+	}
+
+	return saved, failed
+}
+
+// commitTypes Commits all the files of the output types `idx` (all the types if nil) that didn't fail, or none of them.
+// The results of the committed files get their size and checksum
+func commitTypes(results [][]SaveResult, pending [][]pendingWrite, causes []error, idx []int) {
 
-	var err error
+	if idx == nil {
+		for i := range results {
+			idx = append(idx, i)
+		}
+	}
+
+	type ref struct{ i, j int }
+	var refs []ref
+	var pws []pendingWrite
+	for _, i := range idx {
+		if causes[i] != nil {
+			continue
+		}
+		for j := range results[i] {
+			if pending[i][j] == nil {
+				continue
+			}
+			refs = append(refs, ref{i, j})
+			pws = append(pws, pending[i][j])
+		}
+	}
 
-	switch ftype {
-	case "json":
-		err = persistResultJSON(srchRes.Search.OutputFile, srchRes.RunResult)
-	case "csv":
-		err = persistResultCSV(srchRes.Search.OutputFile, srchRes.RunResult)
-	default:
-		err = errors.New("Type not supported")
+	if k, err := commitAll(pws); err != nil {
+		for n, rf := range refs {
+			r := &results[rf.i][rf.j]
+			if n == k {
+				r.Path, r.Error = "", err
+				continue
+			}
+			notSaved(r, err)
+		}
+		return
 	}
 
-	return SaveResult{
-		Search: srchRes.Search,
-		Type:   ftype,
-		Error:  err,
+	for n, rf := range refs {
+		r := &results[rf.i][rf.j]
+		r.Written = pws[n].written()
+		r.Size, r.SHA256, r.Error = checksum(r.Path)
 	}
 }
 
-func persistResultJSON(fn string, d query.RunResult) error {
-	filename := fn + ".json"
+// notSaved Marks the file as not saved because of the failure of another file
+func notSaved(r *SaveResult, cause error) {
+	r.Path = ""
+	r.Error = fmt.Errorf("not saved because another output failed: %w", cause)
+}
+
+// writeOutput Writes the results in the files of the output type: a single file, or one per partition and slice.
+// The directories of the files are created if `createDirs` is set or if they depend on the search, the run or the rows.
+// Returns one SaveResult per file, with its path, and the write of every file to commit, nil for the files that failed
+func writeOutput(srchRes SearchResult, ftype string, createDirs bool) ([]SaveResult, []pendingWrite) {
+
+	sd := srchRes.Search
+	failed := func(err error) ([]SaveResult, []pendingWrite) {
+		return []SaveResult{{Search: sd, Type: ftype, Error: err}}, []pendingWrite{nil}
+	}
+
+	if ftype != "json" && ftype != "csv" {
+		return failed(errors.New("Type not supported"))
+	}
+
+	parts, err := outputParts(sd, srchRes.Data)
+	if err != nil {
+		return failed(err)
+	}
+	if len(parts) == 0 {
+		// without a file written, removing the files of the previous run would leave no output at all
+		return []SaveResult{{Search: sd, Type: ftype, Skipped: "no rows to partition, the partitions of the previous run are kept"}}, []pendingWrite{nil}
+	}
+
+	layout := newOutputLayout(sd, srchRes.Started)
+	createDirs = createDirs || dynamicDirs(sd)
+
+	// incremental results are added to the results of the previous runs
+	appendResults := srchRes.Watermark != ""
+
+	ext := ftype + compressionExt(sd.Compression)
+
+	// the slices of incremental results follow the slices of the previous runs, the files never grow past RowsPerFile rows
+	next := make(map[string]int)
+	if appendResults && sd.RowsPerFile > 0 {
+		for _, part := range parts {
+			if _, ok := next[part.partition]; !ok {
+				next[part.partition] = layout.nextSlice(ext, part.partition)
+			}
+		}
+	}
+
+	results := make([]SaveResult, 0, len(parts))
+	pending := make([]pendingWrite, 0, len(parts))
+	for _, part := range parts {
+		res := SaveResult{Search: sd, Type: ftype}
+		fn := layout.path(ext, part.partition, next[part.partition]+part.slice)
+
+		d := srchRes.RunResult
+		if len(parts) > 1 || part.partition != "" {
+			d.Data.Rows = part.rows
+			d.Data.Total = len(part.rows)
+		}
+
+		var pw pendingWrite
+		if createDirs {
+			res.Error = os.MkdirAll(filepath.Dir(fn), 0777)
+		}
+		if res.Error == nil {
+			switch {
+			case ftype == "json" && appendResults:
+				pw, res.Error = appendResultJSON(fn, d, sd.Compression)
+			case ftype == "json":
+				pw, res.Error = persistResultJSON(fn, d, sd.Compression)
+			case appendResults:
+				pw, res.Error = appendResultCSV(fn, d, sd.Compression, sd.AppendInPlace)
+			default:
+				pw, res.Error = persistResultCSV(fn, d, sd.Compression)
+			}
+		}
+		if res.Error == nil {
+			res.Path = fn
+		}
+
+		results = append(results, res)
+		pending = append(pending, pw)
+	}
+
+	return results, pending
+}
 
-	str, err := json.Marshal(d)
+// persistResultJSON Writes the results to the JSON file `filename`, compressed with `compression`. The file is replaced on commit
+func persistResultJSON(filename string, d query.RunResult, compression string) (pendingWrite, error) {
+	sf, err := stageFile(filename, 0644, func(w io.Writer) error {
+		return writeCompressed(w, compression, func(w io.Writer) error {
+			return encodeResultJSON(w, d)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sf, nil
+}
+
+// encodeResultJSON Writes the same JSON as json.Marshal, one row at a time so that the encoded results are never all in memory
+func encodeResultJSON(out io.Writer, d query.RunResult) error {
+	return encodeResultJSONAfter(out, d, nil)
+}
+
+// encodeResultJSONAfter Writes the results like encodeResultJSON, with the rows given by `before` ahead of the rows of `d`.
+// `before` calls `add` with every row, already encoded
+func encodeResultJSONAfter(out io.Writer, d query.RunResult, before func(add func(json.RawMessage) error) error) error {
+
+	bw := bufio.NewWriter(out)
+
+	fields, err := json.Marshal(d.Data.Fields)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(bw, `{"Data":{"Total":%d,"Pages":%d,"Fields":%s,"Rows":`, d.Data.Total, d.Data.Pages, fields)
+
+	if d.Data.Rows == nil && before == nil {
+		bw.WriteString("null")
+	} else {
+		n := 0
+		add := func(r json.RawMessage) error {
+			if n > 0 {
+				bw.WriteByte(',')
+			}
+			n++
+			_, err := bw.Write(r)
+			return err
+		}
+
+		bw.WriteByte('[')
+		if before != nil {
+			if err := before(add); err != nil {
+				return err
+			}
+		}
+		for _, r := range d.Data.Rows {
+			b, err := json.Marshal(r)
+			if err != nil {
+				return err
+			}
+			if err := add(b); err != nil {
+				return err
+			}
+		}
+		bw.WriteByte(']')
+	}
+
+	e, err := json.Marshal(d.Error)
 	if err != nil {
 		return err
 	}
-	return ioutil.WriteFile(filename, str, 0666)
+	fmt.Fprintf(bw, `},"Error":%s}`, e)
+
+	return bw.Flush()
+}
+
+// appendResultJSON Adds the rows to the ones already saved in the JSON output file, which must have the same fields.
+// The file is rewritten through a temporary file, the rows already saved are copied one at a time, never all in memory
+func appendResultJSON(filename string, d query.RunResult, compression string) (pendingWrite, error) {
+
+	// the first pass reads the fields and counts the rows, which come first in the new file
+	var count int
+	prev, err := scanResultJSON(filename, func(json.RawMessage) error {
+		count++
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return persistResultJSON(filename, d, compression)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot append to %s: %w", filename, err)
+	}
+
+	fields := prev.Data.Fields
+	if len(fields) == 0 {
+		fields = d.Data.Fields
+	}
+	if was, is := fieldIDs(fields), fieldIDs(d.Data.Fields); !sameColumns(was, is) {
+		return nil, fmt.Errorf("cannot append to %s: the fields of the results changed from %q to %q, do a full refresh to rewrite it", filename, was, is)
+	}
+
+	res := d
+	res.Data.Fields = fields
+	res.Data.Total = count + len(d.Data.Rows)
+	res.Data.Pages = prev.Data.Pages + d.Data.Pages
+
+	sf, err := stageFile(filename, 0644, func(w io.Writer) error {
+		return writeCompressed(w, compression, func(w io.Writer) error {
+			return encodeResultJSONAfter(w, res, func(add func(json.RawMessage) error) error {
+				_, err := scanResultJSON(filename, add)
+				return err
+			})
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot append to %s: %w", filename, err)
+	}
+	return sf, nil
+}
+
+// scanResultJSON Reads a JSON output file token by token: calls `row` with every row, in order, and returns the other fields of the results
+func scanResultJSON(filename string, row func(json.RawMessage) error) (query.RunResult, error) {
+
+	var res query.RunResult
+
+	f, err := OpenOutput(filename)
+	if err != nil {
+		return res, err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	err = scanObject(dec, func(key string) error {
+		if key != "Data" {
+			return dec.Decode(&json.RawMessage{})
+		}
+		return scanObject(dec, func(key string) error {
+			switch key {
+			case "Total":
+				return dec.Decode(&res.Data.Total)
+			case "Pages":
+				return dec.Decode(&res.Data.Pages)
+			case "Fields":
+				return dec.Decode(&res.Data.Fields)
+			case "Rows":
+				return scanArray(dec, row)
+			}
+			return dec.Decode(&json.RawMessage{})
+		})
+	})
+
+	return res, err
+}
+
+// scanObject Reads an object, calling `value` to read the value of every key
+func scanObject(dec *json.Decoder, value func(key string) error) error {
+
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if err := value(t.(string)); err != nil {
+			return err
+		}
+	}
+	_, err := dec.Token()
+	return err
+}
+
+// scanArray Reads an array, or null, calling `item` with every item
+func scanArray(dec *json.Decoder, item func(json.RawMessage) error) error {
+
+	t, err := dec.Token()
+	if err != nil || t == nil {
+		return err
+	}
+	if t != json.Delim('[') {
+		return fmt.Errorf("expected an array, got %v", t)
+	}
+	for dec.More() {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return err
+		}
+		if err := item(raw); err != nil {
+			return err
+		}
+	}
+	_, err = dec.Token()
+	return err
+}
+
+func expectDelim(dec *json.Decoder, d json.Delim) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if t != d {
+		return fmt.Errorf("expected %v, got %v", d, t)
+	}
+	return nil
+}
+
+func fieldIDs(fields []query.Field) []string {
+	ids := make([]string, len(fields))
+	for i, f := range fields {
+		ids[i] = f.ID
+	}
+	return ids
+}
+
+// WriteJSON Writes the results as JSON, in the same format as the json output files
+func WriteJSON(out io.Writer, d query.RunResult) error {
+	return json.NewEncoder(out).Encode(d)
 }
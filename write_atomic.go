@@ -0,0 +1,336 @@
+package thinknum
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// pendingWrite A file written but not committed yet: commit makes the new content visible, rollback restores the previous content,
+// before or after commit, and finish discards the previous content once all the files committed.
+// The outputs of a search are committed together, so that they stay consistent with each other
+type pendingWrite interface {
+	commit() error
+	rollback()
+	finish()
+	// written Returns the number of bytes written: the whole file, or only the content appended
+	written() int64
+}
+
+// commitAll Commits all the writes, or none of them: when one fails, the writes already committed are rolled back.
+// Returns the index of the write that failed and its error, -1 if all of them committed
+func commitAll(pws []pendingWrite) (int, error) {
+
+	for i, pw := range pws {
+		if err := pw.commit(); err != nil {
+			for j := i - 1; j >= 0; j-- {
+				pws[j].rollback()
+			}
+			return i, err
+		}
+	}
+
+	for _, pw := range pws {
+		pw.finish()
+	}
+
+	return -1, nil
+}
+
+// countingWriter Counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// WriteFileAtomic Writes the file `fn` through a temporary file in the same directory, renamed to `fn` once `write` succeeds.
+// Readers of `fn` see either its previous content or the complete new content, never a half-written file.
+// An existing file keeps its permissions, a new file gets `perm` less the umask of the process, like with os.OpenFile
+func WriteFileAtomic(fn string, perm os.FileMode, write func(io.Writer) error) error {
+	sf, err := stageFile(fn, perm, write)
+	if err != nil {
+		return err
+	}
+	_, err = commitAll([]pendingWrite{sf})
+	return err
+}
+
+// stagedFile The new content of a file, in a temporary file renamed to the file on commit.
+// The previous content is kept in a hard link (a copy if links are not supported) until finish, so that rollback can restore it
+type stagedFile struct {
+	tmp  string
+	fn   string
+	size int64
+
+	committed bool
+	// backup The previous content of the file, empty if the file didn't exist
+	backup string
+}
+
+// stageFile Writes the new content of `fn` in a temporary file, see WriteFileAtomic
+func stageFile(fn string, perm os.FileMode, write func(io.Writer) error) (*stagedFile, error) {
+
+	tmp, err := createTemp(fn, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	cw := &countingWriter{w: tmp}
+	err = write(cw)
+	if info, serr := os.Stat(fn); err == nil && serr == nil {
+		err = tmp.Chmod(info.Mode().Perm())
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+
+	return &stagedFile{tmp: tmp.Name(), fn: fn, size: cw.n}, nil
+}
+
+func (sf *stagedFile) commit() error {
+
+	if _, err := os.Lstat(sf.fn); err == nil {
+		sf.backup = strings.TrimSuffix(sf.tmp, ".tmp") + ".bak"
+		if err := linkOrCopy(sf.fn, sf.backup); err != nil {
+			os.Remove(sf.tmp)
+			sf.backup = ""
+			return err
+		}
+	}
+
+	if err := os.Rename(sf.tmp, sf.fn); err != nil {
+		os.Remove(sf.tmp)
+		if sf.backup != "" {
+			os.Remove(sf.backup)
+		}
+		return err
+	}
+	sf.committed = true
+
+	return nil
+}
+
+func (sf *stagedFile) rollback() {
+	switch {
+	case !sf.committed:
+		os.Remove(sf.tmp)
+	case sf.backup != "":
+		os.Rename(sf.backup, sf.fn)
+	default:
+		os.Remove(sf.fn)
+	}
+}
+
+func (sf *stagedFile) finish() {
+	if sf.backup != "" {
+		os.Remove(sf.backup)
+	}
+	// the new content replaces any interrupted append
+	os.Remove(appendMarker(sf.fn))
+}
+
+// linkOrCopy Makes `dst` a hard link to `src`, or a copy of it where hard links are not supported
+func linkOrCopy(src, dst string) error {
+
+	if os.Link(src, dst) == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+
+	return err
+}
+
+func (sf *stagedFile) written() int64 {
+	return sf.size
+}
+
+// createTemp Creates a new temporary file for `fn`, next to it. Unlike ioutil.TempFile the file is created with `perm`, so the umask applies
+func createTemp(fn string, perm os.FileMode) (*os.File, error) {
+
+	dir, base := filepath.Split(fn)
+	b := make([]byte, 6)
+	for {
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		// hidden, so that tools watching the directory for new outputs skip it
+		f, err := os.OpenFile(filepath.Join(dir, "."+base+"."+hex.EncodeToString(b)+".tmp"), os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+		if !os.IsExist(err) {
+			return f, err
+		}
+	}
+}
+
+// writeFile Same as ioutil.WriteFile, but atomic. See WriteFileAtomic
+func writeFile(fn string, b []byte) error {
+	return WriteFileAtomic(fn, 0644, func(w io.Writer) error {
+		_, err := w.Write(b)
+		return err
+	})
+}
+
+// stageCopyAppend Writes a copy of the existing file `fn` followed by the new content in a temporary file, renamed to `fn` on commit
+// like with stageFile. The live file is never modified, but every append costs as much as the whole file. Only the new content is
+// counted as written
+func stageCopyAppend(fn string, write func(io.Writer) error) (*stagedFile, error) {
+
+	// the file may have been appended to in place before
+	if err := recoverAppend(fn); err != nil {
+		return nil, err
+	}
+
+	in, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	var n int64
+	sf, err := stageFile(fn, 0644, func(w io.Writer) error {
+		if _, err := io.Copy(w, in); err != nil {
+			return err
+		}
+		cw := &countingWriter{w: w}
+		err := write(cw)
+		n = cw.n
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	sf.size = n
+
+	return sf, nil
+}
+
+// appendMarker Returns the hidden file recording the size of `fn` before rows are appended to it
+func appendMarker(fn string) string {
+	dir, base := filepath.Split(fn)
+	return filepath.Join(dir, "."+base+".append")
+}
+
+// appendedFile Content appended in place to a file, cut off again on rollback
+type appendedFile struct {
+	fn   string
+	size int64
+	n    int64
+}
+
+// stageAppend Writes at the end of the existing file `fn`, in place, so that appending doesn't cost more as the file grows.
+// Only used when the search opts in with `append_in_place`, see stageCopyAppend for the default.
+// The size of the file is recorded in a marker file until the append is committed. An append rolled back is cut off right away,
+// one interrupted by a crash is cut off by the next write of the file, see recoverAppend. Readers can see the content while it is appended
+func stageAppend(fn string, write func(io.Writer) error) (*appendedFile, error) {
+
+	if err := recoverAppend(fn); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(fn, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	size, err := f.Seek(0, io.SeekEnd)
+	if err == nil {
+		err = writeFile(appendMarker(fn), []byte(strconv.FormatInt(size, 10)))
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	af := &appendedFile{fn: fn, size: size}
+
+	cw := &countingWriter{w: f}
+	err = write(cw)
+	af.n = cw.n
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		af.rollback()
+		return nil, err
+	}
+
+	return af, nil
+}
+
+// commit The content is already in place, the marker is kept until finish so that rollback still works
+func (af *appendedFile) commit() error {
+	return nil
+}
+
+func (af *appendedFile) finish() {
+	os.Remove(appendMarker(af.fn))
+}
+
+func (af *appendedFile) written() int64 {
+	return af.n
+}
+
+func (af *appendedFile) rollback() {
+	if os.Truncate(af.fn, af.size) == nil {
+		os.Remove(appendMarker(af.fn))
+	}
+}
+
+// recoverAppend Cuts off the end of a file left by an interrupted append, see stageAppend
+func recoverAppend(fn string) error {
+
+	b, err := ioutil.ReadFile(appendMarker(fn))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	size, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return fmt.Errorf("%s: invalid append marker: %w", fn, err)
+	}
+	if err := os.Truncate(fn, size); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return os.Remove(appendMarker(fn))
+}
@@ -0,0 +1,182 @@
+package thinknum
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "out.csv")
+
+	if err := writeFile(fn, []byte("a,b\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	// a failed write keeps the previous content and leaves no temporary file behind
+	failed := errors.New("connection lost")
+	err := WriteFileAtomic(fn, 0644, func(w io.Writer) error {
+		w.Write([]byte("half"))
+		return failed
+	})
+	if err != failed {
+		t.Errorf("Expected the error of the writer, got: %v", err)
+	}
+
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "a,b\n" {
+		t.Errorf("Wrong content after a failed write: %q", b)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("Expected only the output file, got: %d files", len(files))
+	}
+}
+
+func TestAppendFile(t *testing.T) {
+
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "out.csv")
+	if err := writeFile(fn, []byte("a,b\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	failed := errors.New("connection lost")
+	_, err := stageAppend(fn, func(w io.Writer) error {
+		w.Write([]byte("half"))
+		return failed
+	})
+	if err != failed {
+		t.Errorf("Expected the error of the writer, got: %v", err)
+	}
+	if b, _ := ioutil.ReadFile(fn); string(b) != "a,b\n" {
+		t.Errorf("Wrong content after a failed append: %q", b)
+	}
+
+	// a rolled back append is cut off
+	af, err := stageAppend(fn, func(w io.Writer) error {
+		_, err := w.Write([]byte("3,4\n"))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	af.rollback()
+	if b, _ := ioutil.ReadFile(fn); string(b) != "a,b\n" {
+		t.Errorf("Wrong content after a rollback: %q", b)
+	}
+
+	// an append interrupted by a crash leaves its marker, the next append cuts it off first
+	if err := ioutil.WriteFile(appendMarker(fn), []byte("4"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, _ := os.OpenFile(fn, os.O_WRONLY|os.O_APPEND, 0)
+	f.Write([]byte("1,"))
+	f.Close()
+
+	if err := commitWrite(stageAppend(fn, func(w io.Writer) error {
+		_, err := w.Write([]byte("1,2\n"))
+		return err
+	})); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(fn); string(b) != "a,b\n1,2\n" {
+		t.Errorf("Wrong content after recovering an append: %q", b)
+	}
+	if _, err := os.Stat(appendMarker(fn)); !os.IsNotExist(err) {
+		t.Errorf("Expected the marker to be removed, got: %v", err)
+	}
+}
+
+func TestCopyAppendFile(t *testing.T) {
+
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "out.csv")
+	if err := writeFile(fn, []byte("a,b\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	sf, err := stageCopyAppend(fn, func(w io.Writer) error {
+		_, err := w.Write([]byte("1,2\n"))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the live file only changes on commit
+	if b, _ := ioutil.ReadFile(fn); string(b) != "a,b\n" {
+		t.Errorf("The file was changed before the commit: %q", b)
+	}
+	if _, err := commitAll([]pendingWrite{sf}); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(fn); string(b) != "a,b\n1,2\n" {
+		t.Errorf("Wrong content after the append: %q", b)
+	}
+	if sf.written() != int64(len("1,2\n")) {
+		t.Errorf("Expected only the new content to be counted, got: %d", sf.written())
+	}
+}
+
+func TestCommitAll(t *testing.T) {
+
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.csv"), filepath.Join(dir, "sub", "b.csv")
+	if err := writeFile(a, []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Dir(b), 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	stage := func(fn string) pendingWrite {
+		sf, err := stageFile(fn, 0644, func(w io.Writer) error {
+			_, err := w.Write([]byte("new"))
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sf
+	}
+	pws := []pendingWrite{stage(a), stage(b)}
+
+	// the second file can't be committed anymore, the first one is restored
+	if err := os.RemoveAll(filepath.Dir(b)); err != nil {
+		t.Fatal(err)
+	}
+	if k, err := commitAll(pws); k != 1 || err == nil {
+		t.Fatalf("Expected the second commit to fail, got: %d, %v", k, err)
+	}
+	if content, _ := ioutil.ReadFile(a); string(content) != "old" {
+		t.Errorf("Expected the previous content to be restored, got: %q", content)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Errorf("Expected only the restored file, got: %d files", len(files))
+	}
+
+	// committed together
+	pws = []pendingWrite{stage(a), stage(filepath.Join(dir, "c.csv"))}
+	if _, err := commitAll(pws); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := ioutil.ReadFile(a); string(content) != "new" {
+		t.Errorf("Expected the new content, got: %q", content)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 2 {
+		t.Errorf("Expected no backup left, got: %d files", len(files))
+	}
+}
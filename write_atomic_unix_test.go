@@ -0,0 +1,40 @@
+//go:build !windows && !plan9 && !js
+// +build !windows,!plan9,!js
+
+package thinknum
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestWriteFileAtomicUmask(t *testing.T) {
+
+	old := syscall.Umask(077)
+	defer syscall.Umask(old)
+
+	fn := filepath.Join(t.TempDir(), "report.json")
+	if err := writeFile(fn, []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the umask to apply to a new file, got: %v", info.Mode().Perm())
+	}
+
+	// an existing file keeps its permissions
+	if err := os.Chmod(fn, 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFile(fn, []byte("[]")); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(fn); info.Mode().Perm() != 0640 {
+		t.Errorf("Expected the permissions to be kept, got: %v", info.Mode().Perm())
+	}
+}
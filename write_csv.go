@@ -1,33 +1,121 @@
 package thinknum
 
 import (
-	"bytes"
 	"encoding/csv"
 	"fmt"
 	"html"
-	"io/ioutil"
+	"io"
+	"os"
 	"strings"
 
 	"github.com/mehiX/thinknumV2/internal/query"
 	"github.com/microcosm-cc/bluemonday"
 )
 
-func persistResultCSV(fn string, rd query.RunResult) error {
+// persistResultCSV Writes the results to the CSV file `filename`, compressed with `compression`. The file is replaced on commit
+func persistResultCSV(filename string, rd query.RunResult, compression string) (pendingWrite, error) {
+	sf, err := stageFile(filename, 0644, func(w io.Writer) error {
+		return writeCompressed(w, compression, func(w io.Writer) error {
+			return WriteCSV(w, rd.Data)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sf, nil
+}
+
+// appendResultCSV Adds the rows at the end of the CSV output file. The header is only written for a new file.
+// The columns of the file must be the columns of the results, so that rows are never added under the wrong columns.
+// The file is rewritten with the new rows in a temporary copy, see stageCopyAppend. With `inPlace` the rows are appended to the file
+// itself, so the cost of an incremental run depends on the new rows only: see stageAppend for what happens when such an append fails.
+// For a compressed file the new rows are added as another compressed stream
+func appendResultCSV(filename string, rd query.RunResult, compression string, inPlace bool) (pendingWrite, error) {
 
-	filename := fn + ".csv"
+	header, err := readCSVHeader(filename)
+	if os.IsNotExist(err) || (err == nil && header == nil) {
+		return persistResultCSV(filename, rd, compression)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot append to %s: %w", filename, err)
+	}
+	if columns := headerForCSV(rd.Data.Fields); !sameColumns(header, columns) {
+		return nil, fmt.Errorf("cannot append to %s: the columns of the results changed from %q to %q, do a full refresh to rewrite it", filename, header, columns)
+	}
 
-	var buf bytes.Buffer
+	write := func(w io.Writer) error {
+		return writeCompressed(w, compression, func(w io.Writer) error {
+			return writeCSVRows(csv.NewWriter(w), rd.Data.Rows)
+		})
+	}
 
-	w := csv.NewWriter(&buf)
-	if err := w.Write(headerForCSV(rd.Data.Fields)); err != nil {
-		return err
+	if inPlace {
+		af, err := stageAppend(filename, write)
+		if err != nil {
+			return nil, err
+		}
+		return af, nil
+	}
+
+	sf, err := stageCopyAppend(filename, write)
+	if err != nil {
+		return nil, err
+	}
+	return sf, nil
+}
+
+// readCSVHeader Returns the first line of a CSV output file, nil if the file is empty
+func readCSVHeader(fn string) ([]string, error) {
+
+	f, err := OpenOutput(fn)
+	if err != nil {
+		return nil, err
 	}
+	defer f.Close()
 
-	if err := w.WriteAll(prepareForCSV(rd.Data.Rows)); err != nil {
+	header, err := csv.NewReader(f).Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+
+	return header, err
+}
+
+func sameColumns(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteCSV Writes the results as CSV, with a header line containing the display names of the fields
+func WriteCSV(out io.Writer, d query.RowsItems) error {
+
+	w := csv.NewWriter(out)
+	if err := w.Write(headerForCSV(d.Fields)); err != nil {
 		return err
 	}
 
-	return ioutil.WriteFile(filename, buf.Bytes(), 0666)
+	return writeCSVRows(w, d.Rows)
+}
+
+// writeCSVRows Writes the rows one at a time, so that the formatted rows are never all in memory
+func writeCSVRows(w *csv.Writer, rows []query.Row) error {
+
+	p := bluemonday.StrictPolicy()
+	for _, r := range rows {
+		if err := w.Write(formatForCSV(p, r)); err != nil {
+			return err
+		}
+	}
+
+	w.Flush()
+	return w.Error()
 }
 
 func headerForCSV(fields []query.Field) []string {
@@ -40,20 +128,28 @@ func headerForCSV(fields []query.Field) []string {
 	return header
 }
 
+// formatForCSV Formats the values of a row as text, without HTML and on a single line
+func formatForCSV(p *bluemonday.Policy, r query.Row) []string {
+	out := make([]string, len(r))
+
+	for j := range r {
+		s := fmt.Sprintf("%v", r[j])
+		s = html.UnescapeString(s)
+		s = p.Sanitize(s)
+		s = strings.ReplaceAll(s, "\n", " ")
+		out[j] = s
+	}
+
+	return out
+}
+
 func prepareForCSV(matrix []query.Row) [][]string {
 	out := make([][]string, len(matrix))
 
 	p := bluemonday.StrictPolicy()
 
 	for index, r := range matrix {
-		out[index] = make([]string, len(r))
-		for j := range r {
-			s := fmt.Sprintf("%v", matrix[index][j])
-			s = html.UnescapeString(s)
-			s = p.Sanitize(s)
-			s = strings.ReplaceAll(s, "\n", " ")
-			out[index][j] = s
-		}
+		out[index] = formatForCSV(p, r)
 	}
 
 	return out
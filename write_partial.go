@@ -0,0 +1,84 @@
+package thinknum
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/mehiX/thinknumV2/internal/query"
+)
+
+const (
+	partialSuffix = ".partial"
+
+	// PartialDiscard Drop the rows fetched by a search that fails midway (default)
+	PartialDiscard = "discard"
+	// PartialSave Save the rows fetched by a search that fails midway, see SavePartialResult
+	PartialSave = "save"
+)
+
+// MissingRanges Describes the records missing from the partial outputs of a failed search
+type MissingRanges struct {
+	Search  string        `json:"search"`
+	Dataset string        `json:"dataset"`
+	Created time.Time     `json:"created"`
+	Error   string        `json:"error"`
+	Fetched int           `json:"fetched"`
+	Total   int           `json:"total"`
+	Missing []query.Range `json:"missing"`
+	// The request sent to the API, to fetch the missing ranges with the same filters
+	Request query.Request `json:"request"`
+	// For searches split by tickers, the missing ranges of every batch, fetched with the request of the batch
+	Batches []MissingBatch `json:"batches,omitempty"`
+}
+
+// MissingBatch The records missing from the rows of one batch of a search split by tickers
+type MissingBatch struct {
+	Index   int      `json:"index"`
+	Tickers []string `json:"tickers"`
+	// Missing The missing ranges within the rows of the batch, with the offsets of the merged results. See Batch.Rows
+	Missing []query.Range `json:"missing"`
+	// Error The error of the batch. A batch that failed before its first page has no rows, all of them are missing
+	Error   string        `json:"error,omitempty"`
+	Request query.Request `json:"request"`
+}
+
+// writeMissing Writes the sidecar file describing the missing ranges of partial results
+func writeMissing(sr SearchResult) (string, error) {
+
+	m := MissingRanges{
+		Search:  sr.Search.Name,
+		Dataset: sr.Search.DatasetID,
+		Created: time.Now(),
+		Error:   sr.Error.Error(),
+		Fetched: len(sr.Data.Rows),
+		Total:   sr.Data.Total,
+		Missing: sr.Data.Missing(),
+		Request: sr.Request,
+	}
+
+	for _, b := range sr.Batches {
+		mb := MissingBatch{Index: b.Index, Tickers: b.Tickers, Missing: []query.Range{}, Error: b.Error, Request: b.Request}
+		// a missing range can run over the end of a batch into the next one
+		for _, r := range m.Missing {
+			if r.Start < b.Rows.Start {
+				r.Start = b.Rows.Start
+			}
+			if r.End > b.Rows.End {
+				r.End = b.Rows.End
+			}
+			if r.Start < r.End {
+				mb.Missing = append(mb.Missing, r)
+			}
+		}
+		if len(mb.Missing) > 0 || b.Error != "" {
+			m.Batches = append(m.Batches, mb)
+		}
+	}
+
+	b, err := json.MarshalIndent(m, "", "    ")
+	if err != nil {
+		return "", err
+	}
+
+	return writeSidecar(sr, "missing.json", b)
+}
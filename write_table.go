@@ -0,0 +1,41 @@
+package thinknum
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/mehiX/thinknumV2/internal/query"
+)
+
+// WriteTable Prints the results as an aligned text table, one row per line
+// Cell values are cleaned up the same way as for CSV outputs and truncated to `maxWidth` characters (0 means no limit)
+func WriteTable(w io.Writer, d query.RowsItems, maxWidth int) error {
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	rows := append([][]string{headerForCSV(d.Fields)}, prepareForCSV(d.Rows)...)
+
+	for _, r := range rows {
+		for j := range r {
+			r[j] = truncate(strings.ReplaceAll(r[j], "\t", " "), maxWidth)
+		}
+		if _, err := fmt.Fprintln(tw, strings.Join(r, "\t")); err != nil {
+			return err
+		}
+	}
+
+	return tw.Flush()
+}
+
+func truncate(s string, max int) string {
+	r := []rune(s)
+	if max <= 0 || len(r) <= max {
+		return s
+	}
+	if max <= 3 {
+		return string(r[:max])
+	}
+	return string(r[:max-3]) + "..."
+}
@@ -0,0 +1,147 @@
+package thinknum
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mehiX/thinknumV2/internal/query"
+)
+
+// commitWrite Commits a write if it succeeded
+func commitWrite(pw pendingWrite, err error) error {
+	if err != nil {
+		return err
+	}
+	_, err = commitAll([]pendingWrite{pw})
+	return err
+}
+
+func TestAppendResults(t *testing.T) {
+
+	dir := t.TempDir()
+
+	first := query.RunResult{}
+	first.Data.Fields = []query.Field{{ID: "title", DisplayName: "Title"}, {ID: "country", DisplayName: "Country"}}
+	first.Data.Rows = []query.Row{{"golang", "US"}}
+	second := first
+	second.Data.Rows = []query.Row{{"rust", "DE"}}
+
+	csvFile := filepath.Join(dir, "jobs.csv")
+	jsonFile := filepath.Join(dir, "jobs.json")
+	for _, r := range []query.RunResult{first, second} {
+		if err := commitWrite(appendResultCSV(csvFile, r, CompressionNone, false)); err != nil {
+			t.Fatal(err)
+		}
+		if err := commitWrite(appendResultJSON(jsonFile, r, CompressionNone)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if b, _ := ioutil.ReadFile(csvFile); string(b) != "Title,Country\ngolang,US\nrust,DE\n" {
+		t.Errorf("Wrong CSV: %q", b)
+	}
+	var res query.RunResult
+	b, _ := ioutil.ReadFile(jsonFile)
+	if err := json.Unmarshal(b, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Data.Total != 2 || len(res.Data.Rows) != 2 || res.Data.Rows[1][0] != "rust" {
+		t.Errorf("Wrong JSON: %s", b)
+	}
+
+	// rows are never appended under other columns
+	reordered := second
+	reordered.Data.Fields = []query.Field{first.Data.Fields[1], first.Data.Fields[0]}
+	if _, err := appendResultCSV(csvFile, reordered, CompressionNone, false); err == nil || !strings.Contains(err.Error(), "columns") {
+		t.Errorf("Expected an error for changed columns, got: %v", err)
+	}
+	if _, err := appendResultJSON(jsonFile, reordered, CompressionNone); err == nil || !strings.Contains(err.Error(), "fields") {
+		t.Errorf("Expected an error for changed fields, got: %v", err)
+	}
+}
+
+// TestSaveOutputsIncremental Checks that the rows of an incremental search are appended to none of the outputs when one of them fails
+func TestSaveOutputsIncremental(t *testing.T) {
+
+	dir := t.TempDir()
+
+	sr := SearchResult{
+		Search: SearchDefinition{
+			Name:        "jobs",
+			DatasetID:   "job_listings",
+			OutputFile:  filepath.Join(dir, "jobs"),
+			OutputTypes: []string{"csv", "json"},
+		},
+		Watermark: "2021-03-04",
+		Started:   time.Now(),
+	}
+	sr.Data.Fields = []query.Field{{ID: "title", DisplayName: "Title"}}
+	sr.Data.Rows = []query.Row{{"golang"}}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "jobs.csv"), []byte("Title\nrust\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "jobs.json"), []byte("not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	results, failed := saveOutputs(sr, false)
+	if !failed || len(results) != 2 {
+		t.Fatalf("Expected both outputs to fail, got: %v", results)
+	}
+	for _, r := range results {
+		if r.Error == nil || r.Path != "" {
+			t.Errorf("Expected %s to fail, got: %v", r.Type, r)
+		}
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(dir, "jobs.csv")); string(b) != "Title\nrust\n" {
+		t.Errorf("The CSV output was appended to: %q", b)
+	}
+
+	// once the JSON output is fixed, both get the rows
+	os.Remove(filepath.Join(dir, "jobs.json"))
+	results, failed = saveOutputs(sr, false)
+	if failed {
+		t.Fatal("Expected the outputs to be saved")
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(dir, "jobs.csv")); string(b) != "Title\nrust\ngolang\n" {
+		t.Errorf("Wrong CSV output: %q", b)
+	}
+
+	// only the appended rows are counted as written
+	if r := results[0]; r.Written != int64(len("golang\n")) || r.Size != int64(len("Title\nrust\ngolang\n")) {
+		t.Errorf("Wrong sizes. Expected %d bytes written to a file of %d, got: %d and %d", len("golang\n"), len("Title\nrust\ngolang\n"), r.Written, r.Size)
+	}
+}
+
+// TestAppendResultJSONOrder Checks that the rows of any JSON document with the results are appended to, whatever the order of its keys
+func TestAppendResultJSONOrder(t *testing.T) {
+
+	fn := filepath.Join(t.TempDir(), "jobs.json")
+	prev := `{"Error": null, "Data": {"Rows": [["golang", "US"], ["rust", "DE"]], "Pages": 2, "Fields": [{"id": "title"}, {"id": "country"}], "Total": 2}}`
+	if err := ioutil.WriteFile(fn, []byte(prev), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d := query.RunResult{}
+	d.Data.Fields = []query.Field{{ID: "title"}, {ID: "country"}}
+	d.Data.Rows = []query.Row{{"zig", "FR"}}
+	d.Data.Pages = 1
+	if err := commitWrite(appendResultJSON(fn, d, CompressionNone)); err != nil {
+		t.Fatal(err)
+	}
+
+	var res query.RunResult
+	b, _ := ioutil.ReadFile(fn)
+	if err := json.Unmarshal(b, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Data.Total != 3 || res.Data.Pages != 3 || len(res.Data.Rows) != 3 || res.Data.Rows[1][0] != "rust" || res.Data.Rows[2][0] != "zig" {
+		t.Errorf("Wrong JSON: %s", b)
+	}
+}